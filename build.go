@@ -0,0 +1,542 @@
+package dev
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/builder/dockerignore"
+	"github.com/docker/docker/pkg/fileutils"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/wish/dev/compose"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"github.com/wish/dev/state"
+)
+
+// BuildService is a compose service that is built from a Dockerfile.
+type BuildService struct {
+	Name string
+	// Image is the name of the image built, set only when the service
+	// names it.
+	Image string
+	// Context is the absolute path of the build context, or its URL when
+	// it is remote.
+	Context string
+	// Dockerfile is the absolute path of the Dockerfile, or its path in the
+	// context when the context is remote.
+	Dockerfile string
+	// Args are the build arguments, with those taken from the environment
+	// resolved.
+	Args   map[string]string
+	Target string
+	// Depends are the names of the services that build the images this
+	// service is built FROM.
+	Depends []string
+}
+
+// BuildDecision is whether a service needs to be built and why.
+type BuildDecision struct {
+	Service *BuildService
+	// Hash of the build context, Dockerfile and build arguments.
+	Hash   string
+	Build  bool
+	Reason string
+	// Built is whether the service was built successfully by RunBuild.
+	Built bool
+}
+
+// CreateBuildServices returns the services built from a Dockerfile in the
+// project's docker-compose files that are enabled by the profiles of the
+// project. Relative build contexts are relative to the directory of the first
+// docker-compose file. The services are sorted by name.
+func CreateBuildServices(devConfig *c.Dev, project *c.Project) ([]*BuildService, error) {
+	services := []*BuildService{}
+	composeConfig, err := parseCompose(devConfig, project)
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range enabledServices(composeConfig, project) {
+		if service.Build.Context == "" {
+			continue
+		}
+		buildService := &BuildService{
+			Name:       service.Name,
+			Image:      service.Image,
+			Context:    service.Build.Context,
+			Dockerfile: service.Build.Dockerfile,
+			Target:     service.Build.Target,
+			Args:       make(map[string]string),
+		}
+		for name, value := range service.Build.Args {
+			if value == nil {
+				buildService.Args[name] = os.Getenv(name)
+			} else {
+				buildService.Args[name] = *value
+			}
+		}
+		services = append(services, buildService)
+	}
+
+	for _, service := range services {
+		if service.Dockerfile == "" {
+			service.Dockerfile = "Dockerfile"
+		}
+		if !filepath.IsAbs(service.Dockerfile) && !service.Remote() {
+			service.Dockerfile = filepath.Join(service.Context, service.Dockerfile)
+		}
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	if err := resolveBuildDependencies(devConfig.GetFs(), devConfig.ImagePrefix, services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// Remote returns whether the build context of the service is a git repository
+// or a URL, which compose hands to the builder without dev reading it.
+func (s *BuildService) Remote() bool {
+	return compose.RemoteContext(s.Context)
+}
+
+// dockerfileBaseImages returns the images the stages of the Dockerfile are
+// built FROM, leaving out earlier stages used as the base of later ones.
+func dockerfileBaseImages(fs afero.Fs, filename string) ([]string, error) {
+	content, err := afero.ReadFile(fs, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	images := []string{}
+	stages := make(map[string]bool)
+	lines := strings.Split(strings.Replace(string(content), "\\\n", " ", -1), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		image := fields[0]
+		if !stages[strings.ToLower(image)] && !SliceContainsString(images, image) {
+			images = append(images, image)
+		}
+		if len(fields) == 3 && strings.EqualFold(fields[1], "AS") {
+			stages[strings.ToLower(fields[2])] = true
+		}
+	}
+	return images, nil
+}
+
+// resolveBuildDependencies sets the services each service depends on, those
+// that build an image its Dockerfile is built FROM.
+func resolveBuildDependencies(fs afero.Fs, imagePrefix string, services []*BuildService) error {
+	builtBy := make(map[string]string)
+	for _, service := range services {
+		for _, name := range serviceImageNames(imagePrefix, service) {
+			builtBy[strings.TrimSuffix(name, ":latest")] = service.Name
+		}
+	}
+
+	for _, service := range services {
+		service.Depends = []string{}
+		if service.Remote() {
+			continue
+		}
+		images, err := dockerfileBaseImages(fs, service.Dockerfile)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read Dockerfile of %s", service.Name)
+		}
+		for _, image := range images {
+			dependency, ok := builtBy[strings.TrimSuffix(image, ":latest")]
+			if ok && dependency != service.Name && !SliceContainsString(service.Depends, dependency) {
+				service.Depends = append(service.Depends, dependency)
+			}
+		}
+	}
+	return nil
+}
+
+// orderBuildServices returns the services in an order they can be built in,
+// each after the services it depends on. Only the selected services and their
+// dependencies are returned, every service if none are selected.
+func orderBuildServices(services []*BuildService, selected []string) ([]*BuildService, error) {
+	serviceMap := make(map[string]*BuildService)
+	for _, service := range services {
+		serviceMap[service.Name] = service
+	}
+	if len(selected) == 0 {
+		for _, service := range services {
+			selected = append(selected, service.Name)
+		}
+	}
+
+	ordered := []*BuildService{}
+	visited := make(map[string]bool)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		service, ok := serviceMap[name]
+		if !ok {
+			return errors.Errorf("%s is not a service built from a Dockerfile", name)
+		}
+		if SliceContainsString(path, name) {
+			return errors.Errorf("services are built from each other's images: %s",
+				strings.Join(append(path, name), " -> "))
+		}
+		if visited[name] {
+			return nil
+		}
+		for _, dependency := range service.Depends {
+			if err := visit(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+		visited[name] = true
+		ordered = append(ordered, service)
+		return nil
+	}
+	for _, name := range selected {
+		if err := visit(name, []string{}); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// readDockerignore returns the exclusion patterns of the .dockerignore file
+// of the build context, if there is one.
+func readDockerignore(fs afero.Fs, context string) ([]string, error) {
+	f, err := fs.Open(filepath.Join(context, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return dockerignore.ReadAll(f)
+}
+
+// hashFile writes the contents of the file to the hash.
+func hashFile(fs afero.Fs, filename string, h io.Writer) error {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// HashBuildService returns a hash of everything that determines the image
+// built for the service: the files in its build context not excluded by
+// .dockerignore, its Dockerfile, its build arguments and its target. Remote
+// build contexts cannot be hashed.
+func HashBuildService(fs afero.Fs, service *BuildService) (string, error) {
+	if service.Remote() {
+		return "", errors.Errorf("build context of %s is remote: %s", service.Name, service.Context)
+	}
+	h := sha256.New()
+
+	excludes, err := readDockerignore(fs, service.Context)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read .dockerignore of %s", service.Name)
+	}
+	matcher, err := fileutils.NewPatternMatcher(excludes)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid .dockerignore of %s", service.Name)
+	}
+
+	err = afero.Walk(fs, service.Context, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(service.Context, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		excluded, err := matcher.Matches(rel)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			// exceptions may re-include files below an excluded
+			// directory, in which case it must be walked
+			if info.IsDir() && !matcher.Exclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		fmt.Fprintf(h, "%s\x00%s\x00", rel, info.Mode())
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			if _, ok := fs.(*afero.OsFs); ok {
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(h, "%s\x00", target)
+			}
+		case info.Mode().IsRegular():
+			if err := hashFile(fs, path, h); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read build context of %s", service.Name)
+	}
+
+	// the Dockerfile is used even when excluded from the context, or when
+	// it is outside of it
+	fmt.Fprint(h, "\x00Dockerfile\x00")
+	if err := hashFile(fs, service.Dockerfile, h); err != nil {
+		return "", errors.Wrapf(err, "failed to read Dockerfile of %s", service.Name)
+	}
+
+	names := make([]string, 0, len(service.Args))
+	for name := range service.Args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "\x00arg\x00%s=%s", name, service.Args[name])
+	}
+	fmt.Fprintf(h, "\x00target\x00%s", service.Target)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// serviceImageNames returns the names compose may have given the image built
+// for the service, the name configured for it if any, otherwise the image
+// prefix and service name joined as compose v2, or v1, does.
+func serviceImageNames(imagePrefix string, service *BuildService) []string {
+	if service.Image != "" {
+		return []string{service.Image}
+	}
+	return []string{imagePrefix + "-" + service.Name, imagePrefix + "_" + service.Name}
+}
+
+// serviceImageID returns the id of the image built for the service or an
+// empty string if it does not exist.
+func serviceImageID(engine docker.Engine, imagePrefix string, service *BuildService) string {
+	for _, name := range serviceImageNames(imagePrefix, service) {
+		if image, err := engine.ImageInspect(name); err == nil {
+			return image.ID
+		}
+	}
+	return ""
+}
+
+// PlanBuild decides which of the selected services of the project, and the
+// services they depend on, need to be built. Every service is considered if
+// none are selected. A service is built when forced, when it has not been
+// built by dev before, when its build context, Dockerfile or build arguments
+// changed since it was last built, when the image built then no longer exists
+// or when a service it depends on is built. Services with a remote build
+// context, which cannot be hashed, are always built. The decisions are returned in the
+// order the services must be built in.
+func PlanBuild(appConfig *c.Dev, project *c.Project, selected []string, force bool) ([]*BuildDecision, error) {
+	services, err := CreateBuildServices(appConfig, project)
+	if err != nil {
+		return nil, err
+	}
+	services, err = orderBuildServices(services, selected)
+	if err != nil {
+		return nil, err
+	}
+	localState, err := state.Load(appConfig.GetFs(), appConfig.ImagePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := []*BuildDecision{}
+	built := []string{}
+	for _, service := range services {
+		hash := ""
+		if !service.Remote() {
+			hash, err = HashBuildService(appConfig.GetFs(), service)
+			if err != nil {
+				return nil, err
+			}
+		}
+		decision := &BuildDecision{Service: service, Hash: hash, Build: true}
+		decisions = append(decisions, decision)
+
+		record, recorded := localState.Builds[service.Name]
+		rebuiltDependency := ""
+		for _, dependency := range service.Depends {
+			if SliceContainsString(built, dependency) {
+				rebuiltDependency = dependency
+				break
+			}
+		}
+		switch {
+		case force:
+			decision.Reason = "forced"
+		case service.Remote():
+			decision.Reason = "build context is remote"
+		case !recorded:
+			decision.Reason = "not built by dev before"
+		case record.Hash != hash:
+			decision.Reason = "build context, Dockerfile or build args changed"
+		case rebuiltDependency != "":
+			decision.Reason = fmt.Sprintf("image built by %s is rebuilt", rebuiltDependency)
+		default:
+			imageID := serviceImageID(appConfig.GetEngine(), appConfig.ImagePrefix, service)
+			if imageID == "" {
+				decision.Reason = "image does not exist"
+			} else if imageID != record.ImageID {
+				decision.Reason = "image was replaced since it was built"
+			} else {
+				decision.Build = false
+				decision.Reason = fmt.Sprintf("unchanged since built %s ago", since(&record.Time))
+			}
+		}
+		if decision.Build {
+			built = append(built, service.Name)
+		}
+	}
+	return decisions, nil
+}
+
+// buildStages groups the services that need to be built into the stages they
+// are built in. Each service is built in the stage after the last of those of
+// the services it depends on, so services within a stage are independent of
+// each other, and sorted by name.
+func buildStages(decisions []*BuildDecision) [][]string {
+	stages := [][]string{}
+	stageOf := make(map[string]int)
+	for _, decision := range decisions {
+		if !decision.Build {
+			continue
+		}
+		stage := 0
+		for _, dependency := range decision.Service.Depends {
+			if dependencyStage, ok := stageOf[dependency]; ok && dependencyStage >= stage {
+				stage = dependencyStage + 1
+			}
+		}
+		stageOf[decision.Service.Name] = stage
+		if stage == len(stages) {
+			stages = append(stages, []string{})
+		}
+		stages[stage] = append(stages[stage], decision.Service.Name)
+	}
+	for _, stage := range stages {
+		sort.Strings(stage)
+	}
+	return stages
+}
+
+// RunBuild builds the services that need to be built with the build function,
+// in the order of PlanBuild's decisions. Each stage of services is built in
+// parallel once the previous stage has been built. A failed build stops the
+// build after the current stage.
+func RunBuild(decisions []*BuildDecision, build func(service *BuildService) error) error {
+	decisionOf := make(map[string]*BuildDecision)
+	for _, decision := range decisions {
+		decisionOf[decision.Service.Name] = decision
+	}
+
+	for _, stage := range buildStages(decisions) {
+		errs := make([]error, len(stage))
+		var wg sync.WaitGroup
+		for i, service := range stage {
+			wg.Add(1)
+			go func(i int, service string) {
+				defer wg.Done()
+				errs[i] = build(decisionOf[service].Service)
+			}(i, service)
+		}
+		wg.Wait()
+
+		var buildErr error
+		for i, err := range errs {
+			if err != nil {
+				if buildErr == nil {
+					buildErr = errors.Wrapf(err, "failed to build %s", stage[i])
+				}
+				continue
+			}
+			decisionOf[stage[i]].Built = true
+		}
+		if buildErr != nil {
+			return buildErr
+		}
+	}
+	return nil
+}
+
+// ServicesToBuild returns the names of the services that need to be built.
+func ServicesToBuild(decisions []*BuildDecision) []string {
+	services := []string{}
+	for _, decision := range decisions {
+		if decision.Build {
+			services = append(services, decision.Service.Name)
+		}
+	}
+	return services
+}
+
+// PrintBuildPlan writes whether each service is built and why.
+func PrintBuildPlan(out io.Writer, decisions []*BuildDecision) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tBUILD\tREASON")
+	for _, decision := range decisions {
+		fmt.Fprintf(w, "%s\t%t\t%s\n", decision.Service.Name, decision.Build, decision.Reason)
+	}
+	w.Flush()
+}
+
+// RecordBuilds records the hash and image of each service built successfully
+// so that they are not built again until they change. The images replaced are
+// kept in the record for clean.
+func RecordBuilds(appConfig *c.Dev, decisions []*BuildDecision) error {
+	localState, err := state.Load(appConfig.GetFs(), appConfig.ImagePrefix)
+	if err != nil {
+		return err
+	}
+	for _, decision := range decisions {
+		if !decision.Built {
+			continue
+		}
+		imageID := serviceImageID(appConfig.GetEngine(), appConfig.ImagePrefix, decision.Service)
+		if imageID == "" {
+			// the builder did not produce the image compose expects,
+			// so there is nothing to compare with next time.
+			delete(localState.Builds, decision.Service.Name)
+			continue
+		}
+		build := &state.Build{
+			Hash:    decision.Hash,
+			ImageID: imageID,
+			Time:    time.Now(),
+		}
+		if last, ok := localState.Builds[decision.Service.Name]; ok {
+			build.Previous = last.Previous
+			if last.ImageID != imageID {
+				build.Previous = append([]*state.Image{{ID: last.ImageID, Time: last.Time}}, last.Previous...)
+			}
+		}
+		localState.Builds[decision.Service.Name] = build
+	}
+	return localState.Save()
+}
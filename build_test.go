@@ -0,0 +1,303 @@
+package dev
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/spf13/afero"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"github.com/wish/dev/state"
+)
+
+func writeFiles(t *testing.T, fs afero.Fs, files map[string]string) {
+	for filename, content := range files {
+		if err := afero.WriteFile(fs, filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHashBuildService(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writeFiles(t, fs, map[string]string{
+		"/src/app/Dockerfile":      "FROM alpine\nCOPY . /app\n",
+		"/src/app/main.go":         "package main\n",
+		"/src/app/.dockerignore":   "logs\n*.tmp\n",
+		"/src/app/logs/server.log": "started\n",
+		"/src/app/build.tmp":       "partial\n",
+	})
+	service := &BuildService{
+		Name:       "app",
+		Context:    "/src/app",
+		Dockerfile: "/src/app/Dockerfile",
+		Args:       map[string]string{"VERSION": "1"},
+	}
+	hash, err := HashBuildService(fs, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name    string
+		Change  func()
+		Changed bool
+	}{
+		{"ignored file", func() { writeFiles(t, fs, map[string]string{"/src/app/logs/server.log": "stopped\n"}) }, false},
+		{"ignored pattern", func() { writeFiles(t, fs, map[string]string{"/src/app/other.tmp": "x"}) }, false},
+		{"source file", func() { writeFiles(t, fs, map[string]string{"/src/app/main.go": "package app\n"}) }, true},
+		{"new file", func() { writeFiles(t, fs, map[string]string{"/src/app/util.go": "package main\n"}) }, true},
+		{"Dockerfile", func() { writeFiles(t, fs, map[string]string{"/src/app/Dockerfile": "FROM debian\n"}) }, true},
+		{"build arg", func() { service.Args["VERSION"] = "2" }, true},
+		{"target", func() { service.Target = "dev" }, true},
+	}
+	for _, test := range tests {
+		test.Change()
+		got, err := HashBuildService(fs, service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (got != hash) != test.Changed {
+			t.Errorf("%s: expected hash changed to be %t", test.Name, test.Changed)
+		}
+		hash = got
+	}
+}
+
+func TestPlanBuild(t *testing.T) {
+	engine := docker.NewFakeEngine()
+	appConfig := c.NewConfig()
+	appConfig.ImagePrefix = "dev"
+	appConfig.SetEngine(engine)
+	appConfig.SetFs(afero.NewMemMapFs())
+	writeFiles(t, appConfig.GetFs(), map[string]string{
+		"/home/test/docker-compose.yml": `version: "3.6"
+services:
+  app:
+    build: ./app
+  db:
+    image: postgres
+`,
+		"/home/test/app/Dockerfile": "FROM alpine\n",
+	})
+	project := &c.Project{
+		Name:                   "app",
+		Directory:              "/home/test",
+		DockerComposeFilenames: []string{"/home/test/docker-compose.yml"},
+	}
+
+	plan := func(force bool) *BuildDecision {
+		decisions, err := PlanBuild(appConfig, project, nil, force)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decisions) != 1 || decisions[0].Service.Name != "app" {
+			t.Fatalf("Expected a decision for the app service only but got %+v", decisions)
+		}
+		return decisions[0]
+	}
+	expect := func(decision *BuildDecision, build bool, reason string) {
+		t.Helper()
+		if decision.Build != build || (reason != "" && decision.Reason != reason) {
+			t.Errorf("Expected build %t because '%s' but got %t because '%s'", build, reason,
+				decision.Build, decision.Reason)
+		}
+	}
+
+	decision := plan(false)
+	expect(decision, true, "not built by dev before")
+	if decision.Service.Context != "/home/test/app" || decision.Service.Dockerfile != "/home/test/app/Dockerfile" {
+		t.Errorf("Unexpected build context %s and Dockerfile %s", decision.Service.Context, decision.Service.Dockerfile)
+	}
+
+	engine.ImageDetails["dev-app"] = types.ImageInspect{ID: "sha256:1"}
+	decision.Built = true
+	if err := RecordBuilds(appConfig, []*BuildDecision{decision}); err != nil {
+		t.Fatal(err)
+	}
+	expect(plan(false), false, "")
+	expect(plan(true), true, "forced")
+
+	engine.ImageDetails["dev-app"] = types.ImageInspect{ID: "sha256:2"}
+	expect(plan(false), true, "image was replaced since it was built")
+
+	delete(engine.ImageDetails, "dev-app")
+	expect(plan(false), true, "image does not exist")
+
+	engine.ImageDetails["dev-app"] = types.ImageInspect{ID: "sha256:1"}
+	writeFiles(t, appConfig.GetFs(), map[string]string{"/home/test/app/Dockerfile": "FROM debian\n"})
+	expect(plan(false), true, "build context, Dockerfile or build args changed")
+
+	// the image replaced by a build is remembered for clean
+	engine.ImageDetails["dev-app"] = types.ImageInspect{ID: "sha256:3"}
+	if err := RecordBuilds(appConfig, []*BuildDecision{decision}); err != nil {
+		t.Fatal(err)
+	}
+	localState, err := state.Load(appConfig.GetFs(), "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous := localState.Builds["app"].Previous; len(previous) != 1 || previous[0].ID != "sha256:1" {
+		t.Errorf("Expected the previous image sha256:1 to be remembered but got %+v", previous)
+	}
+}
+
+func TestPlanBuildRemoteContext(t *testing.T) {
+	project := &c.Project{
+		Name:                   "app",
+		Directory:              "/home/test",
+		DockerComposeFilenames: []string{"/home/test/docker-compose.yml"},
+	}
+	appConfig, _ := testConfig(t, map[string]string{
+		"/home/test/docker-compose.yml": `version: "3.6"
+services:
+  app:
+    build: ./app
+  proxy:
+    build:
+      context: https://github.com/example/proxy.git#main
+      dockerfile: build/Dockerfile
+  tools:
+    build: git@github.com:example/tools.git
+`,
+		"/home/test/app/Dockerfile": "FROM alpine\n",
+	}, project)
+
+	decisions, err := PlanBuild(appConfig, project, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := map[string]string{}
+	for _, decision := range decisions {
+		reasons[decision.Service.Name] = decision.Reason
+		decision.Built = true
+	}
+	expected := map[string]string{
+		"app":   "not built by dev before",
+		"proxy": "build context is remote",
+		"tools": "build context is remote",
+	}
+	if !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("Expected reasons %v but got %v", expected, reasons)
+	}
+	if proxy := decisions[1].Service; proxy.Dockerfile != "build/Dockerfile" {
+		t.Errorf("Expected the Dockerfile to stay in the remote context but got %s", proxy.Dockerfile)
+	}
+
+	// remote contexts are rebuilt even when recorded
+	if err := RecordBuilds(appConfig, decisions); err != nil {
+		t.Fatal(err)
+	}
+	if decisions, err = PlanBuild(appConfig, project, []string{"tools"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(decisions) != 1 || !decisions[0].Build {
+		t.Errorf("Expected the remote context to be rebuilt but got %+v", decisions)
+	}
+}
+
+func TestDockerfileBaseImages(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writeFiles(t, fs, map[string]string{"/src/Dockerfile": `# syntax=docker/dockerfile:1
+FROM --platform=linux/amd64 smallco_base:latest AS build
+RUN make
+from golang:1.16 as tools
+FROM build
+COPY --from=tools /go/bin /bin
+FROM \
+  alpine
+`})
+	got, err := dockerfileBaseImages(fs, "/src/Dockerfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"smallco_base:latest", "golang:1.16", "alpine"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected base images %v but got %v", want, got)
+	}
+}
+
+func TestBuildOrder(t *testing.T) {
+	appConfig := c.NewConfig()
+	appConfig.ImagePrefix = "smallco"
+	appConfig.SetEngine(docker.NewFakeEngine())
+	appConfig.SetFs(afero.NewMemMapFs())
+	writeFiles(t, appConfig.GetFs(), map[string]string{
+		"/home/test/docker-compose.yml": `version: "3.6"
+services:
+  web:
+    build: ./web
+  api:
+    build: ./api
+  worker:
+    build: ./worker
+  base:
+    build: ./base
+  tools:
+    build: ./tools
+    image: smallco/tools
+`,
+		"/home/test/web/Dockerfile":    "FROM smallco_base\n",
+		"/home/test/api/Dockerfile":    "FROM smallco-base:latest\nCOPY --from=smallco/tools /bin/tool /bin\n",
+		"/home/test/worker/Dockerfile": "FROM smallco/tools AS tools\nFROM smallco_api\n",
+		"/home/test/base/Dockerfile":   "FROM alpine\n",
+		"/home/test/tools/Dockerfile":  "FROM golang\n",
+	})
+	project := &c.Project{
+		Name:                   "app",
+		Directory:              "/home/test",
+		DockerComposeFilenames: []string{"/home/test/docker-compose.yml"},
+	}
+
+	tests := []struct {
+		Selected []string
+		Stages   [][]string
+	}{
+		{nil, [][]string{{"base", "tools"}, {"api", "web"}, {"worker"}}},
+		{[]string{"web"}, [][]string{{"base"}, {"web"}}},
+		{[]string{"worker", "tools"}, [][]string{{"base", "tools"}, {"api"}, {"worker"}}},
+	}
+	for _, test := range tests {
+		decisions, err := PlanBuild(appConfig, project, test.Selected, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := buildStages(decisions); !reflect.DeepEqual(got, test.Stages) {
+			t.Errorf("%v: expected stages %v but got %v", test.Selected, test.Stages, got)
+		}
+	}
+
+	if _, err := PlanBuild(appConfig, project, []string{"db"}, false); err == nil {
+		t.Errorf("Expected an error building a service without a Dockerfile")
+	}
+}
+
+func TestRunBuild(t *testing.T) {
+	base := &BuildDecision{Service: &BuildService{Name: "base"}, Build: true}
+	web := &BuildDecision{Service: &BuildService{Name: "web", Depends: []string{"base"}}, Build: true}
+	api := &BuildDecision{Service: &BuildService{Name: "api", Depends: []string{"base"}}, Build: true}
+	decisions := []*BuildDecision{base, web, api}
+
+	var mu sync.Mutex
+	built := []string{}
+	err := RunBuild(decisions, func(service *BuildService) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if service.Name == "api" {
+			return errors.New("build failed")
+		}
+		built = append(built, service.Name)
+		return nil
+	})
+	if err == nil {
+		t.Errorf("Expected the failed build of api to be returned")
+	}
+	if !reflect.DeepEqual(built, []string{"base", "web"}) {
+		t.Errorf("Expected base then web to be built but got %v", built)
+	}
+	if !base.Built || !web.Built || api.Built {
+		t.Errorf("Expected base and web only to be marked built")
+	}
+}
@@ -0,0 +1,195 @@
+package dev
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+)
+
+// Builder builds the images of the services of a project.
+type Builder interface {
+	// Name of the builder, one of the builder types of the configuration.
+	Name() string
+	// Prepare does what the builder requires before services are built.
+	// It is run once per build.
+	Prepare() error
+	// Build builds the image of the service.
+	Build(service *BuildService) error
+}
+
+// BuildCommandData is the data the command template of a custom builder is
+// rendered with.
+type BuildCommandData struct {
+	Service     string
+	Target      string
+	Image       string
+	ImagePrefix string
+	Context     string
+	Dockerfile  string
+}
+
+type composeBuilder struct {
+	appConfig *c.Dev
+	project   *c.Project
+	args      []string
+	// files are the compose files of the project, written once by Prepare
+	// as the services are built in parallel.
+	files []string
+}
+
+func (b *composeBuilder) Name() string { return c.BuilderCompose }
+
+func (b *composeBuilder) Prepare() error {
+	files, err := ComposeFiles(b.appConfig, b.project)
+	if err != nil {
+		return err
+	}
+	b.files = files
+	return nil
+}
+
+func (b *composeBuilder) Build(service *BuildService) error {
+	args := append(append([]string{}, b.args...), service.Name)
+	return RunComposeBuild(b.appConfig.ImagePrefix, ProjectProfiles(b.project), b.files, args...)
+}
+
+type dobiBuilder struct {
+	appConfig *c.Dev
+	project   *c.Project
+	config    *c.Builder
+}
+
+func (b *dobiBuilder) Name() string { return c.BuilderDobi }
+
+// Prepare pulls the images of the services without a Dockerfile, which dobi
+// does not know about, with compose.
+func (b *dobiBuilder) Prepare() error {
+	if _, err := b.appConfig.GetFs().Stat(b.config.File); err != nil {
+		return errors.Errorf("dobi configuration %s not found", b.config.File)
+	}
+	files, err := ComposeFiles(b.appConfig, b.project)
+	if err != nil {
+		return err
+	}
+	RunComposePull(b.appConfig.ImagePrefix, ProjectProfiles(b.project), files)
+	return nil
+}
+
+// Build runs the dobi resource of the service. Unlike compose, dobi needs to
+// run in the directory of its configuration.
+func (b *dobiBuilder) Build(service *BuildService) error {
+	args := append([]string{"-f", b.config.File}, b.config.Args...)
+	args = append(args, b.config.Target(service.Name))
+	return RunDobi(filepath.Dir(b.config.File), args...)
+}
+
+type bakeBuilder struct {
+	appConfig *c.Dev
+	project   *c.Project
+	config    *c.Builder
+}
+
+func (b *bakeBuilder) Name() string { return c.BuilderBake }
+
+func (b *bakeBuilder) Prepare() error {
+	if runtime := docker.CurrentRuntime(); runtime.Name != docker.RuntimeDocker {
+		return errors.Errorf("the %s builder requires the docker runtime, not %s", c.BuilderBake, runtime.Name)
+	}
+	return nil
+}
+
+// Build runs the bake target of the service, from the bake file or, if none
+// is configured, from the project's docker compose files.
+func (b *bakeBuilder) Build(service *BuildService) error {
+	args := []string{"buildx", "bake"}
+	files := b.project.DockerComposeFilenames
+	if b.config.File != "" {
+		files = []string{b.config.File}
+	}
+	for _, file := range files {
+		args = append(args, "-f", file)
+	}
+	args = append(args, b.config.Args...)
+	args = append(args, b.config.Target(service.Name))
+	return RunCommandInDir(b.appConfig.Dir, docker.CurrentRuntime().CLI, args)
+}
+
+type customBuilder struct {
+	appConfig *c.Dev
+	config    *c.Builder
+	command   *template.Template
+}
+
+func (b *customBuilder) Name() string { return c.BuilderCustom }
+
+func (b *customBuilder) Prepare() error { return nil }
+
+// Build runs the command rendered for the service with the shell, in the
+// directory of the dev configuration.
+func (b *customBuilder) Build(service *BuildService) error {
+	var command bytes.Buffer
+	err := b.command.Execute(&command, BuildCommandData{
+		Service:     service.Name,
+		Target:      b.config.Target(service.Name),
+		Image:       serviceImageNames(b.appConfig.ImagePrefix, service)[0],
+		ImagePrefix: b.appConfig.ImagePrefix,
+		Context:     service.Context,
+		Dockerfile:  service.Dockerfile,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to render the build command of %s", service.Name)
+	}
+	args := []string{"-c", command.String(), "sh"}
+	return RunCommandInDir(b.appConfig.Dir, "sh", append(args, b.config.Args...))
+}
+
+// dobiAvailable returns whether the dobi binary is installed and there is a
+// dobi configuration next to the dev configuration.
+func dobiAvailable(appConfig *c.Dev) (string, bool) {
+	if _, err := exec.LookPath("dobi"); err != nil {
+		return "", false
+	}
+	filename := filepath.Join(appConfig.Dir, "dobi.yaml")
+	if _, err := appConfig.GetFs().Stat(filename); err != nil {
+		return "", false
+	}
+	return filename, true
+}
+
+// NewBuilder returns the builder of the project along with the reason it was
+// chosen: either the builder configured for the project or, when none is, dobi
+// if it is available and compose otherwise.
+func NewBuilder(appConfig *c.Dev, project *c.Project) (Builder, string, error) {
+	config := project.Builder
+	reason := fmt.Sprintf("configured for project %s", project.Name)
+	if config == nil {
+		config = &c.Builder{Type: c.BuilderCompose}
+		reason = "no builder is configured and dobi is not available"
+		if filename, ok := dobiAvailable(appConfig); ok {
+			config = &c.Builder{Type: c.BuilderDobi, File: filename}
+			reason = fmt.Sprintf("no builder is configured and dobi is installed with %s", filename)
+		}
+	}
+
+	switch config.Type {
+	case c.BuilderCompose:
+		return &composeBuilder{appConfig: appConfig, project: project, args: config.Args}, reason, nil
+	case c.BuilderDobi:
+		return &dobiBuilder{appConfig: appConfig, project: project, config: config}, reason, nil
+	case c.BuilderBake:
+		return &bakeBuilder{appConfig: appConfig, project: project, config: config}, reason, nil
+	case c.BuilderCustom:
+		command, err := template.New(project.Name).Option("missingkey=error").Parse(config.Command)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "invalid build command of project %s", project.Name)
+		}
+		return &customBuilder{appConfig: appConfig, config: config, command: command}, reason, nil
+	}
+	return nil, "", errors.Errorf("unknown builder %s of project %s", config.Type, project.Name)
+}
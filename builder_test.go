@@ -0,0 +1,116 @@
+package dev
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	c "github.com/wish/dev/config"
+	"gotest.tools/v3/env"
+)
+
+func TestNewBuilder(t *testing.T) {
+	defer env.Patch(t, "PATH", "/nonexistent")()
+	defer setExecutor(nil)
+
+	appConfig := c.NewConfig()
+	appConfig.ImagePrefix = "dev"
+	appConfig.Dir = "/home/test"
+	appConfig.SetFs(afero.NewMemMapFs())
+	service := &BuildService{Name: "app", Context: "/home/test/app", Dockerfile: "/home/test/app/Dockerfile"}
+
+	tests := []struct {
+		Name     string
+		Builder  *c.Builder
+		Reason   string
+		Path     string
+		Expected []string
+	}{
+		{
+			"default",
+			nil,
+			"no builder is configured and dobi is not available",
+			"docker",
+			[]string{"compose", "--compatibility", "-p", "dev", "-f", "/home/test/docker-compose.yml", "build", "app"},
+		},
+		{
+			"compose",
+			&c.Builder{Type: c.BuilderCompose, Args: []string{"--pull"}},
+			"configured for project app",
+			"docker",
+			[]string{"compose", "--compatibility", "-p", "dev", "-f", "/home/test/docker-compose.yml", "build", "--pull", "app"},
+		},
+		{
+			"dobi",
+			&c.Builder{Type: c.BuilderDobi, File: "/home/test/build/dobi.yaml", Targets: map[string]string{"app": "app-image"},
+				Args: []string{"--no-bind-mount"}},
+			"configured for project app",
+			"dobi",
+			[]string{"-f", "/home/test/build/dobi.yaml", "--no-bind-mount", "app-image"},
+		},
+		{
+			"buildx-bake",
+			&c.Builder{Type: c.BuilderBake},
+			"configured for project app",
+			"docker",
+			[]string{"buildx", "bake", "-f", "/home/test/docker-compose.yml", "app"},
+		},
+		{
+			"custom",
+			&c.Builder{Type: c.BuilderCustom, Command: "make image-{{.Target}} IMAGE={{.Image}}", Targets: map[string]string{"app": "api"}},
+			"configured for project app",
+			"sh",
+			[]string{"-c", "make image-api IMAGE=dev-app", "sh"},
+		},
+	}
+
+	for _, test := range tests {
+		project := &c.Project{
+			Name:                   "app",
+			Directory:              "/home/test",
+			DockerComposeFilenames: []string{"/home/test/docker-compose.yml"},
+			Builder:                test.Builder,
+		}
+		builder, reason, err := NewBuilder(appConfig, project)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.Name, err)
+			continue
+		}
+		if reason != test.Reason {
+			t.Errorf("%s: expected reason '%s' but got '%s'", test.Name, test.Reason, reason)
+		}
+
+		// the compose files are written by Prepare, the other builders
+		// need dobi or buildx to be prepared
+		if builder.Name() == c.BuilderCompose {
+			if err := builder.Prepare(); err != nil {
+				t.Errorf("%s: unexpected error: %s", test.Name, err)
+				continue
+			}
+		}
+		setup()
+		setExecutor(tc.NewCommand)
+		if err := builder.Build(service); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.Name, err)
+		}
+		if tc.Path != test.Path || !reflect.DeepEqual(tc.Args, test.Expected) {
+			t.Errorf("%s: expected '%s %s' but got '%s %s'", test.Name, test.Path, strings.Join(test.Expected, " "),
+				tc.Path, strings.Join(tc.Args, " "))
+		}
+	}
+}
+
+func TestDobiBuilderMissingFile(t *testing.T) {
+	appConfig := c.NewConfig()
+	appConfig.SetFs(afero.NewMemMapFs())
+	project := &c.Project{Name: "app", Builder: &c.Builder{Type: c.BuilderDobi, File: "/home/test/dobi.yaml"}}
+
+	builder, _, err := NewBuilder(appConfig, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Prepare(); err == nil {
+		t.Errorf("Expected an error preparing dobi without its configuration")
+	}
+}
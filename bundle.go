@@ -0,0 +1,263 @@
+package dev
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	c "github.com/wish/dev/config"
+)
+
+const (
+	// bundleManifestName is the name of the manifest in a bundle, its
+	// first entry.
+	bundleManifestName = "manifest.json"
+	// bundleImagesName is the name of the archive of the images in a
+	// bundle, in the format of 'docker save'.
+	bundleImagesName = "images.tar"
+)
+
+// BundleManifest describes the images of a bundle written by SaveBundle.
+type BundleManifest struct {
+	// ImagePrefix is the image prefix of the configuration the bundle was
+	// saved with.
+	ImagePrefix string `json:"image_prefix"`
+	// Projects are the projects whose images were saved, dependencies
+	// first.
+	Projects []string       `json:"projects"`
+	Created  time.Time      `json:"created"`
+	Images   []*BundleImage `json:"images"`
+}
+
+// BundleImage is an image saved in a bundle.
+type BundleImage struct {
+	Project string `json:"project"`
+	Service string `json:"service"`
+	// Ref is the name the image was saved under.
+	Ref string `json:"ref"`
+	// Locked is the image pinned for the service by the lock file, saved
+	// rather than Ref. It is tagged with its bundled reference when
+	// loaded, which the lock override then uses.
+	Locked *LockedImage `json:"locked,omitempty"`
+	ID     string       `json:"id"`
+	// Prefixed is set for images built from a Dockerfile that compose
+	// names after the image prefix. They are tagged with the names
+	// compose expects with the image prefix of the configuration they
+	// are loaded with.
+	Prefixed bool `json:"prefixed,omitempty"`
+}
+
+// BundleImages returns the images the projects need to run: the images pulled
+// by compose, those pinned by the lock file if the project is locked, which are
+// pulled if missing, and the images of the services built from a Dockerfile,
+// which must have been built or downloaded. An image needed by several
+// projects is only returned once.
+func BundleImages(appConfig *c.Dev, projects []*c.Project) ([]*BundleImage, error) {
+	engine := appConfig.GetEngine()
+	lock, err := LoadLock(appConfig)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	images := []*BundleImage{}
+	add := func(image *BundleImage) {
+		if !seen[image.saved()] {
+			seen[image.saved()] = true
+			images = append(images, image)
+		}
+	}
+
+	for _, project := range projects {
+		refs, err := composeImages(appConfig, project)
+		if err != nil {
+			return nil, err
+		}
+		var locked map[string]*LockedImage
+		if projectLock := lock.Project(project.Name); projectLock != nil {
+			locked = projectLock.Images
+		}
+		for _, service := range sortedKeys(refs) {
+			image := &BundleImage{Project: project.Name, Service: service, Ref: refs[service],
+				Locked: pinned(locked, project.Name, service, refs[service])}
+			ref := image.saved()
+			info, err := engine.ImageInspect(ref)
+			if err != nil {
+				log.Infof("Pulling %s for %s", ref, service)
+				if err := RunDockerPull(ref); err != nil {
+					return nil, errors.Wrapf(err, "failed to pull %s", ref)
+				}
+				if info, err = engine.ImageInspect(ref); err != nil {
+					return nil, err
+				}
+			}
+			image.ID = info.ID
+			add(image)
+		}
+
+		services, err := CreateBuildServices(appConfig, project)
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range services {
+			var image *BundleImage
+			for _, name := range serviceImageNames(appConfig.ImagePrefix, service) {
+				if info, err := engine.ImageInspect(name); err == nil {
+					image = &BundleImage{Project: project.Name, Service: service.Name, Ref: name, ID: info.ID,
+						Prefixed: service.Image == ""}
+					break
+				}
+			}
+			if image == nil {
+				return nil, errors.Errorf("there is no image of %s, run 'dev %s build' or 'dev %s download' first",
+					service.Name, project.Name, project.Name)
+			}
+			add(image)
+		}
+	}
+	return images, nil
+}
+
+// saved returns the reference the image is saved under.
+func (i *BundleImage) saved() string {
+	if i.Locked != nil {
+		return i.Locked.Reference()
+	}
+	return i.Ref
+}
+
+// sortedKeys returns the keys of the map in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeTarFile writes the entry to the tar archive.
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// SaveBundle writes the images, and a manifest of them, to the bundle file.
+func SaveBundle(appConfig *c.Dev, filename string, projects []string, images []*BundleImage) error {
+	fs := appConfig.GetFs()
+	manifest := &BundleManifest{
+		ImagePrefix: appConfig.ImagePrefix,
+		Projects:    projects,
+		Created:     time.Now().UTC(),
+		Images:      images,
+	}
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the bundle manifest")
+	}
+
+	// the image archive is buffered to a temporary file as its size must
+	// be known to add it to the bundle
+	refs := make([]string, 0, len(images))
+	for _, image := range images {
+		refs = append(refs, image.saved())
+	}
+	saved, err := appConfig.GetEngine().ImageSave(context.Background(), refs)
+	if err != nil {
+		return err
+	}
+	defer saved.Close()
+	tmp, err := afero.TempFile(fs, "", "dev-bundle")
+	if err != nil {
+		return errors.Wrap(err, "failed to create a temporary file")
+	}
+	defer fs.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, saved)
+	if err != nil {
+		return errors.Wrap(err, "failed to save images")
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "failed to save images")
+	}
+
+	f, err := fs.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", filename)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	if err := writeTarFile(tw, bundleManifestName, int64(len(b)), bytes.NewReader(b)); err != nil {
+		return errors.Wrapf(err, "failed to write %s", filename)
+	}
+	if err := writeTarFile(tw, bundleImagesName, size, tmp); err != nil {
+		return errors.Wrapf(err, "failed to write %s", filename)
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Wrapf(err, "failed to write %s", filename)
+	}
+	return f.Close()
+}
+
+// LoadBundle imports the images of the bundle file and tags them with the
+// names compose expects, those of the images built from a Dockerfile with the
+// image prefix of the configuration. The images pinned by the lock file are
+// also tagged with their bundled reference, as the digest they are pinned to
+// is lost.
+func LoadBundle(appConfig *c.Dev, filename string) (*BundleManifest, error) {
+	f, err := appConfig.GetFs().Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", filename)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	header, err := tr.Next()
+	if err != nil || header.Name != bundleManifestName {
+		return nil, errors.Errorf("%s is not a bundle saved by dev", filename)
+	}
+	manifest := &BundleManifest{}
+	if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+		return nil, errors.Wrapf(err, "failed to read the manifest of %s", filename)
+	}
+	header, err = tr.Next()
+	if err != nil || header.Name != bundleImagesName {
+		return nil, errors.Errorf("%s has no images", filename)
+	}
+	engine := appConfig.GetEngine()
+	if err := engine.ImageLoad(context.Background(), tr); err != nil {
+		return nil, err
+	}
+
+	for _, image := range manifest.Images {
+		targets := []string{image.Ref}
+		if image.Prefixed {
+			targets = serviceImageNames(appConfig.ImagePrefix, &BuildService{Name: image.Service})
+		}
+		if image.Locked != nil {
+			targets = append(targets, image.Locked.BundledReference())
+		}
+		for _, target := range targets {
+			if err := engine.ImageTag(image.ID, target); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return manifest, nil
+}
@@ -0,0 +1,122 @@
+package dev
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/spf13/afero"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"gotest.tools/v3/env"
+)
+
+func TestSaveLoadBundle(t *testing.T) {
+	appConfig, project, engine := downloadTestConfig(t)
+	if _, err := BundleImages(appConfig, []*c.Project{project}); err == nil {
+		t.Errorf("Expected an error bundling images that were not built")
+	}
+
+	engine.ImageDetails["postgres"] = types.ImageInspect{ID: "sha256:1"}
+	engine.ImageDetails["dev_app"] = types.ImageInspect{ID: "sha256:2"}
+	engine.ImageDetails["dev/worker"] = types.ImageInspect{ID: "sha256:3"}
+	images, err := BundleImages(appConfig, []*c.Project{project, project})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := []string{}
+	for _, image := range images {
+		refs = append(refs, image.Ref)
+	}
+	if !reflect.DeepEqual(refs, []string{"postgres", "dev_app", "dev/worker"}) {
+		t.Errorf("Unexpected bundled images %v", refs)
+	}
+	if err := SaveBundle(appConfig, "/bundle.tar", []string{project.Name}, images); err != nil {
+		t.Fatal(err)
+	}
+
+	// images built are tagged with the prefix they are loaded with
+	engine = docker.NewFakeEngine()
+	appConfig.SetEngine(engine)
+	appConfig.ImagePrefix = "offline"
+	manifest, err := LoadBundle(appConfig, "/bundle.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ImagePrefix != "dev" || !reflect.DeepEqual(manifest.Projects, []string{"app"}) {
+		t.Errorf("Unexpected manifest %+v", manifest)
+	}
+	expected := map[string]string{
+		"postgres":    "sha256:1",
+		"offline-app": "sha256:2",
+		"offline_app": "sha256:2",
+		"dev/worker":  "sha256:3",
+	}
+	for ref, id := range expected {
+		if image, err := engine.ImageInspect(ref); err != nil || image.ID != id {
+			t.Errorf("Expected %s to be loaded as %s but got %q (%v)", ref, id, image.ID, err)
+		}
+	}
+
+	if _, err := LoadBundle(appConfig, "/home/test/docker-compose.yml"); err == nil {
+		t.Errorf("Expected an error loading a file that is not a bundle")
+	}
+}
+
+func TestSaveLoadBundleLocked(t *testing.T) {
+	defer env.Patch(t, "DEV_STATE_HOME", "/state")()
+	appConfig, project, engine := downloadTestConfig(t)
+	appConfig.Dir = "/home/test"
+	lock, err := LoadLock(appConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Projects[project.Name] = &ProjectLock{Images: map[string]*LockedImage{
+		"db": {Image: "postgres", Digest: "sha256:abc"},
+	}}
+	if err := lock.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pinned image is saved rather than whatever postgres is tagged
+	engine.ImageDetails["postgres"] = types.ImageInspect{ID: "sha256:newer"}
+	engine.ImageDetails["postgres@sha256:abc"] = types.ImageInspect{ID: "sha256:1"}
+	engine.ImageDetails["dev_app"] = types.ImageInspect{ID: "sha256:2"}
+	engine.ImageDetails["dev/worker"] = types.ImageInspect{ID: "sha256:3"}
+	images, err := BundleImages(appConfig, []*c.Project{project})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if images[0].ID != "sha256:1" || images[0].saved() != "postgres@sha256:abc" {
+		t.Errorf("Expected the pinned image to be bundled but got %+v", images[0])
+	}
+	if err := SaveBundle(appConfig, "/bundle.tar", []string{project.Name}, images); err != nil {
+		t.Fatal(err)
+	}
+
+	// the loaded image has no digest, so up uses the tag it is loaded under
+	engine = docker.NewFakeEngine()
+	appConfig.SetEngine(engine)
+	if _, err := LoadBundle(appConfig, "/bundle.tar"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"postgres", "postgres:sha256-abc"} {
+		if image, err := engine.ImageInspect(ref); err != nil || image.ID != "sha256:1" {
+			t.Errorf("Expected %s to be loaded as the pinned image but got %q (%v)", ref, image.ID, err)
+		}
+	}
+	files, err := ComposeFiles(appConfig, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected a compose file overriding the locked images but got %v", files)
+	}
+	override, err := afero.ReadFile(appConfig.GetFs(), files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "services:\n  db:\n    image: postgres:sha256-abc\n"; string(override) != expected {
+		t.Errorf("Expected override:\n%s\nbut got:\n%s", expected, override)
+	}
+}
@@ -0,0 +1,315 @@
+package dev
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"github.com/wish/dev/state"
+)
+
+// The kinds of the resources removed by clean, in the order they are removed.
+const (
+	CleanContainer = "container"
+	CleanImage     = "image"
+	CleanNetwork   = "network"
+	CleanVolume    = "volume"
+)
+
+// CleanOptions select the resources clean removes.
+type CleanOptions struct {
+	// OlderThan keeps the containers, images and volumes created more
+	// recently.
+	OlderThan time.Duration
+	// KeepLast is the number of images built by dev for each service
+	// that are kept, the current image included.
+	KeepLast int
+	// Volumes removes the volumes of the projects.
+	Volumes bool
+}
+
+// CleanResource is a resource managed by dev that clean removes.
+type CleanResource struct {
+	Kind string
+	ID   string
+	Name string
+	// Size is the disk space of the resource, -1 if it is not known.
+	Size    int64
+	Created time.Time
+	// Err is why the resource could not be removed, set by RunClean.
+	Err error
+
+	// service is the service whose earlier build an image is.
+	service string
+}
+
+// builtImageNames returns the names of the images built for the services of
+// every project, which are not orphaned.
+func builtImageNames(appConfig *c.Dev) (map[string]bool, error) {
+	names := make(map[string]bool)
+	for _, name := range appConfig.ProjectNames() {
+		services, err := CreateBuildServices(appConfig, appConfig.Projects[name])
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range services {
+			for _, image := range serviceImageNames(appConfig.ImagePrefix, service) {
+				names[image] = true
+			}
+		}
+	}
+	return names, nil
+}
+
+// PlanClean returns the resources managed by dev that are no longer needed:
+// the stopped containers of the projects, the images built by dev before the
+// last KeepLast builds of each service, the images dev built for services
+// that no longer exist, the managed networks without containers and,
+// with Volumes, the volumes of the projects not used by a container. Resources
+// created less than OlderThan ago are kept.
+func PlanClean(appConfig *c.Dev, opts *CleanOptions) ([]*CleanResource, error) {
+	if opts.KeepLast < 1 {
+		return nil, errors.Errorf("at least the current image of each service must be kept, not %d", opts.KeepLast)
+	}
+	engine := appConfig.GetEngine()
+	now := time.Now()
+	old := func(created time.Time) bool {
+		return now.Sub(created) >= opts.OlderThan
+	}
+	resources := []*CleanResource{}
+
+	containers, err := engine.ContainerList()
+	if err != nil {
+		return nil, err
+	}
+	removed := make(map[string]bool)
+	// the images and volumes of the containers kept cannot be removed
+	usedImages := make(map[string]bool)
+	usedVolumes := make(map[string]bool)
+	for i, container := range containers {
+		created := time.Unix(container.Created, 0)
+		if container.Labels[docker.ComposeProjectLabel] == appConfig.ImagePrefix && old(created) &&
+			(container.State == "exited" || container.State == "created" || container.State == "dead") {
+			name := docker.ContainerName(&containers[i])
+			removed[name] = true
+			resources = append(resources, &CleanResource{Kind: CleanContainer, ID: container.ID, Name: name,
+				Size: -1, Created: created})
+			continue
+		}
+		usedImages[container.ImageID] = true
+		for _, mount := range container.Mounts {
+			usedVolumes[mount.Name] = true
+		}
+	}
+
+	images, err := engine.ImageList()
+	if err != nil {
+		return nil, err
+	}
+	imageMap := make(map[string]types.ImageSummary, len(images))
+	for _, image := range images {
+		imageMap[image.ID] = image
+	}
+	localState, err := state.Load(appConfig.GetFs(), appConfig.ImagePrefix)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]bool)
+	recorded := make(map[string]bool)
+	for _, build := range localState.Builds {
+		current[build.ImageID] = true
+		recorded[build.ImageID] = true
+		for _, image := range build.Previous {
+			recorded[image.ID] = true
+		}
+	}
+	planned := make(map[string]bool)
+	addImage := func(image types.ImageSummary, name, service string) {
+		if planned[image.ID] || current[image.ID] || usedImages[image.ID] {
+			return
+		}
+		planned[image.ID] = true
+		resources = append(resources, &CleanResource{Kind: CleanImage, ID: image.ID, Name: name,
+			Size: image.Size, Created: time.Unix(image.Created, 0), service: service})
+	}
+
+	for _, service := range sortedBuilds(localState) {
+		previous := localState.Builds[service].Previous
+		for i, built := range previous {
+			image, ok := imageMap[built.ID]
+			if !ok || i < opts.KeepLast-1 || !old(built.Time) {
+				continue
+			}
+			name := service + " build of " + built.Time.Format("2006-01-02 15:04")
+			addImage(image, name, service)
+		}
+	}
+
+	// images of services removed from the configuration are orphaned,
+	// though only if every project could be read. Other projects can have
+	// names under the image prefix, so only the images dev recorded or
+	// compose built for the project are
+	if names, err := builtImageNames(appConfig); err != nil {
+		log.Warnf("Not cleaning up orphaned images: %s", err)
+	} else {
+		prefixed, err := docker.ImageList(engine, appConfig.ImagePrefix)
+		if err != nil {
+			return nil, err
+		}
+		// the images of other instances are under the image prefix too
+		instances, err := otherInstancePrefixes(appConfig)
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range prefixed {
+			orphaned := recorded[image.ID] || image.Labels[docker.ComposeProjectLabel] == appConfig.ImagePrefix
+			for _, tag := range image.RepoTags {
+				orphaned = orphaned && !names[imageRepository(tag)]
+				for _, prefix := range instances {
+					orphaned = orphaned && !strings.HasPrefix(tag, prefix+"-") && !strings.HasPrefix(tag, prefix+"_")
+				}
+			}
+			if orphaned && old(time.Unix(image.Created, 0)) {
+				addImage(image, strings.Join(image.RepoTags, ", "), "")
+			}
+		}
+	}
+
+	summaries, err := InspectNetworks(appConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, summary := range summaries {
+		if summary.State == StateMissing {
+			continue
+		}
+		unused := true
+		for _, attachment := range summary.Attachments {
+			unused = unused && removed[attachment.Container]
+		}
+		if unused {
+			resources = append(resources, &CleanResource{Kind: CleanNetwork, ID: summary.ID, Name: summary.Name, Size: -1})
+		}
+	}
+
+	if opts.Volumes {
+		volumes, err := engine.VolumeList()
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+		for _, volume := range volumes {
+			if volume.Labels[docker.ComposeProjectLabel] != appConfig.ImagePrefix || usedVolumes[volume.Name] {
+				continue
+			}
+			created, _ := time.Parse(time.RFC3339, volume.CreatedAt)
+			if !old(created) {
+				continue
+			}
+			size := int64(-1)
+			if volume.UsageData != nil && volume.UsageData.Size >= 0 {
+				size = volume.UsageData.Size
+			}
+			resources = append(resources, &CleanResource{Kind: CleanVolume, ID: volume.Name, Name: volume.Name,
+				Size: size, Created: created})
+		}
+	}
+	return resources, nil
+}
+
+// sortedBuilds returns the names of the services with a build recorded in the
+// state, in order.
+func sortedBuilds(localState *state.State) []string {
+	services := make([]string, 0, len(localState.Builds))
+	for service := range localState.Builds {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// RunClean removes the resources, containers first so that the images,
+// networks and volumes they use can be removed. The error of each resource
+// that could not be removed is set, and the images removed are forgotten by
+// the build records.
+func RunClean(appConfig *c.Dev, resources []*CleanResource) error {
+	engine := appConfig.GetEngine()
+	for _, resource := range resources {
+		switch resource.Kind {
+		case CleanContainer:
+			resource.Err = engine.ContainerRemove(resource.ID, false)
+		case CleanImage:
+			resource.Err = engine.ImageRemove(resource.ID, false)
+		case CleanNetwork:
+			resource.Err = docker.NetworkRemove(engine, resource.ID, false)
+		case CleanVolume:
+			resource.Err = engine.VolumeRemove(resource.ID, false)
+		}
+		if resource.Err != nil {
+			log.Warnf("Failed to remove %s %s: %s", resource.Kind, resource.Name, resource.Err)
+		}
+	}
+
+	localState, err := state.Load(appConfig.GetFs(), appConfig.ImagePrefix)
+	if err != nil {
+		return err
+	}
+	for _, resource := range resources {
+		build, ok := localState.Builds[resource.service]
+		if resource.Kind != CleanImage || resource.Err != nil || !ok {
+			continue
+		}
+		previous := []*state.Image{}
+		for _, image := range build.Previous {
+			if image.ID != resource.ID {
+				previous = append(previous, image)
+			}
+		}
+		build.Previous = previous
+	}
+	return localState.Save()
+}
+
+// PrintClean writes the resources removed, or that would be with dryRun, and
+// the disk space they use.
+func PrintClean(out io.Writer, resources []*CleanResource, dryRun bool) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tNAME\tSIZE\tCREATED\tRESULT")
+	var total int64
+	for _, resource := range resources {
+		size, created := "-", "-"
+		if resource.Size >= 0 {
+			size = units.HumanSize(float64(resource.Size))
+		}
+		if !resource.Created.IsZero() {
+			created = units.HumanDuration(time.Since(resource.Created)) + " ago"
+		}
+		result := "removed"
+		switch {
+		case dryRun:
+			result = "would be removed"
+		case resource.Err != nil:
+			result = "failed"
+		}
+		if resource.Err == nil && resource.Size > 0 {
+			total += resource.Size
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", resource.Kind, resource.Name, size, created, result)
+	}
+	w.Flush()
+	verb := "Reclaimed"
+	if dryRun {
+		verb = "Would reclaim"
+	}
+	// images share layers, so the space of each is an upper bound
+	fmt.Fprintf(out, "%s up to %s\n", verb, units.HumanSize(float64(total)))
+}
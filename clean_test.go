@@ -0,0 +1,141 @@
+package dev
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/wish/dev/docker"
+	"github.com/wish/dev/state"
+	"gotest.tools/v3/env"
+)
+
+func TestClean(t *testing.T) {
+	defer env.Patch(t, "DEV_STATE_HOME", "/state")()
+	appConfig, _, engine := downloadTestConfig(t)
+	appConfig.Networks["dev-net"] = &types.NetworkCreate{}
+	appConfig.Networks["dev-busy"] = &types.NetworkCreate{}
+
+	now := time.Now()
+	daysAgo := func(days int) time.Time { return now.Add(-time.Duration(days) * 24 * time.Hour) }
+	localState, err := state.Load(appConfig.GetFs(), "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localState.Builds["app"] = &state.Build{ImageID: "sha256:a3", Time: daysAgo(1), Previous: []*state.Image{
+		{ID: "sha256:a2", Time: daysAgo(3)},
+		{ID: "sha256:a1", Time: daysAgo(10)},
+		{ID: "sha256:a0", Time: daysAgo(20)},
+	}}
+	if err := localState.Save(); err != nil {
+		t.Fatal(err)
+	}
+	image := func(id string, days int, tags ...string) types.ImageSummary {
+		return types.ImageSummary{ID: id, Created: daysAgo(days).Unix(), Size: 1000, RepoTags: tags}
+	}
+	composeImage := func(id string, days int, project string, tags ...string) types.ImageSummary {
+		summary := image(id, days, tags...)
+		summary.Labels = map[string]string{docker.ComposeProjectLabel: project}
+		return summary
+	}
+	engine.Images = []types.ImageSummary{
+		image("sha256:a3", 1, "dev-app:latest"),
+		image("sha256:a2", 3, "<none>:<none>"),
+		image("sha256:a1", 10, "<none>:<none>"),
+		image("sha256:a0", 20, "<none>:<none>"),
+		composeImage("sha256:legacy", 30, "dev", "dev-legacy:latest"),
+		// images of other projects whose names start with the prefix
+		image("sha256:admin", 30, "dev-admin-web:latest"),
+		composeImage("sha256:admin_web", 30, "dev-admin", "dev-admin_web:latest"),
+		image("sha256:postgres", 30, "postgres:latest"),
+	}
+
+	busyID := engine.AddNetwork("dev-busy", types.NetworkCreate{})
+	unusedID := engine.AddNetwork("dev-net", types.NetworkCreate{})
+	labels := map[string]string{docker.ComposeProjectLabel: "dev"}
+	engine.AddContainer(types.Container{ID: "exited", Names: []string{"/dev_app_1"}, State: "exited",
+		Labels: labels, ImageID: "sha256:a0", Created: daysAgo(20).Unix(),
+		Mounts: []types.MountPoint{{Name: "dev_data"}}})
+	engine.AddContainer(types.Container{ID: "running", Names: []string{"/dev_db_1"}, State: "running",
+		Labels: labels, ImageID: "sha256:a1", Created: daysAgo(20).Unix(),
+		Mounts: []types.MountPoint{{Name: "dev_cache"}},
+		NetworkSettings: &types.SummaryNetworkSettings{Networks: map[string]*network.EndpointSettings{
+			"dev-busy": {NetworkID: busyID},
+		}}})
+	engine.AddContainer(types.Container{ID: "other", Names: []string{"/other_app_1"}, State: "exited",
+		Labels: map[string]string{docker.ComposeProjectLabel: "other"}, Created: daysAgo(20).Unix()})
+	volumeLabels := map[string]string{docker.ComposeProjectLabel: "dev"}
+	created := daysAgo(20).Format(time.RFC3339)
+	engine.Volumes = []*types.Volume{
+		{Name: "dev_data", Labels: volumeLabels, CreatedAt: created},
+		{Name: "dev_cache", Labels: volumeLabels, CreatedAt: created},
+		{Name: "other_data", Labels: map[string]string{docker.ComposeProjectLabel: "other"}, CreatedAt: created},
+	}
+
+	names := func(resources []*CleanResource) []string {
+		got := []string{}
+		for _, resource := range resources {
+			got = append(got, resource.Kind+" "+resource.ID)
+		}
+		return got
+	}
+	if _, err := PlanClean(appConfig, &CleanOptions{KeepLast: 0}); err == nil {
+		t.Errorf("Expected an error when not keeping the current image")
+	}
+
+	resources, err := PlanClean(appConfig, &CleanOptions{KeepLast: 2, OlderThan: 5 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a1 is used by a running container and a2 is kept as one of the
+	// last 2 builds
+	expected := []string{"container exited", "image sha256:a0", "image sha256:legacy", "network " + unusedID}
+	if got := names(resources); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v but got %v", expected, got)
+	}
+
+	resources, err = PlanClean(appConfig, &CleanOptions{KeepLast: 1, Volumes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"container exited", "image sha256:a2", "image sha256:a0", "image sha256:legacy",
+		"network " + unusedID, "volume dev_data"}
+	if got := names(resources); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v but got %v", expected, got)
+	}
+
+	var out bytes.Buffer
+	PrintClean(&out, resources, true)
+	if !strings.Contains(out.String(), "Would reclaim up to 3kB") {
+		t.Errorf("Expected the dry run to report the space reclaimed but got:\n%s", out.String())
+	}
+
+	if err := RunClean(appConfig, resources); err != nil {
+		t.Fatal(err)
+	}
+	for _, resource := range resources {
+		if resource.Err != nil {
+			t.Errorf("Unexpected error removing %s %s: %s", resource.Kind, resource.Name, resource.Err)
+		}
+	}
+	if _, ok := engine.Container("exited"); ok {
+		t.Errorf("Expected the exited container to be removed")
+	}
+	if _, ok := engine.Network("dev-busy"); !ok {
+		t.Errorf("Expected the network in use to be kept")
+	}
+	if volumes, _ := engine.VolumeList(); len(volumes) != 2 {
+		t.Errorf("Expected the volumes in use or of other projects to be kept but got %d", len(volumes))
+	}
+	localState, err = state.Load(appConfig.GetFs(), "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous := localState.Builds["app"].Previous; len(previous) != 1 || previous[0].ID != "sha256:a1" {
+		t.Errorf("Expected only the build in use to be remembered but got %+v", previous)
+	}
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/wish/dev"
+	"github.com/wish/dev/config"
+)
+
+func newLoadCommand(devConfig *config.Dev) *cobra.Command {
+	return &cobra.Command{
+		Use:   "load <bundle>",
+		Short: "Import the images of a bundle saved by 'dev <project> save'",
+		Long: `Imports the images of the bundle and tags them with the names compose expects,
+those of the services built from a Dockerfile with the image prefix of this
+configuration, so that the projects of the bundle can be brought up with
+'up --no-build' without a registry.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			manifest, err := dev.LoadBundle(devConfig, args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, image := range manifest.Images {
+				log.Debugf("Loaded %s of %s/%s", image.Ref, image.Project, image.Service)
+			}
+			log.Infof("Loaded %d images of %s", len(manifest.Images), strings.Join(manifest.Projects, ", "))
+		},
+	}
+}
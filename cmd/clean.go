@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/wish/dev"
+	"github.com/wish/dev/config"
+)
+
+func newCleanCommand(devConfig *config.Dev) *cobra.Command {
+	var dryRun bool
+	opts := &dev.CleanOptions{}
+	clean := &cobra.Command{
+		Use:   "clean",
+		Short: "Remove the containers, images, networks and volumes dev no longer needs",
+		Long: `Removes only what dev manages: the stopped containers of the projects, the
+images dev built before the last --keep-last builds of each service, the images
+dev or compose built for services that no longer exist and the managed networks
+without containers. With --volumes the volumes of the projects not used by a
+container are removed as well. Resources created within --older-than are kept.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			resources, err := dev.PlanClean(devConfig, opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(resources) == 0 {
+				log.Info("Nothing to clean")
+				return
+			}
+			if !dryRun {
+				if err := dev.RunClean(devConfig, resources); err != nil {
+					log.Warnf("Failed to update the build records: %s", err)
+				}
+			}
+			dev.PrintClean(os.Stdout, resources, dryRun)
+			for _, resource := range resources {
+				if resource.Err != nil {
+					log.Fatal("Some resources could not be removed")
+				}
+			}
+		},
+	}
+	clean.Flags().BoolVar(&dryRun, "dry-run", false, "List what would be removed and the space it uses")
+	clean.Flags().BoolVar(&opts.Volumes, "volumes", false, "Remove the volumes of the projects too")
+	clean.Flags().DurationVar(&opts.OlderThan, "older-than", 0, "Keep what was created more recently, e.g. 168h")
+	clean.Flags().IntVar(&opts.KeepLast, "keep-last", 1, "Number of images built for each service to keep, the current one included")
+	return clean
+}
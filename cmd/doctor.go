@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/dev"
+	"github.com/wish/dev/config"
+)
+
+// doctorCommand is the name of the doctor command. It must be able to run
+// when the environment is broken, so Initialize does not exit for it.
+const doctorCommand = "doctor"
+
+func newDoctorCommand(devConfig *config.Dev) *cobra.Command {
+	return &cobra.Command{
+		Use:   doctorCommand,
+		Short: "Diagnose problems with the environment dev runs in",
+		Long: `Checks that docker, docker compose, dobi and the configured registries are
+available, that the managed networks do not conflict with other networks, the
+disk space used by the project images, the clock of the docker daemon and the
+validity of the dev configuration. A remediation is printed for each problem
+found.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			results := dev.Diagnose(devConfig)
+			dev.PrintCheckResults(os.Stdout, results)
+			for _, result := range results {
+				if result.Status == dev.CheckFail {
+					os.Exit(1)
+				}
+			}
+		},
+	}
+}
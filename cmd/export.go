@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/wish/dev"
+	"github.com/wish/dev/config"
+)
+
+func newComposeConfigCommand(objMap map[string]dev.Dependency, devConfig *config.Dev, project *dev.Project) *cobra.Command {
+	var withDeps bool
+	var output string
+	composeConfig := &cobra.Command{
+		Use:   dev.COMPOSECONFIG,
+		Short: "Export the compose configuration of " + project.Name + " as a single file",
+		Long: `Writes the effective compose configuration of the project as one compose file
+that 'docker compose -f' runs without dev. The docker-compose files of the
+project are merged, with the images pinned by the lock file and relative paths
+made absolute. The compose project is named after the image_prefix and the
+networks managed by dev are defined in the file. With --with-deps the projects
+it depends on are included.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			projects := []*config.Project{project.Config}
+			if withDeps {
+				deps, err := dev.DependencyProjects(objMap, project)
+				if err != nil {
+					log.Fatal(err)
+				}
+				projects = []*config.Project{}
+				for _, p := range append(deps, project) {
+					projects = append(projects, p.Config)
+				}
+			}
+			b, err := dev.ExportCompose(devConfig, projects)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if output == "" {
+				os.Stdout.Write(b)
+				return
+			}
+			if err := afero.WriteFile(devConfig.GetFs(), output, b, 0644); err != nil {
+				log.Fatal(err)
+			}
+			log.Infof("Wrote the compose configuration of %s to %s", project.Name, output)
+		},
+	}
+	composeConfig.Flags().BoolVar(&withDeps, "with-deps", false, "Include the projects this project depends on")
+	composeConfig.Flags().StringVarP(&output, "output", "o", "", "Write the compose file to this file rather than stdout")
+	return composeConfig
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/wish/dev"
+	"github.com/wish/dev/config"
+)
+
+func newInstancesCommand(devConfig *config.Dev) *cobra.Command {
+	return &cobra.Command{
+		Use:   "instances",
+		Short: "List the instances of the projects",
+		Long: `Lists the instances of the projects that were brought up with
+'dev --instance <name> <project> up', or DEV_INSTANCE, along with how far
+their published ports are moved, their containers and the directory of the
+dev configuration they were last brought up from. The selected instance is
+marked with a '*'.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			summaries, err := dev.ListInstances(devConfig)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(summaries) == 0 {
+				log.Info("No instances, select one with --instance")
+				return
+			}
+			dev.PrintInstances(os.Stdout, summaries, devConfig.Instance)
+		},
+	}
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/wish/dev"
+	"github.com/wish/dev/config"
+)
+
+func newNetworkCommand(devConfig *config.Dev) *cobra.Command {
+	network := &cobra.Command{
+		Use:   "network",
+		Short: "Manage the networks defined in the dev configuration",
+	}
+
+	var lsJSON bool
+	ls := &cobra.Command{
+		Use:   "ls",
+		Short: "List the managed networks and the containers attached to them",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			summaries, err := dev.InspectNetworks(devConfig, nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if lsJSON {
+				printJSON(summaries)
+				return
+			}
+			dev.PrintNetworkList(os.Stdout, summaries)
+		},
+	}
+	ls.Flags().BoolVar(&lsJSON, "json", false, "Output the networks as JSON")
+	network.AddCommand(ls)
+
+	create := &cobra.Command{
+		Use:   "create [network...]",
+		Short: "Create the specified managed networks, or all of them",
+		Args:  cobra.ArbitraryArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				args = devConfig.NetworkNames()
+			}
+			for _, name := range args {
+				opts, ok := devConfig.Networks[name]
+				if !ok {
+					log.Fatalf("%s is not a network managed by dev", name)
+				}
+				dev.NewNetwork(name, opts).Create(devConfig)
+			}
+		},
+	}
+	create.Flags().BoolVar(&devConfig.RecreateNetworks, "recreate-networks", devConfig.RecreateNetworks,
+		"Recreate managed networks whose configuration has changed")
+	network.AddCommand(create)
+
+	var force bool
+	rm := &cobra.Command{
+		Use:   "rm network...",
+		Short: "Remove the specified managed networks",
+		Long: `Removes the specified managed networks. Networks with running containers
+attached are not removed unless --force is given, in which case the containers
+are disconnected from the network first.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			removed, err := dev.RemoveNetworks(devConfig, args, force)
+			for _, name := range removed {
+				fmt.Printf("Removed network %s\n", name)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	rm.Flags().BoolVarP(&force, "force", "f", false, "Remove networks with running containers attached")
+	network.AddCommand(rm)
+
+	var inspectJSON bool
+	inspect := &cobra.Command{
+		Use:   "inspect network...",
+		Short: "Show the settings of managed networks and the containers attached to them",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			summaries, err := dev.InspectNetworks(devConfig, args)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if inspectJSON {
+				printJSON(summaries)
+				return
+			}
+			dev.PrintNetworkDetails(os.Stdout, summaries)
+		},
+	}
+	inspect.Flags().BoolVar(&inspectJSON, "json", false, "Output the networks as JSON")
+	network.AddCommand(inspect)
+
+	prune := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove the managed networks that have no containers attached",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			removed, err := dev.PruneNetworks(devConfig)
+			for _, name := range removed {
+				fmt.Printf("Removed network %s\n", name)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	network.AddCommand(prune)
+
+	return network
+}
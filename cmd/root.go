@@ -1,12 +1,10 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
-	"net/url"
 	"os"
-	"os/exec"
 	"path"
-	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
@@ -18,6 +16,7 @@ import (
 
 	"github.com/wish/dev"
 	"github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
 )
 
 var (
@@ -84,107 +83,274 @@ func createObjectMap(devConfig *config.Dev) map[string]dev.Dependency {
 	return objMap
 }
 
-func dobiAvailable(devConfig *config.Dev) bool {
-	_, err := exec.LookPath("dobi")
+// buildLocally builds the services of the project, returning those built.
+func buildLocally(devConfig *config.Dev, project *dev.Project, services []string) []string {
+	decisions, err := dev.PlanBuild(devConfig, project.Config, services, false)
 	if err != nil {
-		return false
+		log.Errorf("Failed to determine the services to build: %s", err)
+		return []string{}
 	}
-
-	dobiYamlFilename := filepath.Join(devConfig.Dir, "dobi.yaml")
-	if _, err := os.Stat(dobiYamlFilename); os.IsNotExist(err) {
-		return false
+	builder, reason, err := dev.NewBuilder(devConfig, project.Config)
+	if err != nil {
+		log.Error(err)
+		return []string{}
+	}
+	log.Infof("Building %s with %s: %s", project.Name, builder.Name(), reason)
+	if err := builder.Prepare(); err != nil {
+		log.Errorf("Failed to prepare the %s builder: %s", builder.Name(), err)
+		return []string{}
+	}
+	if err := dev.RunBuild(decisions, builder.Build); err != nil {
+		log.Error(err)
+	}
+	if err := dev.RecordBuilds(devConfig, decisions); err != nil {
+		log.Warnf("Failed to record the build of %s: %s", project.Name, err)
 	}
 
-	return true
+	built := []string{}
+	for _, decision := range decisions {
+		// services that were up to date count as built
+		if decision.Built || !decision.Build {
+			built = append(built, decision.Service.Name)
+		}
+	}
+	return built
 }
 
 func addProjectCommands(objMap map[string]dev.Dependency, projectCmd *cobra.Command, devConfig *config.Dev, project *dev.Project) {
+	// profiles are the compose profiles enabled with --profile, in
+	// addition to those of the project
+	var profiles []string
+	enableProfiles := func() {
+		project.Config.Profiles = append(project.Config.Profiles, profiles...)
+	}
+
+	var force, explain bool
 	build := &cobra.Command{
-		Use:   dev.BUILD,
+		Use:   dev.BUILD + " [service...]",
 		Short: "Build the " + project.Name + " container (and its dependencies)",
+		Args:  cobra.ArbitraryArgs,
 		PreRun: func(cmd *cobra.Command, args []string) {
+			enableProfiles()
 			if err := dev.InitDeps(objMap, AppConfig, dev.BUILD, project); err != nil {
 				log.Fatalf("dependency initialization error: %s", err)
 			}
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			if dobiAvailable(devConfig) {
-				// We do have dobi, so we will pull images without Dockerfile
-				// entries via docker-compose
-				dev.RunComposePull(
-					devConfig.ImagePrefix,
-					project.Config.DockerComposeFilenames,
-				)
-
-				// We do have dobi, so we will build images with Dockerfile entries
-				// with dobi.
-				serviceList := dev.CreateBuildableServiceList(devConfig, project.Config)
-				for _, service := range serviceList {
-					dobiYamlFilename := filepath.Join(devConfig.Dir, "dobi.yaml")
-					dev.RunDobi(devConfig.Dir, "-f", dobiYamlFilename, service)
-				}
-			} else {
-				// No Dobi. Just pass command to docker-compose
-				dev.RunComposeBuild(
-					devConfig.ImagePrefix,
-					project.Config.DockerComposeFilenames,
-				)
+			decisions, err := dev.PlanBuild(devConfig, project.Config, args, force)
+			if err != nil {
+				log.Fatalf("Failed to determine the services to build: %s", err)
+			}
+			if explain {
+				dev.PrintBuildPlan(os.Stdout, decisions)
+			}
+			if len(dev.ServicesToBuild(decisions)) == 0 {
+				log.Infof("%s is up to date, use --force to rebuild it", project.Name)
 				return
 			}
 
+			builder, reason, err := dev.NewBuilder(devConfig, project.Config)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Infof("Building %s with %s: %s", project.Name, builder.Name(), reason)
+			if err := builder.Prepare(); err != nil {
+				log.Fatalf("Failed to prepare the %s builder: %s", builder.Name(), err)
+			}
+
+			// record what was built even if a later stage fails, so it
+			// is not rebuilt on the next attempt
+			buildErr := dev.RunBuild(decisions, builder.Build)
+			if err := dev.RecordBuilds(devConfig, decisions); err != nil {
+				log.Warnf("Failed to record the build of %s: %s", project.Name, err)
+			}
+			if buildErr != nil {
+				log.Fatal(buildErr)
+			}
 		},
 	}
+	build.Flags().BoolVar(&force, "force", false, "Build every service, even those that have not changed")
+	build.Flags().BoolVar(&explain, "explain", false, "Print why each service is, or is not, built")
+	addProfileFlag(build, &profiles)
 	projectCmd.AddCommand(build)
 
+	var noBuild bool
 	download := &cobra.Command{
-		Use:   dev.DOWNLOAD,
+		Use:   dev.DOWNLOAD + " [service...]",
 		Short: "Download the " + project.Name + " container (and its dependencies)",
+		Args:  cobra.ArbitraryArgs,
 		PreRun: func(cmd *cobra.Command, args []string) {
 			if err := dev.InitDeps(objMap, AppConfig, dev.DOWNLOAD, project); err != nil {
 				log.Fatalf("dependency initialization error: %s", err)
 			}
 		},
 		Run: func(cmd *cobra.Command, args []string) {
+			images, err := dev.PlanDownload(devConfig, project.Config, args)
+			if err != nil {
+				log.Fatal(err)
+			}
+
 			// We will pull images without Dockerfile entries via docker-compose
-			dev.RunComposePull(
-				devConfig.ImagePrefix,
-				project.Config.DockerComposeFilenames,
-			)
+			files, err := dev.ComposeFiles(devConfig, project.Config)
+			if err != nil {
+				log.Fatal(err)
+			}
+			dev.RunComposePull(devConfig.ImagePrefix, dev.ProjectProfiles(project.Config), files)
+			dev.RunDownload(devConfig, images, nil)
+
+			failed := dev.FailedDownloads(images)
+			built := []string{}
+			if len(failed) > 0 && !noBuild {
+				log.Infof("Building %s locally instead", strings.Join(failed, ", "))
+				built = buildLocally(devConfig, project, failed)
+			}
+			dev.PrintDownloadSummary(os.Stdout, images, built)
+			if len(failed) > len(built) {
+				log.Fatalf("Failed to download %s", project.Name)
+			}
+		},
+	}
+	download.Flags().BoolVar(&noBuild, "no-build", false, "Do not build the services whose image could not be downloaded")
+	projectCmd.AddCommand(download)
 
-			// We do have dobi, so we will build images with Dockerfile entries
-			// with dobi.
-			serviceList := dev.CreateBuildableServiceList(devConfig, project.Config)
-			for _, service := range serviceList {
-				for _, opts := range devConfig.Registries {
-					registry := dev.NewRegistry(opts)
-
-					if registry.Config.DownloadPath == "" {
-						log.Fatalf("No download_path specified in .dev.yaml. Is .dev.yaml out of date? Consider pulling master.")
-					}
-
-					u, err := url.Parse(registry.Config.URL)
-					if err != nil {
-						log.Fatal(err)
-					}
-
-					remote := path.Join(u.Host, registry.Config.DownloadPath, service) + ":current"
-					localTag := devConfig.ImagePrefix + "_" + service
-
-					dev.RunDockerPull(remote)
-					dev.RunDockerTag(remote, localTag)
-					if cmd := registry.Config.PostDownloadCommand; cmd != "" {
-						dev.RunCommandInDir(devConfig.Dir, cmd, []string{localTag})
-					}
+	var update bool
+	lock := &cobra.Command{
+		Use:   dev.LOCK + " [service...]",
+		Short: "Pin the images of " + project.Name + " to their digests in " + dev.LockFilename,
+		Long: `Resolves the images of the services of the project, those pulled by compose and
+those downloaded, to their digests and records them in the lock file next to the
+dev configuration. Images already locked are kept unless --update is given, in
+which case the images of the services named, or of all services, are resolved
+again. up, build and download use the pinned digests.`,
+		Args: cobra.ArbitraryArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if err := dev.InitDeps(objMap, AppConfig, dev.LOCK, project); err != nil {
+				log.Fatalf("dependency initialization error: %s", err)
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 0 && !update {
+				log.Fatal("Services can only be named with --update")
+			}
+			lockFile, err := dev.LoadLock(devConfig)
+			if err != nil {
+				log.Fatal(err)
+			}
+			changed, err := dev.UpdateLock(devConfig, project.Config, lockFile, update, args, dev.ResolveDigest(devConfig))
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := lockFile.Save(); err != nil {
+				log.Fatal(err)
+			}
+			if len(changed) == 0 {
+				log.Infof("The images of %s are already locked", project.Name)
+			} else {
+				log.Infof("Locked %s", strings.Join(changed, ", "))
+			}
+		},
+	}
+	lock.Flags().BoolVar(&update, "update", false, "Resolve the images that are already locked again")
+	projectCmd.AddCommand(lock)
+
+	save := &cobra.Command{
+		Use:   dev.SAVE + " <bundle>",
+		Short: "Export the images of " + project.Name + " (and its dependencies) to a bundle",
+		Long: `Saves every image the project and the projects it depends on need to run, those
+pulled by compose and those built or downloaded, into one archive with a manifest
+of them. 'dev load' imports the bundle on another host so that the project can be
+brought up without a registry.`,
+		Args: cobra.ExactArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if err := dev.InitDeps(objMap, AppConfig, dev.SAVE, project); err != nil {
+				log.Fatalf("dependency initialization error: %s", err)
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			deps, err := dev.DependencyProjects(objMap, project)
+			if err != nil {
+				log.Fatal(err)
+			}
+			names := []string{}
+			configs := []*config.Project{}
+			for _, p := range append(deps, project) {
+				names = append(names, p.Name)
+				configs = append(configs, p.Config)
+			}
+			images, err := dev.BundleImages(devConfig, configs)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := dev.SaveBundle(devConfig, args[0], names, images); err != nil {
+				log.Fatal(err)
+			}
+			log.Infof("Saved %d images of %s to %s", len(images), strings.Join(names, ", "), args[0])
+		},
+	}
+	projectCmd.AddCommand(save)
+
+	var pull bool
+	outdated := &cobra.Command{
+		Use:   dev.OUTDATED + " [service...]",
+		Short: "Compare the images of " + project.Name + " with those of their registries",
+		Long: `Compares the digest of the local image of each service pulled by compose or
+downloaded with the digest of its tag in its registry, using the registry HTTP API
+and the credentials of the configured registries, and reports the services that
+are behind and by how long. With --pull the outdated images are pulled.`,
+		Args: cobra.ArbitraryArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			// the registries are only logged in to by docker to pull
+			if !pull {
+				return
+			}
+			if err := dev.InitDeps(objMap, AppConfig, dev.OUTDATED, project); err != nil {
+				log.Fatalf("dependency initialization error: %s", err)
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			images, err := dev.CheckOutdated(devConfig, project.Config, args)
+			if err != nil {
+				log.Fatal(err)
+			}
+			dev.PrintOutdated(os.Stdout, images)
+			if pull {
+				if err := dev.PullOutdated(devConfig, images); err != nil {
+					log.Fatal(err)
 				}
 			}
 		},
 	}
-	projectCmd.AddCommand(download)
+	outdated.Flags().BoolVar(&pull, "pull", false, "Pull the images that are outdated")
+	projectCmd.AddCommand(outdated)
+	projectCmd.AddCommand(newSnapshotCommand(devConfig, project))
+	projectCmd.AddCommand(newComposeConfigCommand(objMap, devConfig, project))
 
 	up := &cobra.Command{
 		Use:   dev.UP,
 		Short: "Create and start the " + project.Name + " containers",
 		PreRun: func(cmd *cobra.Command, args []string) {
+			enableProfiles()
+			deps, err := dev.DependencyProjects(objMap, project)
+			if err != nil {
+				log.Fatal(err)
+			}
+			configs := []*config.Project{}
+			for _, p := range append(deps, project) {
+				configs = append(configs, p.Config)
+			}
+			if err := dev.PrepareInstance(devConfig, configs); err != nil {
+				log.Fatal(err)
+			}
+			conflicts, err := dev.PreparePorts(devConfig, configs, devConfig.PortOffset)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(conflicts) > 0 {
+				for _, conflict := range conflicts {
+					log.Errorf("The %s", conflict)
+				}
+				log.Fatal("Stop what uses the ports, or set port_offset or --port-offset to publish them on other ports")
+			}
 			if err := dev.InitDeps(objMap, AppConfig, dev.UP, project); err != nil {
 				log.Fatalf("dependency initialization error: %s", err)
 			}
@@ -193,20 +359,50 @@ func addProjectCommands(objMap map[string]dev.Dependency, projectCmd *cobra.Comm
 			project.UpFollowProjectLogs(AppConfig)
 		},
 	}
+	up.Flags().BoolVar(&devConfig.RecreateNetworks, "recreate-networks", devConfig.RecreateNetworks,
+		"Recreate managed networks whose configuration has changed")
+	up.Flags().IntVar(&devConfig.PortOffset, "port-offset", devConfig.PortOffset,
+		"Publish conflicting host ports on ports moved by multiples of the offset")
+	addProfileFlag(up, &profiles)
 	projectCmd.AddCommand(up)
 
 	ps := &cobra.Command{
 		Use:   dev.PS,
 		Short: "List status of " + project.Name + " containers",
 		Run: func(cmd *cobra.Command, args []string) {
+			enableProfiles()
 			dev.RunComposePs(
 				devConfig.ImagePrefix,
+				dev.ProjectProfiles(project.Config),
 				project.Config.DockerComposeFilenames,
 			)
 		},
 	}
+	addProfileFlag(ps, &profiles)
 	projectCmd.AddCommand(ps)
 
+	logOpts := &dev.LogOptions{}
+	var noFollow bool
+	logs := &cobra.Command{
+		Use:   dev.LOGS + " [service...]",
+		Short: "View output from the " + project.Name + " containers",
+		Long: `Shows the logs of the project containers and follows them. With --with-deps the
+logs of the project and all the projects it depends on are aggregated into one
+stream ordered by timestamp.`,
+		Args: cobra.ArbitraryArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			logOpts.Services = args
+			logOpts.Follow = !noFollow
+			project.Logs(AppConfig, objMap, logOpts)
+		},
+	}
+	logs.Flags().StringVar(&logOpts.Since, "since", "", "Show logs since timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m for 42 minutes)")
+	logs.Flags().StringVar(&logOpts.Tail, "tail", "all", "Number of lines to show from the end of the logs for each container")
+	logs.Flags().StringVar(&logOpts.Grep, "grep", "", "Only show lines matching the regular expression")
+	logs.Flags().BoolVar(&noFollow, "no-follow", false, "Do not follow log output")
+	logs.Flags().BoolVar(&logOpts.WithDeps, "with-deps", false, "Include the logs of the projects this project depends on")
+	projectCmd.AddCommand(logs)
+
 	sh := &cobra.Command{
 		Use:   dev.SH,
 		Short: "Get a shell on the " + project.Name + " container",
@@ -234,15 +430,18 @@ its docker-compose.yml file is placed. It does not stop or destroy any container
 may have been brought up to support this project, which is the case for projects that
 use more one docker-compose.yml file.`,
 		Run: func(cmd *cobra.Command, args []string) {
+			enableProfiles()
 			i := len(project.Config.DockerComposeFilenames)
 			// for now we assume the non-shared config is last
 			// compose file listed. Needs fixing.
 			dev.RunComposeDown(
 				devConfig.ImagePrefix,
+				dev.ProjectProfiles(project.Config),
 				[]string{project.Config.DockerComposeFilenames[i-1]},
 			)
 		},
 	}
+	addProfileFlag(down, &profiles)
 	projectCmd.AddCommand(down)
 
 	alldown := &cobra.Command{
@@ -254,6 +453,7 @@ may have been brought up to support this project.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			dev.RunComposeDown(
 				devConfig.ImagePrefix,
+				dev.ProjectProfiles(project.Config),
 				project.Config.DockerComposeFilenames,
 			)
 		},
@@ -275,6 +475,13 @@ may have been brought up to support this project.`,
 
 }
 
+// addProfileFlag adds the --profile flag enabling compose profiles to the
+// command.
+func addProfileFlag(cmd *cobra.Command, profiles *[]string) {
+	cmd.Flags().StringSliceVar(profiles, "profile", nil,
+		"Enable the services of the compose profile, in addition to the profiles of the project")
+}
+
 func addProjects(objMap map[string]dev.Dependency, cmd *cobra.Command, config *config.Dev) error {
 	for _, projectConfig := range config.Projects {
 		log.Debugf("Adding %s to project commands, aliases: %s", projectConfig.Name, projectConfig.Aliases)
@@ -292,6 +499,26 @@ func addProjects(objMap map[string]dev.Dependency, cmd *cobra.Command, config *c
 	return nil
 }
 
+// printJSON writes v to stdout as indented JSON for commands with a --json
+// option.
+func printJSON(v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(b))
+}
+
+// addCommands adds the commands that are not specific to a project.
+func addCommands(cmd *cobra.Command, devConfig *config.Dev) {
+	cmd.AddCommand(newStatusCommand(devConfig))
+	cmd.AddCommand(newDoctorCommand(devConfig))
+	cmd.AddCommand(newNetworkCommand(devConfig))
+	cmd.AddCommand(newLoadCommand(devConfig))
+	cmd.AddCommand(newCleanCommand(devConfig))
+	cmd.AddCommand(newInstancesCommand(devConfig))
+}
+
 func checkMinimumVersion() {
 	mv := AppConfig.MinimumVersion
 	if mv != "" && mv > BuildVersion {
@@ -312,25 +539,96 @@ func checkMinimumVersion() {
 	}
 }
 
-func dockerComposeInstalled() bool {
-	// The docker-compose v2 binary can be in a few different places.
-	// Let's actually try running the command and check the exit code to see if it's
+func composeInstalled(containerRuntime *docker.Runtime) bool {
+	// The compose binary can be in a few different places. Let's actually
+	// try running the command and check the exit code to see if it's
 	// installed properly.
-	cmd := exec.Command("docker", "compose")
+	if err := containerRuntime.ComposeInstalled(); err != nil {
+		log.Debug(err)
+		return false
+	}
+	return true
+}
 
-	if err := cmd.Start(); err != nil {
-		log.Fatalf("cmd.Start: %v", err)
+// configureRuntime selects the container runtime, from the DEV_RUNTIME
+// environment variable or else the configuration.
+func configureRuntime(devConfig *config.Dev) *docker.Runtime {
+	if name := viper.GetString("RUNTIME"); name != "" {
+		devConfig.Runtime = name
 	}
+	containerRuntime, err := docker.LookupRuntime(devConfig.Runtime)
+	if err != nil {
+		log.Fatal(err)
+	}
+	docker.SetRuntime(containerRuntime)
+	log.Debugf("Using the %s runtime", containerRuntime.Name)
+	return containerRuntime
+}
 
-	if err := cmd.Wait(); err != nil {
-		if exiterr, ok := err.(*exec.ExitError); ok {
-			log.Debugf("Exit Status: %d", exiterr.ExitCode())
-		} else {
-			log.Fatalf("cmd.Wait: %v", err)
+// invokedCommand returns the name of the top level command specified on the
+// command line. Commands are not known until Initialize completes, so the
+// first argument that is not a flag is assumed to be the command.
+func invokedCommand(args []string) string {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			return arg
 		}
-		return false
 	}
-	return true
+	return ""
+}
+
+// instanceFlag selects the instance of the projects a command runs in.
+const instanceFlag = "--instance"
+
+// instanceArgs returns the instance selected by the --instance flag given
+// before the command and the command line arguments without it. The flag is
+// not a cobra flag as global flags would end up in the arguments of the sh
+// command, which does not parse its flags.
+func instanceArgs(args []string) (string, []string, error) {
+	instance := ""
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			return instance, append(rest, args[i:]...), nil
+		}
+		switch {
+		case arg == instanceFlag:
+			if i+1 == len(args) {
+				return "", nil, fmt.Errorf("flag needs an argument: %s", instanceFlag)
+			}
+			i++
+			instance = args[i]
+		case strings.HasPrefix(arg, instanceFlag+"="):
+			instance = strings.TrimPrefix(arg, instanceFlag+"=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return instance, rest, nil
+}
+
+// configureInstance selects the instance of the projects: the one of the
+// --instance flag, else of the DEV_INSTANCE environment variable, else, if
+// enabled, the one named after the git worktree of the configuration.
+func configureInstance(devConfig *config.Dev, instance string) {
+	if instance == "" {
+		instance = viper.GetString("INSTANCE")
+	}
+	if instance == "" && devConfig.WorktreeInstances {
+		worktree, err := dev.WorktreeInstance(devConfig.GetFs(), devConfig.Dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		instance = worktree
+	}
+	if instance == "" {
+		return
+	}
+	if err := devConfig.SetInstance(instance); err != nil {
+		log.Fatal(err)
+	}
+	log.Debugf("Using instance '%s'", instance)
 }
 
 // Initialize parses and loads the dev configuration file, bootstrapping the
@@ -346,6 +644,19 @@ func Initialize() {
 	if err := viper.BindEnv("LOGS"); err != nil {
 		log.Fatalf("error binding to DEV_LOGS environment variable: %s", err)
 	}
+	if err := viper.BindEnv("RUNTIME"); err != nil {
+		log.Fatalf("error binding to DEV_RUNTIME environment variable: %s", err)
+	}
+	if err := viper.BindEnv("INSTANCE"); err != nil {
+		log.Fatalf("error binding to DEV_INSTANCE environment variable: %s", err)
+	}
+	instance, args, err := instanceArgs(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) < len(os.Args)-1 {
+		rootCmd.SetArgs(args)
+	}
 
 	// XXX: no global command line flags (persistentFlags) b/c they
 	// DisableFlagParsing is set for the 'sh' command so users do not have to
@@ -363,14 +674,20 @@ func Initialize() {
 	}
 
 	checkMinimumVersion()
+	docker.SetTimeout(time.Duration(AppConfig.DockerTimeoutSeconds) * time.Second)
 
-	if !dockerComposeInstalled() {
-		log.Fatalf("dev requires docker-compose. See https://docs.docker.com/compose/install/")
+	containerRuntime := configureRuntime(AppConfig)
+	if !composeInstalled(containerRuntime) {
+		if invokedCommand(args) != doctorCommand {
+			log.Fatalf("dev requires %s compose. See https://docs.docker.com/compose/install/", containerRuntime.CLI)
+		}
 	}
 
+	configureInstance(AppConfig, instance)
+
 	// removes the annoying: WARNING: Found orphan containers
 	// for this project.
-	err := os.Setenv("COMPOSE_IGNORE_ORPHANS", "True")
+	err = os.Setenv("COMPOSE_IGNORE_ORPHANS", "True")
 	if err != nil {
 		log.Fatalf("Failed to set environment variable: %s", err)
 	}
@@ -381,6 +698,7 @@ func Initialize() {
 	if err := addProjects(objMap, rootCmd, AppConfig); err != nil {
 		log.Fatalf("Error adding projects: %s", err)
 	}
+	addCommands(rootCmd, AppConfig)
 }
 
 // getDefaultConfigDirectory returns the configuration directory of this tool.
@@ -1,7 +1,7 @@
 package cmd
 
 import (
-	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/sirupsen/logrus"
@@ -12,6 +12,9 @@ import (
 	"gotest.tools/v3/env"
 )
 
+// globalCommands are the commands added whether or not there is a config.
+var globalCommands = []string{"status", "doctor", "network", "load", "clean", "instances"}
+
 func TestInitializeWithoutDockerComposeInstalled(t *testing.T) {
 	defer env.Patch(t, "DEV_CONFIG", "/home/test/.dev.yaml")()
 	defer env.Patch(t, "PATH", "/usr/bin:/usr/local/bin:/sbin")()
@@ -81,7 +84,7 @@ func TestInitializeWithDevConfigSet(t *testing.T) {
 				t.Errorf("Expected to find '%s' sub-command of %s, but got nil", subCmd, test.ProjectName)
 			}
 
-			if sCmd.Use != subCmd {
+			if sCmd.Name() != subCmd {
 				t.Errorf("Expected cmd to be named '%s', but got '%s'", subCmd, sCmd.Short)
 			}
 		}
@@ -140,7 +143,7 @@ func TestInitializeWithoutDevConfigSet(t *testing.T) {
 				t.Errorf("Expected to find '%s' sub-command of %s, but got nil", subCmd, test.ProjectName)
 			}
 
-			if sCmd.Use != subCmd {
+			if sCmd.Name() != subCmd {
 				t.Errorf("Expected cmd to be named '%s', but got '%s'", subCmd, sCmd.Short)
 			}
 		}
@@ -159,11 +162,41 @@ func TestInitializeWithoutConfig(t *testing.T) {
 
 	Initialize()
 
+	// only the commands that are not specific to a project are expected
 	numCommands := len(rootCmd.Commands())
-	if numCommands != 0 {
-		t.Errorf("Expected 0 commands without a config, but got %d", numCommands)
+	if numCommands != len(globalCommands) {
+		t.Errorf("Expected %d commands without a config, but got %d", len(globalCommands), numCommands)
 	}
 	for _, cmd := range rootCmd.Commands() {
-		fmt.Printf("cmd %s", cmd.Use)
+		if !dev.SliceContainsString(globalCommands, cmd.Name()) {
+			t.Errorf("Unexpected command without a config: %s", cmd.Use)
+		}
+	}
+}
+
+func TestInstanceArgs(t *testing.T) {
+	tests := []struct {
+		Args     []string
+		Instance string
+		Rest     []string
+	}{
+		{[]string{"app", "up"}, "", []string{"app", "up"}},
+		{[]string{"--instance", "feature-x", "app", "up"}, "feature-x", []string{"app", "up"}},
+		{[]string{"--instance=feature-x", "app", "sh", "--instance", "y"}, "feature-x",
+			[]string{"app", "sh", "--instance", "y"}},
+		{[]string{"--help", "--instance", "x"}, "x", []string{"--help"}},
+	}
+	for _, tc := range tests {
+		instance, rest, err := instanceArgs(tc.Args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance != tc.Instance || !reflect.DeepEqual(rest, tc.Rest) {
+			t.Errorf("Expected instance '%s' and args %v for %v, got '%s' and %v",
+				tc.Instance, tc.Rest, tc.Args, instance, rest)
+		}
+	}
+	if _, _, err := instanceArgs([]string{"--instance"}); err == nil {
+		t.Error("Expected an error without an instance")
 	}
 }
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/wish/dev"
+	"github.com/wish/dev/config"
+)
+
+func newSnapshotCommand(devConfig *config.Dev, project *dev.Project) *cobra.Command {
+	snapshot := &cobra.Command{
+		Use:   dev.SNAPSHOT,
+		Short: "Save and restore the data of the " + project.Name + " volumes",
+		Long: `Archives the named volumes declared in the docker-compose files of the project
+into snapshots stored in dev's local state directory, and restores them. The
+services that mount the volumes are stopped while they are archived or restored
+and started again afterwards.`,
+	}
+
+	var force bool
+	save := &cobra.Command{
+		Use:   "save <name>",
+		Short: "Save the volumes of " + project.Name + " to a snapshot",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			saved, err := dev.SaveSnapshot(devConfig, project.Config, args[0], force)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Infof("Saved %d volumes of %s to snapshot %s", len(saved.Volumes), project.Name, saved.Name)
+		},
+	}
+	save.Flags().BoolVar(&force, "force", false, "Replace the snapshot if it exists")
+	snapshot.AddCommand(save)
+
+	restore := &cobra.Command{
+		Use:   "restore <name>",
+		Short: "Replace the data of the volumes of " + project.Name + " with a snapshot",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			restored, err := dev.RestoreSnapshot(devConfig, project.Config, args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Infof("Restored %d volumes of %s from snapshot %s", len(restored.Volumes), project.Name, restored.Name)
+		},
+	}
+	snapshot.AddCommand(restore)
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the snapshots of " + project.Name,
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			snapshots, err := dev.ListSnapshots(devConfig, project.Config)
+			if err != nil {
+				log.Fatal(err)
+			}
+			dev.PrintSnapshots(os.Stdout, snapshots)
+		},
+	}
+	snapshot.AddCommand(list)
+
+	rm := &cobra.Command{
+		Use:   "rm <name>...",
+		Short: "Remove snapshots of " + project.Name,
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, name := range args {
+				if err := dev.RemoveSnapshot(devConfig, project.Config, name); err != nil {
+					log.Fatal(err)
+				}
+			}
+		},
+	}
+	snapshot.AddCommand(rm)
+
+	return snapshot
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/wish/dev"
+	"github.com/wish/dev/config"
+)
+
+// clearScreen moves the cursor to the top left of the terminal and clears it.
+const clearScreen = "\033[H\033[2J"
+
+func newStatusCommand(devConfig *config.Dev) *cobra.Command {
+	var asJSON bool
+	var watch bool
+	var interval time.Duration
+
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "Show the state of all projects, networks and registries",
+		Long: `Shows the state, health, uptime, published ports and image age of the services of
+every configured project, along with the state of the networks and registry
+logins managed by dev.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			for {
+				status, err := dev.CollectStatus(devConfig)
+				if err != nil {
+					log.Fatalf("Error communicating with docker daemon, is it up? %s", err)
+				}
+
+				if asJSON {
+					printJSON(status)
+				} else {
+					if watch {
+						fmt.Print(clearScreen)
+					}
+					status.Print(os.Stdout)
+				}
+
+				if !watch {
+					return
+				}
+				time.Sleep(interval)
+			}
+		},
+	}
+	status.Flags().BoolVar(&asJSON, "json", false, "Output the status as JSON")
+	status.Flags().BoolVarP(&watch, "watch", "w", false, "Refresh the status until interrupted")
+	status.Flags().DurationVar(&interval, "interval", 2*time.Second, "Refresh interval used with --watch")
+
+	return status
+}
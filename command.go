@@ -7,6 +7,7 @@ import (
 
 	"github.com/mattn/go-isatty"
 	log "github.com/sirupsen/logrus"
+	"github.com/wish/dev/docker"
 )
 
 // Command is a wrapper around exec.Command so we can substitute a test version
@@ -42,6 +43,31 @@ func RunCommandInDir(cwd string, name string, args []string) error {
 	return command.Run()
 }
 
+// outputCommand is a Command whose output can be read.
+type outputCommand interface {
+	Output() ([]byte, error)
+}
+
+// runCommandOutput runs the command in the directory and returns its
+// output, which is not shown.
+func runCommandOutput(cwd string, name string, args ...string) (string, error) {
+	log.Debugf("Running: %s %s", name, strings.Join(args, " "))
+	var command Command
+	if cmdExecutor == nil {
+		cmd := exec.Command(name, args...)
+		cmd.Dir = cwd
+		command = cmd
+	} else {
+		command = cmdExecutor(name, args...)
+	}
+	output, ok := command.(outputCommand)
+	if !ok {
+		return "", command.Run()
+	}
+	b, err := output.Output()
+	return string(b), err
+}
+
 func RunCommand(name string, args []string) error {
 	path, err := os.Getwd()
 	if err != nil {
@@ -50,14 +76,18 @@ func RunCommand(name string, args []string) error {
 	return RunCommandInDir(path, name, args)
 }
 
-// RunDockerCompose runs docker-compose with the specified subcommand and
-// arguments.
-func runDockerCompose(cmd, project string, composePaths []string, args ...string) {
-	cmdLine := []string{"compose", "--compatibility", "-p", project}
+// RunDockerCompose runs the compose front-end of the container runtime with
+// the specified subcommand and arguments, enabling the compose profiles.
+func runDockerCompose(cmd, project string, profiles []string, composePaths []string, args ...string) error {
+	runtime := docker.CurrentRuntime()
+	cmdLine := runtime.ComposeArgs("-p", project)
 
 	for _, path := range composePaths {
 		cmdLine = append(cmdLine, "-f", path)
 	}
+	for _, profile := range profiles {
+		cmdLine = append(cmdLine, "--profile", profile)
+	}
 	// one of build, exec, etc.
 	cmdLine = append(cmdLine, cmd)
 
@@ -66,47 +96,59 @@ func runDockerCompose(cmd, project string, composePaths []string, args ...string
 		cmdLine = append(cmdLine, arg)
 	}
 
-	RunCommand("docker", cmdLine)
+	return RunCommand(runtime.CLI, cmdLine)
 }
 
 // RunComposeBuild runs docker-compose build with the specified docker compose
-// files and args.
-func RunComposeBuild(project string, composePaths []string, args ...string) {
-	runDockerCompose("build", project, composePaths, args...)
+// files, profiles and args.
+func RunComposeBuild(project string, profiles []string, composePaths []string, args ...string) error {
+	return runDockerCompose("build", project, profiles, composePaths, args...)
 }
 
 // RunComposePull runs docker-compose build with the specified docker compose
-// files and args.
-func RunComposePull(project string, composePaths []string, args ...string) {
-	runDockerCompose("pull", project, composePaths, args...)
+// files, profiles and args.
+func RunComposePull(project string, profiles []string, composePaths []string, args ...string) {
+	runDockerCompose("pull", project, profiles, composePaths, args...)
 }
 
 // RunComposeUp runs docker-compose up with the specified docker compose
-// files and args.
-func RunComposeUp(project string, composePaths []string, args ...string) {
-	runDockerCompose("up", project, composePaths, args...)
+// files, profiles and args.
+func RunComposeUp(project string, profiles []string, composePaths []string, args ...string) {
+	runDockerCompose("up", project, profiles, composePaths, args...)
 }
 
 // RunComposePs runs docker-compose ps with the specified docker compose
-// files and args.
-func RunComposePs(project string, composePaths []string, args ...string) {
-	runDockerCompose("ps", project, composePaths, args...)
+// files, profiles and args.
+func RunComposePs(project string, profiles []string, composePaths []string, args ...string) {
+	runDockerCompose("ps", project, profiles, composePaths, args...)
 }
 
 // RunComposeLogs runs docker-compose logs with the specified docker compose
-// files and args.
-func RunComposeLogs(project string, composePaths []string, args ...string) {
-	runDockerCompose("logs", project, composePaths, args...)
+// files, profiles and args.
+func RunComposeLogs(project string, profiles []string, composePaths []string, args ...string) {
+	runDockerCompose("logs", project, profiles, composePaths, args...)
+}
+
+// RunComposeStop runs docker-compose stop with the specified docker compose
+// files, profiles and args.
+func RunComposeStop(project string, profiles []string, composePaths []string, args ...string) error {
+	return runDockerCompose("stop", project, profiles, composePaths, args...)
+}
+
+// RunComposeStart runs docker-compose start with the specified docker compose
+// files, profiles and args.
+func RunComposeStart(project string, profiles []string, composePaths []string, args ...string) error {
+	return runDockerCompose("start", project, profiles, composePaths, args...)
 }
 
 // RunComposeDown runs docker-compose down with the specified docker compose
-// files and args.
-func RunComposeDown(project string, composePaths []string, args ...string) {
-	runDockerCompose("down", project, composePaths, args...)
+// files, profiles and args.
+func RunComposeDown(project string, profiles []string, composePaths []string, args ...string) {
+	runDockerCompose("down", project, profiles, composePaths, args...)
 }
 
 // RunOnContainer runs the commands on the container with the specified
-// name using the 'docker' command.
+// name using the cli of the container runtime.
 func RunOnContainer(containerName string, cmds ...string) {
 	cmdLine := []string{"exec"}
 
@@ -121,7 +163,7 @@ func RunOnContainer(containerName string, cmds ...string) {
 		cmdLine = append(cmdLine, cmd)
 	}
 
-	err := RunCommand("docker", cmdLine)
+	err := RunCommand(docker.CurrentRuntime().CLI, cmdLine)
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			os.Exit(exitError.ExitCode())
@@ -132,20 +174,28 @@ func RunOnContainer(containerName string, cmds ...string) {
 }
 
 // RunDobi runs dobi build with the specified args
-func RunDobi(dir string, args ...string) {
+func RunDobi(dir string, args ...string) error {
 	// Unlike docker-compose, dobi needs to run in the same directory as
 	// the project.
-	RunCommandInDir(dir, "dobi", args)
+	return RunCommandInDir(dir, "dobi", args)
+}
+
+// RunDockerPull runs docker pull quietly with the specified remote image, so
+// that the output of pulls run in parallel is not interleaved.
+func RunDockerPull(img string) error {
+	cmdLine := []string{"pull", "--quiet", img}
+	return RunCommand(docker.CurrentRuntime().CLI, cmdLine)
 }
 
-// RunDockerPull runs docker pull with the specified remote image
-func RunDockerPull(img string) {
-	cmdLine := []string{"pull", img}
-	RunCommand("docker", cmdLine)
+// RunDockerRun runs docker run with the specified args, removing the container
+// once it exits.
+func RunDockerRun(args ...string) error {
+	cmdLine := append([]string{"run", "--rm"}, args...)
+	return RunCommand(docker.CurrentRuntime().CLI, cmdLine)
 }
 
-// RunDockerPull runs docker tag
-func RunDockerTag(from string, to string) {
+// RunDockerTag runs docker tag
+func RunDockerTag(from string, to string) error {
 	cmdLine := []string{"tag", from, to}
-	RunCommand("docker", cmdLine)
+	return RunCommand(docker.CurrentRuntime().CLI, cmdLine)
 }
@@ -2,7 +2,13 @@ package dev
 
 import (
 	"io"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/spf13/afero"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
 )
 
 type TestCommander struct {
@@ -11,14 +17,26 @@ type TestCommander struct {
 	Stdout io.Writer
 	Stderr io.Writer
 	Stdin  io.Writer
+	// Commands are the command lines of the commands created.
+	Commands []string
+	// Outputs are the outputs of the commands, by command line.
+	Outputs map[string]string
+
+	line string
 }
 
 func (tc *TestCommander) Run() error {
 	return nil
 }
 
+func (tc *TestCommander) Output() ([]byte, error) {
+	return []byte(tc.Outputs[tc.line]), nil
+}
+
 func (tc *TestCommander) NewCommand(name string, args ...string) Command {
 	tc.Path = name
+	tc.line = strings.Join(append([]string{name}, args...), " ")
+	tc.Commands = append(tc.Commands, tc.line)
 
 	for _, arg := range args {
 		tc.Args = append(tc.Args, arg)
@@ -37,6 +55,22 @@ func setup() {
 	tc = TestCommander{}
 }
 
+// testConfig returns a configuration with the dev image prefix, a fake engine
+// and an in-memory filesystem with the files, in which the projects are
+// configured.
+func testConfig(t *testing.T, files map[string]string, projects ...*c.Project) (*c.Dev, *docker.FakeEngine) {
+	appConfig := c.NewConfig()
+	appConfig.ImagePrefix = "dev"
+	engine := docker.NewFakeEngine()
+	appConfig.SetEngine(engine)
+	appConfig.SetFs(afero.NewMemMapFs())
+	writeFiles(t, appConfig.GetFs(), files)
+	for _, project := range projects {
+		appConfig.Projects[project.Name] = project
+	}
+	return appConfig, engine
+}
+
 func TestRunComposeBuild(t *testing.T) {
 	tests := []struct {
 		Project      string
@@ -52,7 +86,7 @@ func TestRunComposeBuild(t *testing.T) {
 		setup()
 		setExecutor(tc.NewCommand)
 
-		RunComposeBuild(test.Project, test.ComposePaths, test.Args...)
+		RunComposeBuild(test.Project, nil, test.ComposePaths, test.Args...)
 
 		if tc.Path != "docker" {
 			t.Errorf("Expected path be %s but got %s", "docker", tc.Path)
@@ -75,19 +109,21 @@ func TestRunComposeBuild(t *testing.T) {
 func TestRunComposeUp(t *testing.T) {
 	tests := []struct {
 		Project      string
+		Profiles     []string
 		ComposePaths []string
 		Args         []string
 		Expected     []string
 	}{
-		{"foo", []string{"/foo/bar/baz"}, []string{}, []string{"compose", "--compatibility", "-p", "foo", "-f", "/foo/bar/baz", "up"}},
-		{"far", []string{"/foo/bar/baz", "/boo/far/faz"}, []string{"--no-skippy"}, []string{"compose", "--compatibility", "-p", "far", "-f", "/foo/bar/baz", "-f", "/boo/far/faz", "up", "--no-skippy"}},
+		{"foo", nil, []string{"/foo/bar/baz"}, []string{}, []string{"compose", "--compatibility", "-p", "foo", "-f", "/foo/bar/baz", "up"}},
+		{"far", nil, []string{"/foo/bar/baz", "/boo/far/faz"}, []string{"--no-skippy"}, []string{"compose", "--compatibility", "-p", "far", "-f", "/foo/bar/baz", "-f", "/boo/far/faz", "up", "--no-skippy"}},
+		{"foo", []string{"debug", "mocks"}, []string{"/foo/bar/baz"}, []string{"-d"}, []string{"compose", "--compatibility", "-p", "foo", "-f", "/foo/bar/baz", "--profile", "debug", "--profile", "mocks", "up", "-d"}},
 	}
 
 	for _, test := range tests {
 		setup()
 		setExecutor(tc.NewCommand)
 
-		RunComposeUp(test.Project, test.ComposePaths, test.Args...)
+		RunComposeUp(test.Project, test.Profiles, test.ComposePaths, test.Args...)
 
 		if tc.Path != "docker" {
 			t.Errorf("Expected path be %s but got %s", "docker", tc.Path)
@@ -121,7 +157,7 @@ func TestRunComposePs(t *testing.T) {
 		setup()
 		setExecutor(tc.NewCommand)
 
-		RunComposePs(test.Project, test.ComposePaths, test.Args...)
+		RunComposePs(test.Project, nil, test.ComposePaths, test.Args...)
 
 		if tc.Path != "docker" {
 			t.Errorf("Expected path be %s but got %s", "docker", tc.Path)
@@ -155,7 +191,7 @@ func TestRunComposeLogs(t *testing.T) {
 		setup()
 		setExecutor(tc.NewCommand)
 
-		RunComposeLogs(test.Project, test.ComposePaths, test.Args...)
+		RunComposeLogs(test.Project, nil, test.ComposePaths, test.Args...)
 
 		if tc.Path != "docker" {
 			t.Errorf("Expected path be %s but got %s", "docker", tc.Path)
@@ -190,7 +226,7 @@ func TestRunComposeDown(t *testing.T) {
 		setup()
 		setExecutor(tc.NewCommand)
 
-		RunComposeDown(test.Project, test.ComposePaths, test.Args...)
+		RunComposeDown(test.Project, nil, test.ComposePaths, test.Args...)
 
 		if tc.Path != "docker" {
 			t.Errorf("Expected path be %s but got %s", "docker", tc.Path)
@@ -210,6 +246,37 @@ func TestRunComposeDown(t *testing.T) {
 	}
 }
 
+func TestRunComposeUpWithRuntime(t *testing.T) {
+	tests := []struct {
+		Runtime  string
+		Path     string
+		Expected []string
+	}{
+		{docker.RuntimePodman, "podman", []string{"compose", "-p", "foo", "-f", "/foo/bar/baz", "up", "-d"}},
+		{docker.RuntimeNerdctl, "nerdctl", []string{"compose", "-p", "foo", "-f", "/foo/bar/baz", "up", "-d"}},
+	}
+	defer docker.SetRuntime(docker.CurrentRuntime())
+
+	for _, test := range tests {
+		setup()
+		setExecutor(tc.NewCommand)
+		runtime, err := docker.LookupRuntime(test.Runtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		docker.SetRuntime(runtime)
+
+		RunComposeUp("foo", nil, []string{"/foo/bar/baz"}, "-d")
+
+		if tc.Path != test.Path {
+			t.Errorf("Expected path be %s but got %s", test.Path, tc.Path)
+		}
+		if !reflect.DeepEqual(tc.Args, test.Expected) {
+			t.Errorf("Expected arguments %v but got %v", test.Expected, tc.Args)
+		}
+	}
+}
+
 func TestRunOnContainer(t *testing.T) {
 	tests := []struct {
 		ContainerName string
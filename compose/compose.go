@@ -1,49 +1,306 @@
 package compose
 
 import (
+	"bufio"
+	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/docker/cli/cli/compose/interpolation"
 	"github.com/docker/cli/cli/compose/loader"
 	"github.com/docker/cli/cli/compose/types"
 	errors "github.com/pkg/errors"
 	"github.com/spf13/afero"
 )
 
-func buildConfigDetails(dir string, source map[string]interface{}) *types.ConfigDetails {
-	workingDir, err := os.Getwd()
+// specVersion is the version of the compose file format the files are loaded
+// as. The compose specification is not versioned, so every file is loaded as
+// the most recent version the loader knows.
+const specVersion = "3.8"
+
+// envFilename is the file in the project directory with the default values of
+// the variables interpolated in compose files.
+const envFilename = ".env"
+
+// topLevelKeys are the top-level keys of the compose specification.
+var topLevelKeys = []string{"version", "name", "include", "services", "networks", "volumes", "secrets", "configs"}
+
+// parseEnvFile parses the variables of an env file: KEY=VALUE lines, with
+// optional quotes around the value and an optional 'export ' before the key.
+// Lines with only a key have no value.
+func parseEnvFile(b []byte) (map[string]*string, error) {
+	vars := make(map[string]*string)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i < 0 {
+			vars[line] = nil
+			continue
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if key == "" {
+			return nil, errors.Errorf("line %d has no variable name", lineNumber)
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+			if line[i+1] == '"' {
+				value = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(value)
+			}
+		} else if j := strings.Index(value, " #"); j >= 0 {
+			value = strings.TrimSpace(value[:j])
+		}
+		vars[key] = &value
+	}
+	return vars, scanner.Err()
+}
+
+// readEnvFile reads the variables of the env file.
+func readEnvFile(fs afero.Fs, filename string) (map[string]*string, error) {
+	b, err := afero.ReadFile(fs, filename)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	vars, err := parseEnvFile(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing %s", filename)
+	}
+	return vars, nil
+}
 
-	return &types.ConfigDetails{
-		WorkingDir: workingDir,
-		ConfigFiles: []types.ConfigFile{
-			{Filename: "filename.yml", Config: source},
-		},
-		Environment: map[string]string{
-			"HOME": os.Getenv("HOME"),
-		},
+// Environment returns the variables compose interpolates in the compose files
+// of the project in the directory: those of the environment and, for the
+// others, those of the .env file of the directory.
+func Environment(fs afero.Fs, dir string) (map[string]string, error) {
+	env := make(map[string]string)
+	vars, err := readEnvFile(fs, filepath.Join(dir, envFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for key, value := range vars {
+		if value != nil {
+			env[key] = *value
+		}
 	}
+	for _, keyValue := range os.Environ() {
+		if i := strings.Index(keyValue, "="); i > 0 {
+			env[keyValue[:i]] = keyValue[i+1:]
+		}
+	}
+	return env, nil
 }
 
-// Parse reads and parses the specified docker-compose.yml files and returns
-// a map holdind the parsed structure representing each file.
-func Parse(fs afero.Fs, wd string, file string) (*types.Config, error) {
-	b, err := afero.ReadFile(fs, file)
+// readFile reads and parses a compose file, checking it has the structure of
+// one.
+func readFile(fs afero.Fs, filename string) (map[string]interface{}, error) {
+	b, err := afero.ReadFile(fs, filename)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Could not read %s", file)
+		return nil, errors.Wrapf(err, "Could not read %s", filename)
 	}
 	dict, err := loader.ParseYAML(b)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Error parsing %s", file)
+		return nil, errors.Wrapf(err, "Error parsing %s", filename)
+	}
+	for key := range dict {
+		if !strings.HasPrefix(key, "x-") && !contains(topLevelKeys, key) {
+			return nil, errors.Errorf("Error loading %s: unsupported key '%s'", filename, key)
+		}
 	}
+	if services, ok := dict["services"]; ok && services != nil {
+		services, ok := services.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("Error loading %s: services must be a mapping", filename)
+		}
+		for name, service := range services {
+			if service == nil {
+				services[name] = map[string]interface{}{}
+			} else if _, ok := service.(map[string]interface{}); !ok {
+				return nil, errors.Errorf("Error loading %s: service %s must be a mapping", filename, name)
+			}
+		}
+	}
+	return dict, nil
+}
 
-	details := buildConfigDetails(filepath.Dir(file), dict)
-	config, err := loader.Load(*details)
+// Parse reads and parses the specified docker compose file. See ParseFiles.
+func Parse(fs afero.Fs, wd string, file string) (*types.Config, error) {
+	return ParseFiles(fs, wd, []string{file})
+}
+
+// ParseFiles reads and parses the docker compose files of a project and merges
+// them as compose does, later files overriding earlier ones. The variables of
+// the environment and of the .env file of the project directory are
+// interpolated, and relative paths are relative to the project directory,
+// which compose takes to be the directory of the first file. The included
+// files, services extending others and env files are resolved, as are the
+// keys of the compose specification the loader does not know.
+//
+// The configuration is lossy: the keys of the services the loader does not
+// support, such as mem_limit and volumes_from, are dropped with a warning, the
+// conditions of depends_on are dropped and unknown keys are ignored. It is
+// what dev acts on, and must not be written back out as a compose file, which
+// Merge returns.
+func ParseFiles(fs afero.Fs, dir string, files []string) (*types.Config, error) {
+	if len(files) == 0 {
+		return nil, errors.New("No compose files specified")
+	}
+	env, err := Environment(fs, dir)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Error loading %s", file)
+		return nil, err
 	}
 
+	details := types.ConfigDetails{
+		WorkingDir:  dir,
+		Environment: env,
+		Version:     specVersion,
+	}
+	for _, file := range files {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		n := &normalizer{fs: fs, env: env, loading: make(map[string]bool)}
+		dicts, err := n.load(file, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, dict := range dicts {
+			services, _ := dict["services"].(map[string]interface{})
+			for name, service := range services {
+				if err := n.loaderService(file, name, service.(map[string]interface{})); err != nil {
+					return nil, errors.Wrapf(err, "Error loading service %s of %s", name, file)
+				}
+			}
+			details.ConfigFiles = append(details.ConfigFiles, types.ConfigFile{Filename: file, Config: dict})
+		}
+	}
+
+	// the files are normalized to what the loader accepts, rather than
+	// validated against the schemas of the versioned file formats
+	config, err := loader.Load(details, func(opts *loader.Options) {
+		opts.SkipValidation = true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error loading %s", strings.Join(files, ", "))
+	}
 	return config, nil
 }
+
+// Merge reads the docker compose files of a project and merges them as
+// ParseFiles does, but returns the contents of the merged compose file rather
+// than loading it, so that none of the keys are lost. The variables are
+// interpolated, with '$' escaped in the result, and the version is left out.
+func Merge(fs afero.Fs, dir string, files []string) (map[string]interface{}, error) {
+	if len(files) == 0 {
+		return nil, errors.New("No compose files specified")
+	}
+	env, err := Environment(fs, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]interface{}{}
+	for _, file := range files {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		n := &normalizer{fs: fs, env: env, loading: make(map[string]bool)}
+		dicts, err := n.load(file, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, dict := range dicts {
+			merged = merge(merged, dict).(map[string]interface{})
+		}
+	}
+	delete(merged, "version")
+
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	interpolated, err := interpolation.Interpolate(merged, interpolation.Options{LookupValue: lookupEnv})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error loading %s", strings.Join(files, ", "))
+	}
+	return escapeAll(interpolated).(map[string]interface{}), nil
+}
+
+// DeclaringFile returns the first of the compose files that declares the
+// service, network or volume with the name in the section, or an empty string
+// if none does. Files that cannot be read are skipped.
+func DeclaringFile(fs afero.Fs, files []string, section string, name string) string {
+	for _, file := range files {
+		dict, err := readFile(fs, file)
+		if err != nil {
+			continue
+		}
+		if declared, ok := dict[section].(map[string]interface{}); ok {
+			if _, ok := declared[name]; ok {
+				return file
+			}
+		}
+	}
+	return ""
+}
+
+// Profiles returns the profiles of the service, which is only enabled when
+// one of them is. Services without profiles are always enabled.
+func Profiles(service types.ServiceConfig) []string {
+	profiles := []string{}
+	list, _ := service.Extras[profilesExtra].([]interface{})
+	for _, profile := range list {
+		if profile, ok := profile.(string); ok {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// Enabled reports whether the service is enabled with the profiles. The '*'
+// profile enables every service.
+func Enabled(service types.ServiceConfig, profiles []string) bool {
+	serviceProfiles := Profiles(service)
+	if len(serviceProfiles) == 0 || contains(profiles, "*") {
+		return true
+	}
+	for _, profile := range serviceProfiles {
+		if contains(profiles, profile) {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeAll escapes '$' in the strings of the interpolated value.
+func escapeAll(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = escapeAll(item)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = escapeAll(item)
+		}
+		return value
+	case string:
+		return escape(value)
+	default:
+		return value
+	}
+}
+
+func contains(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
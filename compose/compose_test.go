@@ -1,13 +1,19 @@
 package compose_test
 
 import (
+	"bytes"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/docker/cli/cli/compose/types"
 	"github.com/wish/dev/compose"
 	"github.com/wish/dev/test"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
+	"gotest.tools/v3/env"
 )
 
 func TestConfigParseNoSuchFile(t *testing.T) {
@@ -59,3 +65,98 @@ func TestConfigLoadSuccess(t *testing.T) {
 		t.Errorf("Not expecting a nil config")
 	}
 }
+
+func TestParseFiles(t *testing.T) {
+	defer env.Patch(t, "APP_TAG", "1.2")()
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"/home/app/.env":    "APP_TAG=latest\nWEB_PORT=8080 # published port\n",
+		"/home/app/web.env": "GREETING=hello $USER\nLEVEL=debug\n",
+		"/home/app/docker-compose.yml": `version: "3.6"
+services:
+  web:
+    image: "web:${APP_TAG}"
+    build: ./web
+    env_file: web.env
+    environment:
+      LEVEL: info
+    ports:
+      - "${WEB_PORT}:80"
+    mem_limit: 1g
+    depends_on:
+      db:
+        condition: service_healthy
+  db:
+    extends:
+      file: common/base.yml
+      service: database
+`,
+		"/home/app/override/docker-compose.override.yml": `services:
+  web:
+    volumes:
+      - ./src:/src
+`,
+		"/home/app/common/base.yml": `services:
+  database:
+    image: postgres
+    volumes:
+      - ./data:/var/lib/postgresql/data
+`,
+	}
+	for filename, content := range files {
+		test.CreateConfigFile(fs, content, filename)
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	config, err := compose.ParseFiles(fs, "/home/app",
+		[]string{"/home/app/docker-compose.yml", "/home/app/override/docker-compose.override.yml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logged.String(), "Ignoring mem_limit of service web") {
+		t.Errorf("Expected a warning that mem_limit is ignored, got %q", logged.String())
+	}
+	services := make(map[string]types.ServiceConfig)
+	for _, service := range config.Services {
+		services[service.Name] = service
+	}
+	web, db := services["web"], services["db"]
+	if web.Image != "web:1.2" {
+		t.Errorf("Expected the environment to take precedence over .env, got image %s", web.Image)
+	}
+	if web.Build.Context != "/home/app/web" {
+		t.Errorf("Expected the build context relative to the project directory, got %s", web.Build.Context)
+	}
+	if len(web.Ports) != 1 || web.Ports[0].Published != 8080 {
+		t.Errorf("Expected the port published from .env, got %v", web.Ports)
+	}
+	if *web.Environment["LEVEL"] != "info" || *web.Environment["GREETING"] != "hello $USER" {
+		t.Errorf("Expected the env file under the environment, got %v", web.Environment)
+	}
+	if !reflect.DeepEqual(web.DependsOn, []string{"db"}) {
+		t.Errorf("Expected web to depend on db, got %v", web.DependsOn)
+	}
+	if len(web.Volumes) != 1 || web.Volumes[0].Source != "/home/app/src" {
+		t.Errorf("Expected the volumes of the override relative to the project directory, got %v", web.Volumes)
+	}
+	if db.Image != "postgres" || len(db.Volumes) != 1 || db.Volumes[0].Source != "/home/app/common/data" {
+		t.Errorf("Expected db to extend database relative to its file, got %v %v", db.Image, db.Volumes)
+	}
+}
+
+func TestParseFilesExtendsCycle(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	test.CreateConfigFile(fs, `services:
+  a:
+    extends: b
+  b:
+    extends: a
+`, "/home/app/docker-compose.yml")
+
+	_, err := compose.ParseFiles(fs, "/home/app", []string{"docker-compose.yml"})
+	if err == nil || !strings.Contains(err.Error(), "extends itself") {
+		t.Errorf("Expected an error for services extending each other, got %v", err)
+	}
+}
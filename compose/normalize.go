@@ -0,0 +1,574 @@
+package compose
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/cli/cli/compose/template"
+	"github.com/docker/cli/cli/compose/types"
+	errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+// profilesExtra is the extension key the profiles of a service are kept
+// under, as the loader only keeps the keys it knows and extensions.
+const profilesExtra = "x-dev-profiles"
+
+// discarded are the keys of services already warned about, which are warned
+// about once.
+var (
+	discarded      = make(map[string]bool)
+	discardedMutex sync.Mutex
+)
+
+// replacedKeys are the service keys whose lists replace, rather than extend,
+// those of the services they extend.
+var replacedKeys = []string{"command", "entrypoint", "test"}
+
+// normalizer rewrites compose files in a form independent of the files they
+// were read from: included files and extended services are resolved and
+// relative paths are made absolute. The services are then rewritten in what
+// the loader accepts with loaderService.
+type normalizer struct {
+	fs  afero.Fs
+	env map[string]string
+	// loading holds the services being resolved, to detect cycles of
+	// services extending each other
+	loading map[string]bool
+}
+
+// lookupEnv looks up the variables interpolated in compose files.
+func (n *normalizer) lookupEnv(key string) (string, bool) {
+	value, ok := n.env[key]
+	return value, ok
+}
+
+// path interpolates the path found in a compose file and makes it absolute,
+// relative paths being relative to the directory.
+func (n *normalizer) path(dir string, path string) (string, error) {
+	path, err := template.Substitute(path, n.lookupEnv)
+	if err != nil {
+		return "", err
+	}
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	return filepath.Join(dir, path), nil
+}
+
+// load reads the compose file and those it includes, which come first.
+// Relative paths in the file are relative to the directory.
+func (n *normalizer) load(filename string, dir string) ([]map[string]interface{}, error) {
+	dict, err := readFile(n.fs, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	dicts := []map[string]interface{}{}
+	includes, err := n.includes(filename, dict["include"])
+	if err != nil {
+		return nil, err
+	}
+	for _, include := range includes {
+		included, err := n.load(include[0], include[1])
+		if err != nil {
+			return nil, err
+		}
+		dicts = append(dicts, included...)
+	}
+	delete(dict, "include")
+	delete(dict, "name")
+
+	if services, ok := dict["services"].(map[string]interface{}); ok {
+		normalized := make(map[string]interface{}, len(services))
+		for name := range services {
+			service, err := n.service(filename, dir, dict, name)
+			if err != nil {
+				return nil, err
+			}
+			normalized[name] = service
+		}
+		dict["services"] = normalized
+	}
+	for _, section := range []string{"networks", "volumes"} {
+		resources, _ := dict[section].(map[string]interface{})
+		for _, resource := range resources {
+			if resource, ok := resource.(map[string]interface{}); ok {
+				normalizeExternal(resource)
+			}
+		}
+	}
+	for _, section := range []string{"secrets", "configs"} {
+		resources, _ := dict[section].(map[string]interface{})
+		for name, resource := range resources {
+			resource, ok := resource.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			file, ok := resource["file"].(string)
+			if !ok {
+				continue
+			}
+			path, err := n.path(dir, file)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error loading %s %s of %s", section, name, filename)
+			}
+			resource["file"] = escape(path)
+		}
+	}
+	dict["version"] = specVersion
+	return append(dicts, dict), nil
+}
+
+// normalizeExternal replaces the deprecated name of an external network or
+// volume with the name of the resource, which the loader warns about as the
+// files are loaded as the latest version.
+func normalizeExternal(resource map[string]interface{}) {
+	external, ok := resource["external"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if name, ok := external["name"]; ok {
+		if _, ok := resource["name"]; !ok {
+			resource["name"] = name
+		}
+		resource["external"] = true
+	}
+}
+
+// includes returns the files included by a compose file along with the
+// directory their relative paths are relative to.
+func (n *normalizer) includes(filename string, include interface{}) ([][2]string, error) {
+	if include == nil {
+		return nil, nil
+	}
+	entries, ok := include.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("Error loading %s: include must be a list", filename)
+	}
+	includes := [][2]string{}
+	for _, entry := range entries {
+		var paths []interface{}
+		projectDirectory := ""
+		switch entry := entry.(type) {
+		case string:
+			paths = []interface{}{entry}
+		case map[string]interface{}:
+			switch path := entry["path"].(type) {
+			case string:
+				paths = []interface{}{path}
+			case []interface{}:
+				paths = path
+			}
+			projectDirectory, _ = entry["project_directory"].(string)
+		}
+		if len(paths) == 0 {
+			return nil, errors.Errorf("Error loading %s: include %v has no path", filename, entry)
+		}
+		for _, path := range paths {
+			path, err := n.path(filepath.Dir(filename), fmt.Sprint(path))
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error loading %s", filename)
+			}
+			dir := filepath.Dir(path)
+			if projectDirectory != "" {
+				if dir, err = n.path(filepath.Dir(filename), projectDirectory); err != nil {
+					return nil, errors.Wrapf(err, "Error loading %s", filename)
+				}
+			}
+			includes = append(includes, [2]string{path, dir})
+		}
+	}
+	return includes, nil
+}
+
+// service returns the normalized service of the compose file, merged with the
+// service it extends.
+func (n *normalizer) service(filename string, dir string, dict map[string]interface{}, name string) (map[string]interface{}, error) {
+	key := filename + ":" + name
+	if n.loading[key] {
+		return nil, errors.Errorf("Error loading %s: service %s extends itself", filename, name)
+	}
+	n.loading[key] = true
+	defer delete(n.loading, key)
+
+	services, _ := dict["services"].(map[string]interface{})
+	raw, ok := services[name].(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("Error loading %s: no service %s", filename, name)
+	}
+	service := deepCopy(raw).(map[string]interface{})
+	if err := n.normalizeService(dir, service); err != nil {
+		return nil, errors.Wrapf(err, "Error loading service %s of %s", name, filename)
+	}
+
+	extends, ok := service["extends"]
+	if !ok {
+		return service, nil
+	}
+	delete(service, "extends")
+	baseFilename, baseDir, baseDict, baseName := filename, dir, dict, ""
+	switch extends := extends.(type) {
+	case string:
+		baseName = extends
+	case map[string]interface{}:
+		baseName, _ = extends["service"].(string)
+		if file, ok := extends["file"].(string); ok {
+			path, err := n.path(filepath.Dir(filename), file)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error loading service %s of %s", name, filename)
+			}
+			if baseDict, err = readFile(n.fs, path); err != nil {
+				return nil, err
+			}
+			baseFilename, baseDir = path, filepath.Dir(path)
+		}
+	}
+	if baseName == "" {
+		return nil, errors.Errorf("Error loading %s: service %s extends no service", filename, name)
+	}
+	base, err := n.service(baseFilename, baseDir, baseDict, baseName)
+	if err != nil {
+		return nil, err
+	}
+	return merge(base, service).(map[string]interface{}), nil
+}
+
+// normalizeService rewrites the service in a form independent of the file it
+// is declared in, without dropping any of its keys: relative paths, which are
+// relative to the directory, are made absolute and the environment and labels
+// are made mappings so that they merge.
+func (n *normalizer) normalizeService(dir string, service map[string]interface{}) error {
+	for _, key := range []string{"environment", "labels"} {
+		if value, ok := service[key]; ok {
+			service[key] = toMapping(value)
+		}
+	}
+
+	switch build := service["build"].(type) {
+	case string:
+		context, err := n.context(dir, build)
+		if err != nil {
+			return err
+		}
+		service["build"] = context
+	case map[string]interface{}:
+		if context, ok := build["context"].(string); ok {
+			context, err := n.context(dir, context)
+			if err != nil {
+				return err
+			}
+			build["context"] = context
+		}
+	}
+
+	if volumes, ok := service["volumes"].([]interface{}); ok {
+		for i, volume := range volumes {
+			switch volume := volume.(type) {
+			case string:
+				parts := strings.SplitN(volume, ":", 2)
+				if isRelative(parts[0]) {
+					parts[0] = filepath.Join(dir, parts[0])
+					volumes[i] = strings.Join(parts, ":")
+				}
+			case map[string]interface{}:
+				if source, ok := volume["source"].(string); ok && volume["type"] == "bind" && isRelative(source) {
+					volume["source"] = filepath.Join(dir, source)
+				}
+			}
+		}
+	}
+
+	if envFiles, ok := service["env_file"]; ok {
+		if envFile, ok := envFiles.(string); ok {
+			envFiles = []interface{}{envFile}
+		}
+		entries, ok := envFiles.([]interface{})
+		if !ok {
+			return errors.New("env_file must be a string or a list")
+		}
+		for i, entry := range entries {
+			switch entry := entry.(type) {
+			case string:
+				path, err := n.path(dir, entry)
+				if err != nil {
+					return err
+				}
+				entries[i] = escape(path)
+			case map[string]interface{}:
+				if path, ok := entry["path"].(string); ok {
+					path, err := n.path(dir, path)
+					if err != nil {
+						return err
+					}
+					entry["path"] = escape(path)
+				}
+			}
+		}
+		service["env_file"] = entries
+	}
+	return nil
+}
+
+// loaderService rewrites the keys of the normalized service the loader does
+// not support. Those it rejects are dropped with a warning, as dev does not
+// take them into account, though compose still applies them.
+func (n *normalizer) loaderService(filename string, name string, service map[string]interface{}) error {
+	for _, key := range sortedKeys(service) {
+		if _, forbidden := types.ForbiddenProperties[key]; forbidden && key != "extends" {
+			warnDiscarded(filename, name, key)
+			delete(service, key)
+		}
+	}
+	if profiles, ok := service["profiles"]; ok {
+		delete(service, "profiles")
+		service[profilesExtra] = profiles
+	}
+
+	if err := n.inlineEnvFiles(service); err != nil {
+		return err
+	}
+
+	// the long syntax lists the conditions of the dependencies, only the
+	// services depended on are kept
+	if dependsOn, ok := service["depends_on"].(map[string]interface{}); ok {
+		names := []interface{}{}
+		for _, name := range sortedKeys(dependsOn) {
+			names = append(names, name)
+		}
+		service["depends_on"] = names
+	}
+
+	if ports, ok := service["ports"].([]interface{}); ok {
+		for _, port := range ports {
+			port, ok := port.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if published, ok := port["published"].(string); ok {
+				if number, err := strconv.Atoi(published); err == nil {
+					port["published"] = number
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// warnDiscarded warns that the key of the service is ignored by dev, once per
+// run rather than every time the compose file is parsed.
+func warnDiscarded(filename string, name string, key string) {
+	discardedMutex.Lock()
+	defer discardedMutex.Unlock()
+	warning := filename + ":" + name + ":" + key
+	if discarded[warning] {
+		return
+	}
+	discarded[warning] = true
+	log.Warnf("Ignoring %s of service %s in %s, which dev does not support; compose still applies it",
+		key, name, filename)
+}
+
+// RemoteContext returns whether the build context is a git repository or a
+// URL rather than a local directory.
+func RemoteContext(context string) bool {
+	return strings.Contains(context, "://") || strings.HasPrefix(context, "git@")
+}
+
+// context makes the build context absolute, unless it is a URL.
+func (n *normalizer) context(dir string, context string) (string, error) {
+	if RemoteContext(context) {
+		return context, nil
+	}
+	path, err := n.path(dir, context)
+	if err != nil {
+		return "", err
+	}
+	return escape(path), nil
+}
+
+// inlineEnvFiles reads the env files of the normalized service into its
+// environment, whose variables take precedence.
+func (n *normalizer) inlineEnvFiles(service map[string]interface{}) error {
+	entries, ok := service["env_file"].([]interface{})
+	if !ok {
+		return nil
+	}
+	delete(service, "env_file")
+
+	environment, _ := service["environment"].(map[string]interface{})
+	if environment == nil {
+		environment = make(map[string]interface{})
+	}
+	inlined := make(map[string]interface{})
+	for _, entry := range entries {
+		path, required := "", true
+		switch entry := entry.(type) {
+		case string:
+			path = entry
+		case map[string]interface{}:
+			path, _ = entry["path"].(string)
+			if r, ok := entry["required"].(bool); ok {
+				required = r
+			}
+		}
+		// the paths are absolute, with '$' escaped
+		filename, err := n.path("/", path)
+		if err != nil {
+			return err
+		}
+		vars, err := readEnvFile(n.fs, filename)
+		if err != nil {
+			if !required {
+				continue
+			}
+			return errors.Wrapf(err, "Could not read env_file %s", filename)
+		}
+		for key, value := range vars {
+			if value == nil {
+				inlined[key] = nil
+			} else {
+				// the values of env files are not interpolated
+				inlined[key] = escape(*value)
+			}
+		}
+	}
+	for key, value := range inlined {
+		if _, ok := environment[key]; !ok {
+			environment[key] = value
+		}
+	}
+	service["environment"] = environment
+	return nil
+}
+
+// isRelative reports whether the volume source is a relative path rather than
+// a named volume.
+func isRelative(source string) bool {
+	return source == "." || source == ".." || strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../")
+}
+
+// toMapping converts the list of KEY=VALUE items to a mapping.
+func toMapping(value interface{}) interface{} {
+	list, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+	mapping := make(map[string]interface{}, len(list))
+	for _, item := range list {
+		parts := strings.SplitN(fmt.Sprint(item), "=", 2)
+		if len(parts) == 2 {
+			mapping[parts[0]] = parts[1]
+		} else {
+			mapping[parts[0]] = nil
+		}
+	}
+	return mapping
+}
+
+// merge merges the override into the base as compose merges extended
+// services: mappings are merged, lists are appended to and other values are
+// replaced.
+func merge(base interface{}, override interface{}) interface{} {
+	baseMap, ok := base.(map[string]interface{})
+	overrideMap, ok2 := override.(map[string]interface{})
+	if !ok || !ok2 {
+		return override
+	}
+	merged := make(map[string]interface{}, len(baseMap))
+	for key, value := range baseMap {
+		merged[key] = value
+	}
+	for key, value := range overrideMap {
+		baseValue, ok := merged[key]
+		if !ok {
+			merged[key] = value
+			continue
+		}
+		if key == "depends_on" {
+			baseValue, value = dependencies(baseValue, value)
+		}
+		baseList, ok := baseValue.([]interface{})
+		list, ok2 := value.([]interface{})
+		if ok && ok2 && !contains(replacedKeys, key) {
+			merged[key] = appendUnique(baseList, list)
+		} else {
+			merged[key] = merge(baseValue, value)
+		}
+	}
+	return merged
+}
+
+// dependencies converts the list of services depended on to the long syntax
+// when the other dependencies are in it, so that they merge.
+func dependencies(base interface{}, override interface{}) (interface{}, interface{}) {
+	_, baseMap := base.(map[string]interface{})
+	_, overrideMap := override.(map[string]interface{})
+	if baseMap == overrideMap {
+		return base, override
+	}
+	toMap := func(value interface{}) interface{} {
+		list, ok := value.([]interface{})
+		if !ok {
+			return value
+		}
+		mapping := make(map[string]interface{}, len(list))
+		for _, name := range list {
+			mapping[fmt.Sprint(name)] = map[string]interface{}{"condition": "service_started"}
+		}
+		return mapping
+	}
+	return toMap(base), toMap(override)
+}
+
+// escape escapes '$' in the value, which has already been interpolated.
+func escape(value string) string {
+	return strings.Replace(value, "$", "$$", -1)
+}
+
+// appendUnique appends the items not already in the list.
+func appendUnique(list []interface{}, items []interface{}) []interface{} {
+	seen := make(map[string]bool)
+	result := []interface{}{}
+	for _, item := range append(append([]interface{}{}, list...), items...) {
+		key := fmt.Sprintf("%#v", item)
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			c[key] = deepCopy(item)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(value))
+		for i, item := range value {
+			c[i] = deepCopy(item)
+		}
+		return c
+	default:
+		return value
+	}
+}
+
+func sortedKeys(mapping map[string]interface{}) []string {
+	keys := make([]string, 0, len(mapping))
+	for key := range mapping {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
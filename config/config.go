@@ -2,14 +2,20 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"text/template"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
+	"github.com/wish/dev/docker"
 
 	"github.com/docker/docker/api/types"
 )
@@ -18,6 +24,34 @@ const (
 	projectShellDefault           = "/bin/bash"
 	registryTimeoutSecondsDefault = 2
 	registryContinueOnFail        = false
+	subnetPoolDefault             = "10.242.0.0/16"
+	subnetPoolPrefixDefault       = 24
+	dockerTimeoutSecondsDefault   = 30
+	instancePortOffsetDefault     = 1000
+	runtimeDefault                = docker.RuntimeDocker
+	// SubnetAuto is the subnet of a managed network for which dev picks a
+	// free subnet from the subnet pool.
+	SubnetAuto = "auto"
+	// BuilderCompose builds the images of a project with compose.
+	BuilderCompose = "compose"
+	// BuilderDobi builds the images of a project with dobi.
+	BuilderDobi = "dobi"
+	// BuilderBake builds the images of a project with docker buildx bake.
+	BuilderBake = "buildx-bake"
+	// BuilderCustom builds the images of a project with a command
+	// rendered from a template for each service.
+	BuilderCustom = "custom"
+	// dobiFileDefault is the dobi configuration file used when the file of
+	// a dobi builder is not specified.
+	dobiFileDefault = "dobi.yaml"
+	// downloadTagDefault is the tag downloaded when none is configured.
+	downloadTagDefault = "current"
+	// NetworkRepairRemove removes exited containers attached to a managed
+	// network that no longer exists.
+	NetworkRepairRemove = "remove"
+	// NetworkRepairReconnect reconnects exited containers attached to a
+	// managed network that no longer exists to the current network.
+	NetworkRepairReconnect = "reconnect"
 	// LogLevelDefault is the log level used when one has not been
 	// specified in an environment variable or in configuration file.
 	LogLevelDefault = "info"
@@ -66,9 +100,54 @@ type Dev struct {
 	ImagePrefix           string                          `mapstructure:"image_prefix"`
 	MinimumVersion        string                          `mapstructure:"minimum_version"`
 	ProjectCommandAliases map[string]*ProjectCommandAlias `mapstructure:"project_command_aliases"`
-
+	// RecreateNetworks allows dev to recreate a managed network whose
+	// configuration no longer matches the configuration of the existing
+	// network of the same name. When false the user is asked first.
+	RecreateNetworks bool `mapstructure:"recreate_networks"`
+	// SubnetPool is the list of subnets from which subnets are picked for
+	// managed networks configured with a subnet of 'auto'.
+	SubnetPool []string `mapstructure:"subnet_pool"`
+	// SubnetPoolPrefix is the prefix length of the subnets picked from the
+	// subnet pool, default is 24.
+	SubnetPoolPrefix int `mapstructure:"subnet_pool_prefix"`
+	// NetworkRepair is what dev does with exited containers attached to a
+	// managed network that no longer exists, typically after a reboot.
+	// With 'remove', the default, the containers are removed and
+	// recreated by compose. With 'reconnect' they are reconnected to the
+	// current network so that state local to the container survives.
+	NetworkRepair string `mapstructure:"network_repair"`
+	// DockerTimeoutSeconds is the time allowed for each request dev makes
+	// to the docker daemon, default is 30.
+	DockerTimeoutSeconds int `mapstructure:"docker_timeout_seconds"`
+	// PortOffset enables the automatic remapping of the published host
+	// ports of projects that conflict with ports in use: they are moved
+	// by multiples of the offset until they are free. Default is 0, in
+	// which case up fails on a conflict.
+	PortOffset int `mapstructure:"port_offset"`
+	// Runtime is the container runtime used to run compose and container
+	// commands: docker, podman or nerdctl. The DEV_RUNTIME environment
+	// variable takes precedence. Default is docker.
+	Runtime string `mapstructure:"runtime"`
+	// Instance is the name of the instance of the projects selected with
+	// --instance or DEV_INSTANCE, empty for the default instance. Each
+	// instance has its own compose project, managed networks and state.
+	// This is used internally and is ignored if specified by the user.
+	Instance string `mapstructure:"-"`
+	// WorktreeInstances selects the instance named after the git worktree
+	// the dev configuration is in when no instance is specified, so that
+	// each worktree of a repository runs its own instance.
+	WorktreeInstances bool `mapstructure:"worktree_instances"`
+	// InstancePortOffset is the offset by which the published host ports
+	// of each instance are moved, multiplied by the number of the
+	// instance, default is 1000.
+	InstancePortOffset int `mapstructure:"instance_port_offset"`
+
+	// baseImagePrefix is the image prefix before an instance was selected.
+	baseImagePrefix string
 	// Filesystem to read configuration from
 	fs afero.Fs
+	// Engine performing docker daemon operations
+	engine docker.Engine
 }
 
 // LogConfig holds the logging related configuration.
@@ -96,6 +175,107 @@ type Project struct {
 	Shell string `mapstructure:"shell"`
 	// Projects, registries, networks on which this project depends.
 	Dependencies []string `mapstructure:"depends_on"`
+	// Builder builds the images of the project's services. When not
+	// set, dobi is used if it is installed and there is a dobi.yaml next
+	// to the dev configuration, otherwise compose.
+	Builder *Builder `mapstructure:"builder"`
+	// Download configures where the images of the project's services are
+	// downloaded from by the download command.
+	Download *Download `mapstructure:"download"`
+	// Profiles are the compose profiles enabled for the project. The
+	// services with profiles are only brought up, built and connected to
+	// the networks when one of their profiles is enabled.
+	Profiles []string `mapstructure:"profiles"`
+}
+
+// Download configures the registry, repository and tag the images of a
+// project's services are downloaded from. Services can override each of
+// these.
+type Download struct {
+	// Registry is the name of the registry images are pulled from. When
+	// not set, the only registry with a download_path is used.
+	Registry string `mapstructure:"registry"`
+	// Repository is the path in the registry the images of the services
+	// are stored under, default the download_path of the registry.
+	Repository string `mapstructure:"repository"`
+	// Tag is the template of the tag pulled, default 'current'. The
+	// fields are Service, Branch, SHA and ShortSHA, the latter three of
+	// the git checkout of the dev configuration.
+	Tag string `mapstructure:"tag"`
+	// Services overrides the source of the images of individual services.
+	Services map[string]*DownloadSource `mapstructure:"services"`
+}
+
+// DownloadSource is where the image of a service is downloaded from.
+type DownloadSource struct {
+	Registry   string `mapstructure:"registry"`
+	Repository string `mapstructure:"repository"`
+	// Image is the name of the image in the repository, default the name
+	// of the service.
+	Image string `mapstructure:"image"`
+	Tag   string `mapstructure:"tag"`
+}
+
+// Source returns where the image of the service is downloaded from, the
+// settings of the service taking precedence over those of the project.
+func (d *Download) Source(service string) *DownloadSource {
+	source := &DownloadSource{}
+	if d != nil {
+		source.Registry = d.Registry
+		source.Repository = d.Repository
+		source.Tag = d.Tag
+		if override, ok := d.Services[service]; ok && override != nil {
+			if override.Registry != "" {
+				source.Registry = override.Registry
+			}
+			if override.Repository != "" {
+				source.Repository = override.Repository
+			}
+			if override.Image != "" {
+				source.Image = override.Image
+			}
+			if override.Tag != "" {
+				source.Tag = override.Tag
+			}
+		}
+	}
+	if source.Image == "" {
+		source.Image = service
+	}
+	if source.Tag == "" {
+		source.Tag = downloadTagDefault
+	}
+	return source
+}
+
+// Builder configures the tool that builds the images of a project's services.
+type Builder struct {
+	// Type of the builder: compose, dobi, buildx-bake or custom.
+	Type string `mapstructure:"type"`
+	// File is the dobi configuration, default dobi.yaml, or the bake
+	// file, default the project's docker compose files. Relative paths
+	// are relative to the dev configuration file.
+	File string `mapstructure:"file"`
+	// Targets maps the name of a service to the dobi resource or bake
+	// target that builds it. Services not listed are built by the
+	// target of the same name.
+	Targets map[string]string `mapstructure:"targets"`
+	// Args are extra arguments passed to the builder before the target.
+	Args []string `mapstructure:"args"`
+	// Command is the template of the shell command that builds a
+	// service with the custom builder, e.g., 'make {{.Target}}
+	// IMAGE={{.Image}}'. The fields are Service, Target, Image,
+	// ImagePrefix, Context and Dockerfile.
+	Command string `mapstructure:"command"`
+}
+
+// Target returns the dobi resource or bake target that builds the
+// service.
+func (b *Builder) Target(service string) string {
+	if target, ok := b.Targets[service]; ok && target != "" {
+		return target
+	}
+	return service
 }
 
 // Registry repesents the configuration required to model a container registry.
@@ -157,6 +337,87 @@ func (d *Dev) GetFs() afero.Fs {
 	return d.fs
 }
 
+// SetEngine sets the engine used for docker daemon operations. Helpful during
+// testing.
+func (d *Dev) SetEngine(engine docker.Engine) {
+	d.engine = engine
+}
+
+// GetEngine returns the engine used for docker daemon operations. The default
+// talks to the docker daemon but can be replaced via SetEngine for testing.
+func (d *Dev) GetEngine() docker.Engine {
+	if d.engine == nil {
+		d.engine = docker.NewEngine()
+	}
+	return d.engine
+}
+
+// ProjectNames returns the names of the configured projects in sorted order.
+func (d *Dev) ProjectNames() []string {
+	names := make([]string, 0, len(d.Projects))
+	for name := range d.Projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// instanceNamePattern matches the names allowed for instances, which are part
+// of compose project names.
+var instanceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// SetInstance selects the instance of the projects that is used. The image
+// prefix, which names the compose project, and the names of the managed
+// networks get the name of the instance as a suffix.
+func (d *Dev) SetInstance(instance string) error {
+	if !instanceNamePattern.MatchString(instance) {
+		return errors.Errorf("invalid instance name '%s', use lowercase letters, digits, '-' and '_'", instance)
+	}
+	d.baseImagePrefix = d.BaseImagePrefix()
+	d.Instance = instance
+	d.ImagePrefix = d.baseImagePrefix + "-" + instance
+	return nil
+}
+
+// BaseImagePrefix returns the image prefix of the default instance of the
+// projects.
+func (d *Dev) BaseImagePrefix() string {
+	if d.baseImagePrefix != "" {
+		return d.baseImagePrefix
+	}
+	return d.ImagePrefix
+}
+
+// NetworkName returns the name of the docker network of the managed network
+// with the specified name in the selected instance.
+func (d *Dev) NetworkName(name string) string {
+	if d.Instance == "" {
+		return name
+	}
+	return name + "-" + d.Instance
+}
+
+// NetworkNames returns the names of the configured networks in sorted order.
+func (d *Dev) NetworkNames() []string {
+	names := make([]string, 0, len(d.Networks))
+	for name := range d.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegistryNames returns the names of the configured registries in sorted
+// order.
+func (d *Dev) RegistryNames() []string {
+	names := make([]string, 0, len(d.Registries))
+	for name := range d.Registries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func pathToDockerComposeFilenames(directory string) []string {
 	paths := make([]string, len(dockerComposeFilenames))
 	for _, filename := range dockerComposeFilenames {
@@ -196,6 +457,9 @@ func expandRelativeDirectories(config *Dev) {
 				project.DockerComposeFilenames[i] = fullPath
 			}
 		}
+		if project.Builder != nil && project.Builder.File != "" && !strings.HasPrefix(project.Builder.File, "/") {
+			project.Builder.File = path.Clean(path.Join(config.Dir, project.Builder.File))
+		}
 	}
 }
 
@@ -208,10 +472,32 @@ func setDefaults(config *Dev) {
 		}
 	}
 
+	if len(config.SubnetPool) == 0 {
+		config.SubnetPool = []string{subnetPoolDefault}
+	}
+	if config.SubnetPoolPrefix == 0 {
+		config.SubnetPoolPrefix = subnetPoolPrefixDefault
+	}
+	if config.Runtime == "" {
+		config.Runtime = runtimeDefault
+	}
+	if config.DockerTimeoutSeconds == 0 {
+		config.DockerTimeoutSeconds = dockerTimeoutSecondsDefault
+	}
+	if config.NetworkRepair == "" {
+		config.NetworkRepair = NetworkRepairRemove
+	}
+	if config.InstancePortOffset == 0 {
+		config.InstancePortOffset = instancePortOffsetDefault
+	}
+
 	for _, project := range config.Projects {
 		if project.Shell == "" {
 			project.Shell = projectShellDefault
 		}
+		if project.Builder != nil && project.Builder.Type == BuilderDobi && project.Builder.File == "" {
+			project.Builder.File = filepath.Join(config.Dir, dobiFileDefault)
+		}
 	}
 
 	if config.ImagePrefix == "" {
@@ -290,7 +576,7 @@ func Expand(filename string, config *Dev) {
 	}
 
 	if len(config.Projects) == 0 {
-		fmt.Println(NoProjectWarning)
+		fmt.Print(NoProjectWarning)
 	}
 }
 
@@ -311,6 +597,15 @@ func Merge(target *Dev, source *Dev) error {
 		target.Dir = source.Dir
 		target.Filename = source.Filename
 		target.ProjectCommandAliases = source.ProjectCommandAliases
+		target.RecreateNetworks = source.RecreateNetworks
+		target.SubnetPool = source.SubnetPool
+		target.SubnetPoolPrefix = source.SubnetPoolPrefix
+		target.NetworkRepair = source.NetworkRepair
+		target.DockerTimeoutSeconds = source.DockerTimeoutSeconds
+		target.PortOffset = source.PortOffset
+		target.WorktreeInstances = source.WorktreeInstances
+		target.InstancePortOffset = source.InstancePortOffset
+		target.Runtime = source.Runtime
 
 	} else if source.ImagePrefix != target.ImagePrefix {
 		// Not sure I like forcing this.. but if users switch back and forth
@@ -350,3 +645,152 @@ func Merge(target *Dev, source *Dev) error {
 
 	return nil
 }
+
+// Validate checks the configuration for errors that would prevent dev from
+// functioning, such as dependencies on objects that do not exist. All of the
+// errors found are returned.
+func Validate(config *Dev) []error {
+	errs := []error{}
+
+	for _, name := range config.ProjectNames() {
+		project := config.Projects[name]
+		if len(project.DockerComposeFilenames) == 0 {
+			errs = append(errs, errors.Errorf("project %s has no docker_compose_files", name))
+		}
+		for _, filename := range project.DockerComposeFilenames {
+			if _, err := config.fs.Stat(filename); err != nil {
+				errs = append(errs, errors.Errorf("project %s docker compose file %s does not exist", name, filename))
+			}
+		}
+		if project.Builder != nil {
+			errs = append(errs, validateBuilder(name, project.Builder)...)
+		}
+		if project.Download != nil {
+			errs = append(errs, validateDownload(config, name, project.Download)...)
+		}
+		for _, dep := range project.Dependencies {
+			_, isProject := config.Projects[dep]
+			_, isNetwork := config.Networks[dep]
+			_, isRegistry := config.Registries[dep]
+			if dep == name {
+				errs = append(errs, errors.Errorf("project %s depends on itself", name))
+			} else if !isProject && !isNetwork && !isRegistry {
+				errs = append(errs, errors.Errorf("project %s depends on %s which is not a configured project, network or registry", name, dep))
+			}
+		}
+	}
+
+	for _, name := range config.NetworkNames() {
+		network := config.Networks[name]
+		if network == nil || network.IPAM == nil {
+			continue
+		}
+		for _, ipamConfig := range network.IPAM.Config {
+			if ipamConfig.Subnet == "" || ipamConfig.Subnet == SubnetAuto {
+				continue
+			}
+			if _, _, err := net.ParseCIDR(ipamConfig.Subnet); err != nil {
+				errs = append(errs, errors.Errorf("network %s has an invalid subnet %s", name, ipamConfig.Subnet))
+			}
+		}
+	}
+
+	for _, pool := range config.SubnetPool {
+		if _, _, err := net.ParseCIDR(pool); err != nil {
+			errs = append(errs, errors.Errorf("subnet_pool has an invalid subnet %s", pool))
+		}
+	}
+	if config.SubnetPoolPrefix < 0 || config.SubnetPoolPrefix > 32 {
+		errs = append(errs, errors.Errorf("subnet_pool_prefix %d is not a valid prefix length", config.SubnetPoolPrefix))
+	}
+
+	switch config.NetworkRepair {
+	case "", NetworkRepairRemove, NetworkRepairReconnect:
+	default:
+		errs = append(errs, errors.Errorf("network_repair must be %s or %s, not %s",
+			NetworkRepairRemove, NetworkRepairReconnect, config.NetworkRepair))
+	}
+
+	if config.Runtime != "" {
+		if _, err := docker.LookupRuntime(config.Runtime); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if config.DockerTimeoutSeconds < 0 {
+		errs = append(errs, errors.Errorf("docker_timeout_seconds %d must not be negative", config.DockerTimeoutSeconds))
+	}
+	if config.PortOffset < 0 || config.PortOffset > 65535 {
+		errs = append(errs, errors.Errorf("port_offset %d must be between 0 and 65535", config.PortOffset))
+	}
+	if config.InstancePortOffset < 0 || config.InstancePortOffset > 65535 {
+		errs = append(errs, errors.Errorf("instance_port_offset %d must be between 0 and 65535",
+			config.InstancePortOffset))
+	}
+
+	for _, name := range config.RegistryNames() {
+		registry := config.Registries[name]
+		if registry.URL == "" {
+			errs = append(errs, errors.Errorf("registry %s has no url", name))
+		} else if _, err := url.Parse(registry.URL); err != nil {
+			errs = append(errs, errors.Errorf("registry %s has an invalid url %s", name, registry.URL))
+		}
+	}
+
+	return errs
+}
+
+func validateBuilder(project string, builder *Builder) []error {
+	errs := []error{}
+	switch builder.Type {
+	case BuilderCompose, BuilderDobi, BuilderBake:
+		if builder.Command != "" {
+			errs = append(errs, errors.Errorf("project %s builder command is only used by the %s builder", project, BuilderCustom))
+		}
+	case BuilderCustom:
+		if builder.Command == "" {
+			errs = append(errs, errors.Errorf("project %s builder has no command", project))
+		} else if _, err := template.New("command").Parse(builder.Command); err != nil {
+			errs = append(errs, errors.Errorf("project %s builder has an invalid command: %s", project, err))
+		}
+	default:
+		errs = append(errs, errors.Errorf("project %s builder type must be one of %s, not '%s'", project,
+			strings.Join([]string{BuilderCompose, BuilderDobi, BuilderBake, BuilderCustom}, ", "), builder.Type))
+	}
+	if builder.File != "" && builder.Type != BuilderDobi && builder.Type != BuilderBake {
+		errs = append(errs, errors.Errorf("project %s builder file is only used by the %s and %s builders", project,
+			BuilderDobi, BuilderBake))
+	}
+	return errs
+}
+
+func validateDownload(config *Dev, project string, download *Download) []error {
+	errs := []error{}
+	sources := map[string]*DownloadSource{"": {Registry: download.Registry, Tag: download.Tag}}
+	for service, source := range download.Services {
+		if source != nil {
+			sources[service] = source
+		}
+	}
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, service := range names {
+		source := sources[service]
+		setting := "download"
+		if service != "" {
+			setting = fmt.Sprintf("download of service %s", service)
+		}
+		if _, ok := config.Registries[source.Registry]; source.Registry != "" && !ok {
+			errs = append(errs, errors.Errorf("project %s %s registry %s is not a configured registry",
+				project, setting, source.Registry))
+		}
+		if _, err := template.New("tag").Parse(source.Tag); err != nil {
+			errs = append(errs, errors.Errorf("project %s %s has an invalid tag: %s", project, setting, err))
+		}
+	}
+	return errs
+}
@@ -4,6 +4,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/spf13/afero"
 	"github.com/spf13/viper"
 )
 
@@ -180,6 +181,33 @@ projects:
 	}
 }
 
+func TestSetInstance(t *testing.T) {
+	config := `
+networks:
+  shared:
+    driver: bridge
+`
+	c := expandedConfigFromString("/home/scraper/dev.yaml", config)
+	if name := c.NetworkName("shared"); name != "shared" {
+		t.Errorf("Expected network name 'shared' without an instance but got '%s'", name)
+	}
+	if err := c.SetInstance("Feature X"); err == nil {
+		t.Error("Expected an invalid instance name to be rejected")
+	}
+	if err := c.SetInstance("feature-x"); err != nil {
+		t.Fatal(err)
+	}
+	if c.ImagePrefix != "scraper-feature-x" {
+		t.Errorf("Expected image prefix to be 'scraper-feature-x' but got '%s'", c.ImagePrefix)
+	}
+	if prefix := c.BaseImagePrefix(); prefix != "scraper" {
+		t.Errorf("Expected base image prefix to be 'scraper' but got '%s'", prefix)
+	}
+	if name := c.NetworkName("shared"); name != "shared-feature-x" {
+		t.Errorf("Expected network name 'shared-feature-x' but got '%s'", name)
+	}
+}
+
 func TestMergeForceSamePrefix(t *testing.T) {
 	config := `
 projects:
@@ -366,3 +394,127 @@ registries:
 		}
 	}
 }
+
+func TestValidate(t *testing.T) {
+	config := `
+image_prefix: "bigco"
+port_offset: -1
+instance_port_offset: 70000
+
+projects:
+  frontend:
+    docker_compose_files:
+      - "docker-compose.yml"
+    depends_on: ["app-net", "ecr", "backend"]
+  backend:
+    docker_compose_files:
+      - "missing.docker-compose.yml"
+    depends_on: ["backend", "nonexistent"]
+  tools:
+    docker_compose_files:
+      - "docker-compose.yml"
+    builder:
+      type: custom
+  worker:
+    docker_compose_files:
+      - "docker-compose.yml"
+    builder:
+      type: make
+      file: Makefile
+    download:
+      registry: gcr
+      tag: "{{.Branch"
+      services:
+        worker:
+          registry: ecr
+
+networks:
+  app-net:
+    driver: bridge
+    ipam:
+      driver: default
+      config:
+        - subnet: 173.16.242.0/16
+  bad-net:
+    driver: bridge
+    ipam:
+      driver: default
+      config:
+        - subnet: 173.16.242.0/99
+  auto-net:
+    driver: bridge
+    ipam:
+      driver: default
+      config:
+        - subnet: auto
+
+registries:
+    ecr:
+      url: "https://aws.ecr.my-region"
+    nourl:
+      username: "developer"
+`
+	c := expandedConfigFromString("/home/bigco/dev.yaml", config)
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/home/bigco/docker-compose.yml", []byte(""), 0644)
+	c.SetFs(fs)
+
+	expected := []string{
+		"project backend docker compose file /home/bigco/missing.docker-compose.yml does not exist",
+		"project backend depends on itself",
+		"project backend depends on nonexistent which is not a configured project, network or registry",
+		"project tools builder has no command",
+		"project worker builder type must be one of compose, dobi, buildx-bake, custom, not 'make'",
+		"project worker builder file is only used by the dobi and buildx-bake builders",
+		"project worker download registry gcr is not a configured registry",
+		"project worker download has an invalid tag: template: tag:1: unclosed action",
+		"network bad-net has an invalid subnet 173.16.242.0/99",
+		"port_offset -1 must be between 0 and 65535",
+		"instance_port_offset 70000 must be between 0 and 65535",
+		"registry nourl has no url",
+	}
+
+	errs := Validate(c)
+	if len(errs) != len(expected) {
+		t.Errorf("Expected %d validation errors but got %d: %v", len(expected), len(errs), errs)
+		return
+	}
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("Expected validation error '%s' but got '%s'", expected[i], err)
+		}
+	}
+}
+
+func TestExpandBuilderFile(t *testing.T) {
+	config := `
+projects:
+  app:
+    docker_compose_files:
+      - "docker-compose.yml"
+    builder:
+      type: buildx-bake
+      file: build/docker-bake.hcl
+      targets:
+        app: app-dev
+  worker:
+    docker_compose_files:
+      - "docker-compose.yml"
+    builder:
+      type: dobi
+`
+	c := expandedConfigFromString("/home/bigco/dev.yaml", config)
+
+	if file := c.Projects["app"].Builder.File; file != "/home/bigco/build/docker-bake.hcl" {
+		t.Errorf("Expected the bake file to be relative to the configuration but got %s", file)
+	}
+	if file := c.Projects["worker"].Builder.File; file != "/home/bigco/dobi.yaml" {
+		t.Errorf("Expected the dobi file to default to dobi.yaml but got %s", file)
+	}
+	if target := c.Projects["app"].Builder.Target("app"); target != "app-dev" {
+		t.Errorf("Expected target app-dev for the app service but got %s", target)
+	}
+	if target := c.Projects["app"].Builder.Target("db"); target != "db" {
+		t.Errorf("Expected target db for the db service but got %s", target)
+	}
+}
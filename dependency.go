@@ -34,6 +34,25 @@ const (
 	// UP constant referring to the "up" command of this project which
 	// starts the project and any of the specified dependencies.
 	UP = "up"
+	// LOCK constant referring to the "lock" command of this project which
+	// pins the images of the project to their digests.
+	LOCK = "lock"
+	// SAVE constant referring to the "save" command of this project which
+	// exports the images of the project and its dependencies to a bundle.
+	SAVE = "save"
+	// OUTDATED constant referring to the "outdated" command of this
+	// project which compares its images with those of their registries.
+	OUTDATED = "outdated"
+	// SNAPSHOT constant referring to the "snapshot" command of this
+	// project which saves and restores the data of its volumes.
+	SNAPSHOT = "snapshot"
+	// LOGS constant referring to the "logs" command of this project which
+	// shows the logs of the project containers.
+	LOGS = "logs"
+	// COMPOSECONFIG constant referring to the "compose-config" command of
+	// this project which exports its compose configuration for use
+	// without dev.
+	COMPOSECONFIG = "compose-config"
 )
 
 // Dependency is the interface that is used by all objects in the dev
@@ -127,22 +146,49 @@ func topologicalSort(dag *d.DAG, vertex *d.Vertex) ([]string, error) {
 	return sorted[0 : len(sorted)-1], nil
 }
 
-// InitDeps runs the PreRun method on each dependency for the specified
-// Project.
-func InitDeps(objMap map[string]Dependency, appConfig *c.Dev, cmd string, project *Project) error {
+// SortDependencies returns the names of all the dependencies of the
+// specified Project in the order in which they must be initialized.
+func SortDependencies(objMap map[string]Dependency, project *Project) ([]string, error) {
 	dag := d.NewDAG()
 	vertex := d.NewVertex(project.Name, project)
 	if err := dag.AddVertex(vertex); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := addDeps(objMap, dag, project); err != nil {
-		return errors.Wrap(err, "Failure mapping dependencies")
+		return nil, errors.Wrap(err, "Failure mapping dependencies")
 	}
 
 	deps, err := topologicalSort(dag, vertex)
 	if err != nil {
-		return errors.Wrapf(err, "Failure sorting dependencies for %s", project.Name)
+		return nil, errors.Wrapf(err, "Failure sorting dependencies for %s", project.Name)
+	}
+	return deps, nil
+}
+
+// DependencyProjects returns the projects the specified Project depends on,
+// directly or through other dependencies, in initialization order.
+func DependencyProjects(objMap map[string]Dependency, project *Project) ([]*Project, error) {
+	deps, err := SortDependencies(objMap, project)
+	if err != nil {
+		return nil, err
+	}
+
+	projects := []*Project{}
+	for _, dep := range deps {
+		if p, ok := objMap[dep].(*Project); ok {
+			projects = append(projects, p)
+		}
+	}
+	return projects, nil
+}
+
+// InitDeps runs the PreRun method on each dependency for the specified
+// Project.
+func InitDeps(objMap map[string]Dependency, appConfig *c.Dev, cmd string, project *Project) error {
+	deps, err := SortDependencies(objMap, project)
+	if err != nil {
+		return err
 	}
 
 	log.Debugf("Initializing dependencies for %s: %s", project.Name, deps)
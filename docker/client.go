@@ -0,0 +1,206 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// timeoutDefault is the time allowed for each call to the docker
+	// daemon when no timeout has been configured.
+	timeoutDefault = 30 * time.Second
+	// defaultContext is the name of the docker context that uses the
+	// environment, or the local daemon, rather than a stored endpoint.
+	defaultContext = "default"
+)
+
+var (
+	clientOnce   sync.Once
+	sharedClient *client.Client
+	clientErr    error
+	timeout      = timeoutDefault
+)
+
+// SetTimeout sets the time allowed for each call to the docker daemon. Calls
+// that stream output, such as following logs, are not limited.
+func SetTimeout(t time.Duration) {
+	if t > 0 {
+		timeout = t
+	}
+}
+
+// callContext returns the context for a call to the docker daemon, limited by
+// the configured timeout.
+func callContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// getDockerClient returns the client shared by every call to the docker
+// daemon made during this run of dev. The client is created on first use and
+// negotiates the API version with the daemon then, instead of pinning one in
+// the environment where it would also apply to docker and compose
+// subprocesses. DOCKER_API_VERSION still takes precedence when set.
+func getDockerClient() (*client.Client, error) {
+	clientOnce.Do(func() {
+		var opts []client.Opt
+		opts, clientErr = clientOpts()
+		if clientErr != nil {
+			return
+		}
+		opts = append(opts, client.WithAPIVersionNegotiation())
+		sharedClient, clientErr = client.NewClientWithOpts(opts...)
+		if clientErr != nil {
+			return
+		}
+
+		// negotiate now rather than on the first request, which may
+		// be one of several made concurrently.
+		ctx, cancel := callContext()
+		defer cancel()
+		sharedClient.NegotiateAPIVersion(ctx)
+		log.Debugf("Using docker daemon at %s with API version %s", sharedClient.DaemonHost(),
+			sharedClient.ClientVersion())
+	})
+	return sharedClient, clientErr
+}
+
+// contextEndpoint is the docker endpoint of a docker context, as stored in the
+// metadata of the context by the docker cli.
+type contextEndpoint struct {
+	Host          string `json:"Host"`
+	SkipTLSVerify bool   `json:"SkipTLSVerify"`
+}
+
+type contextMetadata struct {
+	Name      string                     `json:"Name"`
+	Endpoints map[string]contextEndpoint `json:"Endpoints"`
+}
+
+// dockerConfigDir returns the directory of the docker cli configuration.
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker")
+}
+
+// currentContext returns the name of the docker context selected with
+// DOCKER_CONTEXT or, failing that, with 'docker context use'.
+func currentContext(configDir string) string {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name
+	}
+	data, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		return defaultContext
+	}
+	var config struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil || config.CurrentContext == "" {
+		return defaultContext
+	}
+	return config.CurrentContext
+}
+
+// contextDirName is the name of the directories the docker cli stores the
+// metadata and TLS material of a context in.
+func contextDirName(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadContextEndpoint reads the docker endpoint of the named context.
+func loadContextEndpoint(configDir, name string) (*contextEndpoint, error) {
+	filename := filepath.Join(configDir, "contexts", "meta", contextDirName(name), "meta.json")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "docker context %s not found", name)
+	}
+	var meta contextMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", filename)
+	}
+	endpoint, ok := meta.Endpoints["docker"]
+	if !ok || endpoint.Host == "" {
+		return nil, errors.Errorf("docker context %s has no docker endpoint", name)
+	}
+	return &endpoint, nil
+}
+
+// clientOpts returns the options that point the client at the docker daemon
+// the docker cli would use. DOCKER_HOST and the related TLS variables take
+// precedence, then the docker context selected with DOCKER_CONTEXT or 'docker
+// context use'. Runtimes other than docker use the socket of their docker
+// compatible API instead of contexts.
+func clientOpts() ([]client.Opt, error) {
+	if os.Getenv("DOCKER_HOST") != "" {
+		return []client.Opt{client.FromEnv}, nil
+	}
+	if runtime := CurrentRuntime(); runtime.Name != RuntimeDocker {
+		host := runtime.Host()
+		if host == "" {
+			return nil, errors.Errorf("no docker compatible API found for the %s runtime, "+
+				"set DOCKER_HOST to its address", runtime.Name)
+		}
+		return []client.Opt{client.FromEnv, client.WithHost(host)}, nil
+	}
+
+	configDir := dockerConfigDir()
+	name := currentContext(configDir)
+	if name == defaultContext {
+		return []client.Opt{client.FromEnv}, nil
+	}
+
+	endpoint, err := loadContextEndpoint(configDir, name)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Using docker context %s", name)
+
+	opts := []client.Opt{}
+	tlsDir := filepath.Join(configDir, "contexts", "tls", contextDirName(name), "docker")
+	if _, err := os.Stat(tlsDir); err == nil || endpoint.SkipTLSVerify {
+		options := tlsconfig.Options{InsecureSkipVerify: endpoint.SkipTLSVerify}
+		for filename, option := range map[string]*string{
+			"ca.pem":   &options.CAFile,
+			"cert.pem": &options.CertFile,
+			"key.pem":  &options.KeyFile,
+		} {
+			if _, err := os.Stat(filepath.Join(tlsDir, filename)); err == nil {
+				*option = filepath.Join(tlsDir, filename)
+			}
+		}
+		tlsc, err := tlsconfig.Client(options)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load TLS configuration of docker context %s", name)
+		}
+		opts = append(opts, client.WithHTTPClient(&http.Client{
+			Transport:     &http.Transport{TLSClientConfig: tlsc},
+			CheckRedirect: client.CheckRedirect,
+		}))
+	}
+	opts = append(opts, client.WithHost(endpoint.Host))
+	if version := os.Getenv("DOCKER_API_VERSION"); version != "" {
+		opts = append(opts, client.WithVersion(version))
+	}
+	return opts, nil
+}
@@ -0,0 +1,66 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setenv(t *testing.T, name, value string) {
+	old, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func writeFile(t *testing.T, filename, content string) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCurrentContext(t *testing.T) {
+	dir := t.TempDir()
+	setenv(t, "DOCKER_CONTEXT", "")
+
+	if name := currentContext(dir); name != defaultContext {
+		t.Errorf("Expected context %s without a config file but got %s", defaultContext, name)
+	}
+
+	writeFile(t, filepath.Join(dir, "config.json"), `{"currentContext": "remote"}`)
+	if name := currentContext(dir); name != "remote" {
+		t.Errorf("Expected context remote from the config file but got %s", name)
+	}
+
+	setenv(t, "DOCKER_CONTEXT", "other")
+	if name := currentContext(dir); name != "other" {
+		t.Errorf("Expected context other from DOCKER_CONTEXT but got %s", name)
+	}
+}
+
+func TestLoadContextEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "contexts", "meta", contextDirName("remote"), "meta.json"),
+		`{"Name": "remote", "Endpoints": {"docker": {"Host": "tcp://10.0.0.5:2376", "SkipTLSVerify": true}}}`)
+
+	endpoint, err := loadContextEndpoint(dir, "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint.Host != "tcp://10.0.0.5:2376" || !endpoint.SkipTLSVerify {
+		t.Errorf("Unexpected endpoint %+v", endpoint)
+	}
+
+	if _, err := loadContextEndpoint(dir, "missing"); err == nil {
+		t.Error("Expected an error loading a context that does not exist")
+	}
+}
@@ -1,70 +1,45 @@
 package docker
 
 import (
-	"context"
-	"os"
-	"time"
+	"strings"
 
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/network"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	timeoutSecondsDefault = time.Second * 2
+	// ComposeProjectLabel is the label compose adds to every container it
+	// creates to record the project the container belongs to.
+	ComposeProjectLabel = "com.docker.compose.project"
+	// ComposeServiceLabel is the label compose adds to every container it
+	// creates to record the service the container was created for.
+	ComposeServiceLabel = "com.docker.compose.service"
 )
 
-func getDockerClient() (*client.Client, error) {
-	if os.Getenv("DOCKER_API_VERSION") == "" {
-		// Bump this as testing is performed on later versions
-		if err := os.Setenv("DOCKER_API_VERSION", "1.25"); err != nil {
-			log.Warn("Failed to set DOCKER_API_VERSION")
-		}
-	}
-	return client.NewEnvClient()
-}
-
-// NetworkCreate sends a request to the local docker daemon to create the ipam
+// NetworkCreate sends a request to the docker daemon to create the ipam
 // network specified with name with the provided ipam options.
 //
 // Returns the network id of the created network or an error.
-func NetworkCreate(name string, opts *types.NetworkCreate) (string, error) {
-	if os.Getenv("DOCKER_API_VERSION") == "" {
-		// Bump this as testing is performed on later versions
-		if err := os.Setenv("DOCKER_API_VERSION", "1.25"); err != nil {
-			log.Warn("Failed to set DOCKER_API_VERSION")
-		}
-	}
-	cli, err := getDockerClient()
+func NetworkCreate(e Engine, name string, opts *types.NetworkCreate) (string, error) {
+	res, err := e.NetworkCreate(name, *opts)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to create docker client")
-	}
-	res, err := cli.NetworkCreate(context.Background(), name, *opts)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to create network")
+		return "", err
 	}
 	if res.Warning != "" {
 		log.Warn(res.Warning)
 	}
-	return res.ID, err
-
+	return res.ID, nil
 }
 
 // NetworkIDFromName checks for the existence of the supplied network name and
 // returns its id if it exists. If it does not exist an empty string is
 // returned.
-func NetworkIDFromName(name string) (string, error) {
-	cli, err := getDockerClient()
+func NetworkIDFromName(e Engine, name string) (string, error) {
+	resources, err := e.NetworkList()
 	if err != nil {
-		return "", errors.Wrap(err, "failed to create docker client")
-	}
-
-	var resources []types.NetworkResource // saving you 20 seconds since 2019
-	resources, err = cli.NetworkList(context.Background(), types.NetworkListOptions{})
-	if err != nil {
-		return "", errors.Wrap(err, "failed to retrieve networks from docker")
+		return "", err
 	}
 	for _, network := range resources {
 		if network.Name == name {
@@ -74,85 +49,263 @@ func NetworkIDFromName(name string) (string, error) {
 	return "", nil
 }
 
-// RemoveContainerIfRequired checks each exited container in the container name list and
-// removes any of those containers if it is attached to the network name provided but with
-// a different network ID.
-func RemoveContainerIfRequired(networkName, networkID string, containerNames []string) error {
-	cli, err := getDockerClient()
-	if err != nil {
-		return errors.Wrap(err, "failed to create docker client")
+// containerNames returns the names of the container without the leading
+// slash docker adds to them.
+func containerNames(container types.Container) []string {
+	names := make([]string, len(container.Names))
+	for i, name := range container.Names {
+		// why are slashes added to container names?
+		names[i] = strings.TrimPrefix(name, "/")
 	}
+	return names
+}
 
-	// only protecting against containers connected to the dead/wrong network,
-	// so we only need to look for stopped containers here. Might be nice
-	// to verify the network settings are correct/haven't changed for up
-	// containers..
-	options := types.ContainerListOptions{
-		All:     true,
-		Filters: filters.NewArgs(filters.Arg("status", "exited")),
-	}
-	containers, err := cli.ContainerList(context.Background(), options)
+// RepairContainerNetwork ensures the containers of the services that use the
+// named network are attached to the network with the provided network ID.
+// Endpoints maps the name of each service, or of its container, to the
+// settings it is attached to the network with.
+//
+// Networks do not persist reboots, so containers may still be attached to a
+// network that no longer exists. Running containers attached to such a stale
+// network, or that are not attached at all, are reconnected to the current
+// network with the same aliases and addresses. Exited containers attached to a
+// stale network cannot be started; they are removed so compose recreates them,
+// or reconnected when reconnectExited is true.
+func RepairContainerNetwork(e Engine, composeProject, networkName, networkID string,
+	endpoints map[string]*network.EndpointSettings, reconnectExited bool) error {
+
+	containers, err := e.ContainerList()
 	if err != nil {
 		return err
 	}
 
-	containerMap := make(map[string]bool, len(containerNames))
-	for _, name := range containerNames {
-		containerMap[name] = true
-	}
-
 	for _, container := range containers {
-		modNames := make([]string, len(container.Names))
-		for i, name := range container.Names {
-			// why are slashes added to container names?
-			if string(name[0]) == "/" {
-				modNames[i] = name[1:]
-			} else {
-				modNames[i] = name
+		names := containerNames(container)
+		if container.Labels[ComposeProjectLabel] == composeProject {
+			names = append(names, container.Labels[ComposeServiceLabel])
+		}
+		var endpoint *network.EndpointSettings
+		var name string
+		for _, name = range names {
+			if configured, ok := endpoints[name]; ok {
+				endpoint = configured
+				break
 			}
 		}
+		if endpoint == nil {
+			continue
+		}
 
-		for _, name := range modNames {
-			if _, ok := containerMap[name]; ok {
-				// check if this container is attached to the
-				// specified network but has a different id for
-				// it. If so, remove the container as it cannot
-				// be brought up with a network that doesn't
-				// exist..mostly likely to happen on reboot.
-				if settings, ok := container.NetworkSettings.Networks[networkName]; ok {
-					if settings.NetworkID != networkID {
-						log.Debugf("%s attached to %s with a different network id, removing", name, networkName)
-						opts := types.ContainerRemoveOptions{}
-						if err := cli.ContainerRemove(context.Background(), container.ID, opts); err != nil {
-							return err
-						}
-					}
-				}
+		var settings *network.EndpointSettings
+		if container.NetworkSettings != nil {
+			settings = container.NetworkSettings.Networks[networkName]
+		}
+		attached := settings != nil
+		stale := attached && settings.NetworkID != networkID
+		running := container.State == "running"
+
+		switch {
+		case running && (stale || !attached):
+			log.Infof("Reconnecting running container %s to network %s", name, networkName)
+		case !running && stale && reconnectExited:
+			log.Infof("Reconnecting exited container %s to network %s", name, networkName)
+		case !running && stale:
+			// the container cannot be brought up with a network
+			// that doesn't exist..mostly likely to happen on
+			// reboot.
+			log.Debugf("%s attached to %s with a different network id, removing", name, networkName)
+			if err := e.ContainerRemove(container.ID, false); err != nil {
+				return err
 			}
+			continue
+		default:
+			continue
 		}
 
+		reconnect := &network.EndpointSettings{
+			Aliases:    endpoint.Aliases,
+			IPAMConfig: endpoint.IPAMConfig,
+		}
+		if stale {
+			// keep the settings the container was created with
+			if reconnect.IPAMConfig == nil {
+				reconnect.IPAMConfig = settings.IPAMConfig
+			}
+			if len(reconnect.Aliases) == 0 {
+				reconnect.Aliases = settings.Aliases
+			}
+			// disconnecting by name removes the endpoint of the
+			// network that no longer exists
+			if err := e.NetworkDisconnect(networkName, container.ID, true); err != nil {
+				log.Debugf("Failed to disconnect %s from stale network %s: %s", name, networkName, err)
+			}
+		}
+		if err := e.NetworkConnect(networkID, container.ID, reconnect); err != nil {
+			return errors.Wrapf(err, "failed to connect container %s to network %s", name, networkName)
+		}
 	}
 	return nil
 }
 
-// IsContainerRunning checks if there is a container with status "up" for the
-// specified project.
-func IsContainerRunning(name string) (bool, error) {
-	cli, err := getDockerClient()
+// ServiceContainer returns the running container of the specified service of
+// the compose project, or the running container with that name when compose
+// has not created one, i.e., when the service sets a container_name. Nil is
+// returned when no such container is running.
+func ServiceContainer(e Engine, composeProject, service string) (*types.Container, error) {
+	containers, err := e.ContainerList()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to check container status")
+	}
+
+	var named *types.Container
+	for i, container := range containers {
+		if container.State != "running" {
+			continue
+		}
+		if container.Labels[ComposeProjectLabel] == composeProject &&
+			container.Labels[ComposeServiceLabel] == service {
+			return &containers[i], nil
+		}
+		for _, name := range containerNames(container) {
+			if name == service && named == nil {
+				named = &containers[i]
+			}
+		}
+	}
+	return named, nil
+}
+
+// ContainerName returns the name of the container, without the leading slash.
+func ContainerName(container *types.Container) string {
+	names := containerNames(*container)
+	if len(names) == 0 {
+		return container.ID
+	}
+	return names[0]
+}
+
+// ComposeContainers returns the containers created by compose for the
+// specified compose project, including stopped containers. If services is not
+// empty only the containers of those services are returned.
+func ComposeContainers(e Engine, composeProject string, services []string) ([]types.Container, error) {
+	containers, err := e.ContainerList()
 	if err != nil {
-		return false, errors.Wrap(err, "failed to create docker client")
+		return nil, err
 	}
 
-	options := types.ContainerListOptions{
-		//All: true,
-		Filters: filters.NewArgs(
-			filters.Arg("status", "running"),
-			filters.Arg("name", name)),
+	serviceMap := make(map[string]bool, len(services))
+	for _, service := range services {
+		serviceMap[service] = true
+	}
+	filtered := []types.Container{}
+	for _, container := range containers {
+		if container.Labels[ComposeProjectLabel] != composeProject {
+			continue
+		}
+		if len(services) == 0 || serviceMap[container.Labels[ComposeServiceLabel]] {
+			filtered = append(filtered, container)
+		}
 	}
-	containers, err := cli.ContainerList(context.Background(), options)
+	return filtered, nil
+}
+
+// ImageList returns all of the images known to the docker daemon that were
+// built or tagged with the specified prefix, as compose does for the images it
+// builds. All images are returned if the prefix is empty.
+func ImageList(e Engine, prefix string) ([]types.ImageSummary, error) {
+	images, err := e.ImageList()
 	if err != nil {
-		return false, errors.Wrap(err, "Failed to check container status")
+		return nil, err
+	}
+	if prefix == "" {
+		return images, nil
 	}
-	//log.Debugf("containers: %+v", containers)
-	return len(containers) > 0, nil
+
+	filtered := []types.ImageSummary{}
+	for _, image := range images {
+		for _, tag := range image.RepoTags {
+			if strings.HasPrefix(tag, prefix+"_") || strings.HasPrefix(tag, prefix+"-") {
+				filtered = append(filtered, image)
+				break
+			}
+		}
+	}
+	return filtered, nil
+}
+
+// NetworkRecreate removes the existing network with the specified name and
+// creates it again with the provided options. Containers attached to the
+// network are disconnected first and reconnected to the new network with the
+// same aliases and addresses afterwards. A container that cannot be
+// reconnected, i.e., because its address is not valid in the new network, is
+// removed so that compose recreates it.
+//
+// Returns the network id of the created network or an error.
+func NetworkRecreate(e Engine, name string, opts *types.NetworkCreate) (string, error) {
+	resource, err := e.NetworkInspect(name)
+	if err != nil {
+		return "", err
+	}
+
+	endpoints := make(map[string]*network.EndpointSettings, len(resource.Containers))
+	for containerID := range resource.Containers {
+		info, err := e.ContainerInspect(containerID)
+		if err != nil {
+			return "", err
+		}
+		settings := &network.EndpointSettings{}
+		if info.NetworkSettings != nil {
+			if current, ok := info.NetworkSettings.Networks[name]; ok && current != nil {
+				settings.Aliases = current.Aliases
+				settings.Links = current.Links
+				settings.IPAMConfig = current.IPAMConfig
+			}
+		}
+		endpoints[containerID] = settings
+
+		log.Debugf("Disconnecting %s from network %s", info.Name, name)
+		if err := e.NetworkDisconnect(resource.ID, containerID, true); err != nil {
+			return "", errors.Wrapf(err, "failed to disconnect container %s from %s", info.Name, name)
+		}
+	}
+
+	if err := e.NetworkRemove(resource.ID); err != nil {
+		return "", err
+	}
+	networkID, err := NetworkCreate(e, name, opts)
+	if err != nil {
+		return "", err
+	}
+
+	for containerID, settings := range endpoints {
+		if err := e.NetworkConnect(networkID, containerID, settings); err != nil {
+			log.Warnf("Unable to reconnect container %s to %s, removing it so it is recreated: %s",
+				containerID, name, err)
+			if err := e.ContainerRemove(containerID, true); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return networkID, nil
+}
+
+// NetworkRemove removes the specified network. If force is true any
+// containers attached to the network are disconnected from it first,
+// otherwise docker refuses to remove a network with attached containers.
+func NetworkRemove(e Engine, name string, force bool) error {
+	if force {
+		resource, err := e.NetworkInspect(name)
+		if err != nil {
+			return err
+		}
+		for containerID, endpoint := range resource.Containers {
+			log.Debugf("Disconnecting %s from network %s", endpoint.Name, name)
+			if err := e.NetworkDisconnect(resource.ID, containerID, true); err != nil {
+				return errors.Wrapf(err, "failed to disconnect container %s from %s", endpoint.Name, name)
+			}
+		}
+	}
+
+	return e.NetworkRemove(name)
 }
@@ -0,0 +1,156 @@
+package docker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+func composeContainer(name, project, service, state string, networks map[string]*network.EndpointSettings) types.Container {
+	return types.Container{
+		ID:    name,
+		Names: []string{"/" + name},
+		State: state,
+		Labels: map[string]string{
+			ComposeProjectLabel: project,
+			ComposeServiceLabel: service,
+		},
+		NetworkSettings: &types.SummaryNetworkSettings{Networks: networks},
+	}
+}
+
+func TestRepairContainerNetwork(t *testing.T) {
+	tests := []struct {
+		Name            string
+		State           string
+		Attached        bool
+		Stale           bool
+		ReconnectExited bool
+		Removed         bool
+		Connected       bool
+	}{
+		{"running stale", "running", true, true, false, false, true},
+		{"running detached", "running", false, false, false, false, true},
+		{"running current", "running", true, false, false, false, true},
+		{"exited stale", "exited", true, true, false, true, false},
+		{"exited stale reconnect", "exited", true, true, true, false, true},
+		{"exited detached", "exited", false, false, false, false, false},
+	}
+
+	for _, test := range tests {
+		engine := NewFakeEngine()
+		networkID := engine.AddNetwork("app-net", types.NetworkCreate{})
+
+		networks := map[string]*network.EndpointSettings{}
+		if test.Attached {
+			settings := &network.EndpointSettings{NetworkID: networkID, Aliases: []string{"app"}}
+			if test.Stale {
+				settings.NetworkID = "removed-network"
+			}
+			networks["app-net"] = settings
+		}
+		containerID := engine.AddContainer(composeContainer("dev-app-1", "dev", "app", test.State, networks))
+		otherID := engine.AddContainer(composeContainer("other-app-1", "other", "app", "exited",
+			map[string]*network.EndpointSettings{"app-net": {NetworkID: "removed-network"}}))
+
+		endpoints := map[string]*network.EndpointSettings{
+			"app": {Aliases: []string{"app", "api"}},
+		}
+		if err := RepairContainerNetwork(engine, "dev", "app-net", networkID, endpoints, test.ReconnectExited); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.Name, err)
+			continue
+		}
+
+		if _, ok := engine.Container(otherID); !ok {
+			t.Errorf("%s: container of another compose project was removed", test.Name)
+		}
+		container, ok := engine.Container(containerID)
+		if ok == test.Removed {
+			t.Errorf("%s: expected container removed to be %t", test.Name, test.Removed)
+			continue
+		}
+		if test.Removed {
+			continue
+		}
+		settings := container.NetworkSettings.Networks["app-net"]
+		connected := settings != nil && settings.NetworkID == networkID
+		if connected != test.Connected {
+			t.Errorf("%s: expected container connected to be %t but got %+v", test.Name, test.Connected, settings)
+		}
+		if connected && (test.Stale || !test.Attached) && !reflect.DeepEqual(settings.Aliases, []string{"app", "api"}) {
+			t.Errorf("%s: expected aliases [app api] but got %v", test.Name, settings.Aliases)
+		}
+	}
+}
+
+func TestNetworkRecreate(t *testing.T) {
+	engine := NewFakeEngine()
+	oldID := engine.AddNetwork("app-net", types.NetworkCreate{
+		IPAM: &network.IPAM{Config: []network.IPAMConfig{{Subnet: "173.16.242.0/24"}}},
+	})
+	keptID := engine.AddContainer(composeContainer("dev-app-1", "dev", "app", "running", nil))
+	if err := engine.NetworkConnect(oldID, keptID, &network.EndpointSettings{Aliases: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+	removedID := engine.AddContainer(composeContainer("dev-db-1", "dev", "db", "running", nil))
+	settings := &network.EndpointSettings{
+		IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "173.16.242.20"},
+	}
+	if err := engine.NetworkConnect(oldID, removedID, settings); err != nil {
+		t.Fatal(err)
+	}
+
+	networkID, err := NetworkRecreate(engine, "app-net", &types.NetworkCreate{
+		IPAM: &network.IPAM{Config: []network.IPAMConfig{{Subnet: "173.16.243.0/24"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if networkID == oldID {
+		t.Errorf("Expected a new network id")
+	}
+
+	kept, ok := engine.Container(keptID)
+	if !ok {
+		t.Fatalf("Expected container %s to be kept", keptID)
+	}
+	if settings := kept.NetworkSettings.Networks["app-net"]; settings == nil || settings.NetworkID != networkID ||
+		!reflect.DeepEqual(settings.Aliases, []string{"app"}) {
+		t.Errorf("Expected container to be reconnected with its aliases but got %+v", settings)
+	}
+	if _, ok := engine.Container(removedID); ok {
+		t.Errorf("Expected container %s with an address outside the new subnet to be removed", removedID)
+	}
+}
+
+func TestServiceContainer(t *testing.T) {
+	engine := NewFakeEngine()
+	engine.AddContainer(composeContainer("other-app-1", "other", "app", "running", nil))
+	engine.AddContainer(composeContainer("dev-app-1", "dev", "app", "exited", nil))
+	engine.AddContainer(composeContainer("dev-app-2", "dev", "app", "running", nil))
+	engine.AddContainer(composeContainer("tools", "", "", "running", nil))
+
+	tests := []struct {
+		Service  string
+		Expected string
+	}{
+		{"app", "dev-app-2"},
+		{"tools", "tools"},
+		{"db", ""},
+	}
+	for _, test := range tests {
+		container, err := ServiceContainer(engine, "dev", test.Service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := ""
+		if container != nil {
+			name = ContainerName(container)
+		}
+		if name != test.Expected {
+			t.Errorf("Expected container '%s' for service %s but got '%s'", test.Expected, test.Service, name)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/pkg/errors"
+)
+
+// Engine is the set of docker daemon operations used by dev. The functions of
+// this package are written against it so that they can be tested with the
+// FakeEngine instead of a running daemon.
+//
+// Commands that attach to a terminal, i.e., an interactive 'sh', run through
+// the docker cli rather than the engine as it handles the raw mode, resizing
+// and signals of the terminal.
+type Engine interface {
+	// NetworkCreate creates a network and returns its id.
+	NetworkCreate(name string, opts types.NetworkCreate) (types.NetworkCreateResponse, error)
+	// NetworkList returns all of the networks.
+	NetworkList() ([]types.NetworkResource, error)
+	// NetworkInspect returns the network with the specified id or name,
+	// including the containers attached to it.
+	NetworkInspect(network string) (types.NetworkResource, error)
+	// NetworkConnect attaches a container to a network.
+	NetworkConnect(network, containerID string, settings *network.EndpointSettings) error
+	// NetworkDisconnect detaches a container from a network.
+	NetworkDisconnect(network, containerID string, force bool) error
+	// NetworkRemove removes a network.
+	NetworkRemove(network string) error
+
+	// ContainerList returns all of the containers, including stopped
+	// containers.
+	ContainerList() ([]types.Container, error)
+	// ContainerInspect returns the low level information on a container.
+	ContainerInspect(containerID string) (types.ContainerJSON, error)
+	// ContainerRemove removes a container, stopping it first if force is
+	// true.
+	ContainerRemove(containerID string, force bool) error
+	// ContainerLogs returns the log stream of a container. The stream is
+	// not limited by the engine timeout, only by ctx.
+	ContainerLogs(ctx context.Context, containerID string, opts types.ContainerLogsOptions) (io.ReadCloser, error)
+	// ContainerExec runs the command on a running container, without a tty
+	// or stdin, writing its output to stdout and stderr, and returns its
+	// exit code. It is not limited by the engine timeout, only by ctx.
+	ContainerExec(ctx context.Context, containerID string, cmd []string, stdout, stderr io.Writer) (int, error)
+
+	// ImageList returns all of the tagged images.
+	ImageList() ([]types.ImageSummary, error)
+	// ImageInspect returns the low level information on an image.
+	ImageInspect(imageID string) (types.ImageInspect, error)
+	// ImageSave returns an archive of the images in the format of 'docker
+	// save'. The stream is not limited by the engine timeout, only by
+	// ctx.
+	ImageSave(ctx context.Context, images []string) (io.ReadCloser, error)
+	// ImageLoad imports the images of an archive in the format of 'docker
+	// save'. It is not limited by the engine timeout, only by ctx.
+	ImageLoad(ctx context.Context, archive io.Reader) error
+	// ImageTag tags the image with the reference target.
+	ImageTag(image, target string) error
+	// ImageRemove removes an image and its untagged parents. With force
+	// an image with several tags, or used by a stopped container, is
+	// removed.
+	ImageRemove(imageID string, force bool) error
+
+	// VolumeList returns all of the volumes.
+	VolumeList() ([]*types.Volume, error)
+	// VolumeRemove removes a volume. With force the volume is removed
+	// even if it is in use by a stopped container.
+	VolumeRemove(name string, force bool) error
+
+	// ServerVersion returns the version information of the daemon.
+	ServerVersion() (types.Version, error)
+	// Info returns system wide information about the daemon.
+	Info() (types.Info, error)
+	// APIVersion returns the API version used to talk to the daemon.
+	APIVersion() (string, error)
+}
+
+// clientEngine is the Engine backed by the docker daemon.
+type clientEngine struct{}
+
+// NewEngine returns the Engine that talks to the docker daemon through the
+// client shared by this run of dev. The client is only created when the first
+// operation is performed.
+func NewEngine() Engine {
+	return &clientEngine{}
+}
+
+func (ce *clientEngine) NetworkCreate(name string, opts types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return types.NetworkCreateResponse{}, errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	res, err := cli.NetworkCreate(ctx, name, opts)
+	if err != nil {
+		return types.NetworkCreateResponse{}, errors.Wrap(err, "failed to create network")
+	}
+	return res, nil
+}
+
+func (ce *clientEngine) NetworkList() ([]types.NetworkResource, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve networks from docker")
+	}
+	return networks, nil
+}
+
+func (ce *clientEngine) NetworkInspect(network string) (types.NetworkResource, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return types.NetworkResource{}, errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	resource, err := cli.NetworkInspect(ctx, network, types.NetworkInspectOptions{})
+	if err != nil {
+		return types.NetworkResource{}, errors.Wrapf(err, "failed to inspect network %s", network)
+	}
+	return resource, nil
+}
+
+func (ce *clientEngine) NetworkConnect(network, containerID string, settings *network.EndpointSettings) error {
+	cli, err := getDockerClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	return cli.NetworkConnect(ctx, network, containerID, settings)
+}
+
+func (ce *clientEngine) NetworkDisconnect(network, containerID string, force bool) error {
+	cli, err := getDockerClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	return cli.NetworkDisconnect(ctx, network, containerID, force)
+}
+
+func (ce *clientEngine) NetworkRemove(network string) error {
+	cli, err := getDockerClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	if err := cli.NetworkRemove(ctx, network); err != nil {
+		return errors.Wrapf(err, "failed to remove network %s", network)
+	}
+	return nil
+}
+
+func (ce *clientEngine) ContainerList() ([]types.Container, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list containers")
+	}
+	return containers, nil
+}
+
+func (ce *clientEngine) ContainerInspect(containerID string) (types.ContainerJSON, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return types.ContainerJSON{}, errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	info, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return types.ContainerJSON{}, errors.Wrapf(err, "failed to inspect container %s", containerID)
+	}
+	return info, nil
+}
+
+func (ce *clientEngine) ContainerRemove(containerID string, force bool) error {
+	cli, err := getDockerClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	if err := cli.ContainerRemove(ctx, containerID, types.ContainerRemoveOptions{Force: force}); err != nil {
+		return errors.Wrapf(err, "failed to remove container %s", containerID)
+	}
+	return nil
+}
+
+func (ce *clientEngine) ContainerLogs(ctx context.Context, containerID string, opts types.ContainerLogsOptions) (io.ReadCloser, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create docker client")
+	}
+	rc, err := cli.ContainerLogs(ctx, containerID, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read logs of container %s", containerID)
+	}
+	return rc, nil
+}
+
+func (ce *clientEngine) ContainerExec(ctx context.Context, containerID string, cmd []string, stdout, stderr io.Writer) (int, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create docker client")
+	}
+	exec, err := cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd: cmd, AttachStdout: true, AttachStderr: true})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to create exec on container %s", containerID)
+	}
+	resp, err := cli.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to attach to exec on container %s", containerID)
+	}
+	defer resp.Close()
+	if _, err := stdcopy.StdCopy(stdout, stderr, resp.Reader); err != nil {
+		return 0, errors.Wrapf(err, "failed to read the output of exec on container %s", containerID)
+	}
+	info, err := cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to inspect exec on container %s", containerID)
+	}
+	return info.ExitCode, nil
+}
+
+func (ce *clientEngine) ImageList() ([]types.ImageSummary, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	images, err := cli.ImageList(ctx, types.ImageListOptions{All: false})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve images from docker")
+	}
+	return images, nil
+}
+
+func (ce *clientEngine) ImageInspect(imageID string) (types.ImageInspect, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return types.ImageInspect{}, errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	info, _, err := cli.ImageInspectWithRaw(ctx, imageID)
+	if err != nil {
+		return types.ImageInspect{}, errors.Wrapf(err, "failed to inspect image %s", imageID)
+	}
+	return info, nil
+}
+
+func (ce *clientEngine) ImageSave(ctx context.Context, images []string) (io.ReadCloser, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create docker client")
+	}
+	rc, err := cli.ImageSave(ctx, images)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save images")
+	}
+	return rc, nil
+}
+
+// loadMessage is a message of the progress stream of an image load.
+type loadMessage struct {
+	Stream string `json:"stream"`
+	Error  string `json:"error"`
+}
+
+func (ce *clientEngine) ImageLoad(ctx context.Context, archive io.Reader) error {
+	cli, err := getDockerClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker client")
+	}
+	res, err := cli.ImageLoad(ctx, archive, true)
+	if err != nil {
+		return errors.Wrap(err, "failed to load images")
+	}
+	defer res.Body.Close()
+
+	// the daemon reports failures in the stream rather than with the
+	// status of the response
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var msg loadMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			if !res.JSON {
+				return nil
+			}
+			return errors.Wrap(err, "failed to read the response of the image load")
+		}
+		if msg.Error != "" {
+			return errors.Errorf("failed to load images: %s", msg.Error)
+		}
+	}
+}
+
+func (ce *clientEngine) ImageTag(image, target string) error {
+	cli, err := getDockerClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	if err := cli.ImageTag(ctx, image, target); err != nil {
+		return errors.Wrapf(err, "failed to tag image %s as %s", image, target)
+	}
+	return nil
+}
+
+func (ce *clientEngine) ImageRemove(imageID string, force bool) error {
+	cli, err := getDockerClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	opts := types.ImageRemoveOptions{Force: force, PruneChildren: true}
+	if _, err := cli.ImageRemove(ctx, imageID, opts); err != nil {
+		return errors.Wrapf(err, "failed to remove image %s", imageID)
+	}
+	return nil
+}
+
+func (ce *clientEngine) VolumeList() ([]*types.Volume, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	volumes, err := cli.VolumeList(ctx, filters.NewArgs())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list volumes")
+	}
+	return volumes.Volumes, nil
+}
+
+func (ce *clientEngine) VolumeRemove(name string, force bool) error {
+	cli, err := getDockerClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	if err := cli.VolumeRemove(ctx, name, force); err != nil {
+		return errors.Wrapf(err, "failed to remove volume %s", name)
+	}
+	return nil
+}
+
+func (ce *clientEngine) ServerVersion() (types.Version, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return types.Version{}, errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	version, err := cli.ServerVersion(ctx)
+	if err != nil {
+		return types.Version{}, errors.Wrap(err, "failed to retrieve docker version")
+	}
+	return version, nil
+}
+
+func (ce *clientEngine) Info() (types.Info, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return types.Info{}, errors.Wrap(err, "failed to create docker client")
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return types.Info{}, errors.Wrap(err, "failed to retrieve docker info")
+	}
+	return info, nil
+}
+
+func (ce *clientEngine) APIVersion() (string, error) {
+	cli, err := getDockerClient()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create docker client")
+	}
+	return cli.ClientVersion(), nil
+}
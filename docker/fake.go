@@ -0,0 +1,563 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/pkg/errors"
+)
+
+// FakeEngine is an in-memory Engine for tests. It keeps enough state for the
+// networks and containers it holds to behave as they would with a daemon:
+// containers are attached to networks by id, a network with containers
+// attached cannot be removed and a running container cannot be removed unless
+// forced.
+type FakeEngine struct {
+	// Images returned by ImageList.
+	Images []types.ImageSummary
+	// ImageDetails returned by ImageInspect, by image id.
+	ImageDetails map[string]types.ImageInspect
+	// Volumes returned by VolumeList.
+	Volumes []*types.Volume
+	// Logs returned by ContainerLogs, by container id. Fake containers
+	// have a tty so the logs are not multiplexed.
+	Logs map[string]string
+	// Execs are the commands run by ContainerExec.
+	Execs []FakeExec
+	// ExecResults returned by ContainerExec, by the command joined by
+	// spaces. Commands without a result succeed without output.
+	ExecResults map[string]FakeExecResult
+	// Version returned by ServerVersion and, for its API version, by
+	// APIVersion.
+	Version types.Version
+	// SystemInfo returned by Info.
+	SystemInfo types.Info
+
+	mu         sync.Mutex
+	nextID     int
+	networks   map[string]*types.NetworkResource
+	containers map[string]*types.Container
+}
+
+// FakeExec is a command run on a container of the FakeEngine.
+type FakeExec struct {
+	ContainerID string
+	Cmd         []string
+}
+
+// FakeExecResult is the output and exit code of a command run on a container
+// of the FakeEngine.
+type FakeExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// NewFakeEngine returns a FakeEngine without networks, containers or images.
+func NewFakeEngine() *FakeEngine {
+	return &FakeEngine{
+		ImageDetails: make(map[string]types.ImageInspect),
+		Logs:         make(map[string]string),
+		ExecResults:  make(map[string]FakeExecResult),
+		Version:      types.Version{Version: "fake", APIVersion: "1.40"},
+		networks:     make(map[string]*types.NetworkResource),
+		containers:   make(map[string]*types.Container),
+	}
+}
+
+func (f *FakeEngine) newID(kind string) string {
+	f.nextID++
+	return fmt.Sprintf("%s%d", kind, f.nextID)
+}
+
+// findNetwork returns the network with the specified id or name.
+func (f *FakeEngine) findNetwork(idOrName string) *types.NetworkResource {
+	if resource, ok := f.networks[idOrName]; ok {
+		return resource
+	}
+	for _, resource := range f.networks {
+		if resource.Name == idOrName {
+			return resource
+		}
+	}
+	return nil
+}
+
+// AddNetwork creates a network as NetworkCreate does and returns its id.
+func (f *FakeEngine) AddNetwork(name string, opts types.NetworkCreate) string {
+	res, err := f.NetworkCreate(name, opts)
+	if err != nil {
+		panic(err)
+	}
+	return res.ID
+}
+
+// AddContainer adds a container, assigning it an id if it has none, and
+// returns its id. The container is attached to each network in its network
+// settings whose network id is that of an existing network. Settings with any
+// other network id are kept, as they are for containers attached to a network
+// that has since been removed.
+func (f *FakeEngine) AddContainer(c types.Container) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if c.ID == "" {
+		c.ID = f.newID("container")
+	}
+	if c.NetworkSettings == nil {
+		c.NetworkSettings = &types.SummaryNetworkSettings{}
+	}
+	if c.NetworkSettings.Networks == nil {
+		c.NetworkSettings.Networks = make(map[string]*network.EndpointSettings)
+	}
+	f.containers[c.ID] = &c
+
+	for _, settings := range c.NetworkSettings.Networks {
+		if resource, ok := f.networks[settings.NetworkID]; ok {
+			resource.Containers[c.ID] = types.EndpointResource{
+				Name:        ContainerName(&c),
+				EndpointID:  settings.EndpointID,
+				IPv4Address: settings.IPAddress,
+			}
+		}
+	}
+	return c.ID
+}
+
+// Container returns the container with the specified id.
+func (f *FakeEngine) Container(id string) (types.Container, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.containers[id]
+	if !ok {
+		return types.Container{}, false
+	}
+	return *c, true
+}
+
+// Network returns the network with the specified id or name.
+func (f *FakeEngine) Network(idOrName string) (types.NetworkResource, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resource := f.findNetwork(idOrName)
+	if resource == nil {
+		return types.NetworkResource{}, false
+	}
+	return *resource, true
+}
+
+// NetworkCreate implements the Engine interface.
+func (f *FakeEngine) NetworkCreate(name string, opts types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.findNetwork(name) != nil {
+		return types.NetworkCreateResponse{}, errors.Errorf("network with name %s already exists", name)
+	}
+	resource := &types.NetworkResource{
+		Name:       name,
+		ID:         f.newID("network"),
+		Driver:     opts.Driver,
+		EnableIPv6: opts.EnableIPv6,
+		Internal:   opts.Internal,
+		Attachable: opts.Attachable,
+		Options:    opts.Options,
+		Labels:     opts.Labels,
+		Containers: make(map[string]types.EndpointResource),
+		IPAM:       network.IPAM{Driver: "default"},
+	}
+	if resource.Driver == "" {
+		resource.Driver = "bridge"
+	}
+	if opts.IPAM != nil {
+		if opts.IPAM.Driver != "" {
+			resource.IPAM.Driver = opts.IPAM.Driver
+		}
+		resource.IPAM.Options = opts.IPAM.Options
+		resource.IPAM.Config = append([]network.IPAMConfig{}, opts.IPAM.Config...)
+	}
+	f.networks[resource.ID] = resource
+	return types.NetworkCreateResponse{ID: resource.ID}, nil
+}
+
+// NetworkList implements the Engine interface.
+func (f *FakeEngine) NetworkList() ([]types.NetworkResource, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	networks := []types.NetworkResource{}
+	for _, resource := range f.networks {
+		networks = append(networks, *resource)
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+	return networks, nil
+}
+
+// NetworkInspect implements the Engine interface.
+func (f *FakeEngine) NetworkInspect(idOrName string) (types.NetworkResource, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resource := f.findNetwork(idOrName)
+	if resource == nil {
+		return types.NetworkResource{}, errors.Errorf("network %s not found", idOrName)
+	}
+	return *resource, nil
+}
+
+// inSubnets reports whether the address is within one of the subnets of the
+// network, or whether the network has no subnets configured.
+func inSubnets(address string, resource *types.NetworkResource) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	if len(resource.IPAM.Config) == 0 {
+		return true
+	}
+	for _, config := range resource.IPAM.Config {
+		if _, subnet, err := net.ParseCIDR(config.Subnet); err == nil && subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// NetworkConnect implements the Engine interface.
+func (f *FakeEngine) NetworkConnect(idOrName, containerID string, settings *network.EndpointSettings) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	resource := f.findNetwork(idOrName)
+	if resource == nil {
+		return errors.Errorf("network %s not found", idOrName)
+	}
+	c, ok := f.containers[containerID]
+	if !ok {
+		return errors.Errorf("no such container: %s", containerID)
+	}
+	if current, ok := c.NetworkSettings.Networks[resource.Name]; ok && current.NetworkID == resource.ID {
+		return errors.Errorf("container %s is already attached to network %s", containerID, resource.Name)
+	}
+
+	endpoint := &network.EndpointSettings{}
+	if settings != nil {
+		copied := *settings
+		endpoint = &copied
+	}
+	if endpoint.IPAMConfig != nil && endpoint.IPAMConfig.IPv4Address != "" {
+		if !inSubnets(endpoint.IPAMConfig.IPv4Address, resource) {
+			return errors.Errorf("invalid address %s: it does not belong to any of the network's subnets",
+				endpoint.IPAMConfig.IPv4Address)
+		}
+		endpoint.IPAddress = endpoint.IPAMConfig.IPv4Address
+	}
+	endpoint.NetworkID = resource.ID
+	endpoint.EndpointID = f.newID("endpoint")
+	c.NetworkSettings.Networks[resource.Name] = endpoint
+	resource.Containers[containerID] = types.EndpointResource{
+		Name:        ContainerName(c),
+		EndpointID:  endpoint.EndpointID,
+		IPv4Address: endpoint.IPAddress,
+	}
+	return nil
+}
+
+// NetworkDisconnect implements the Engine interface. As with docker, a
+// container attached to a network that no longer exists is disconnected from
+// it by the name of the network.
+func (f *FakeEngine) NetworkDisconnect(idOrName, containerID string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.containers[containerID]
+	if !ok {
+		return errors.Errorf("no such container: %s", containerID)
+	}
+	name := idOrName
+	if resource := f.findNetwork(idOrName); resource != nil {
+		name = resource.Name
+		delete(resource.Containers, containerID)
+	}
+	if _, ok := c.NetworkSettings.Networks[name]; !ok {
+		return errors.Errorf("container %s is not connected to network %s", containerID, idOrName)
+	}
+	delete(c.NetworkSettings.Networks, name)
+	return nil
+}
+
+// NetworkRemove implements the Engine interface.
+func (f *FakeEngine) NetworkRemove(idOrName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	resource := f.findNetwork(idOrName)
+	if resource == nil {
+		return errors.Errorf("network %s not found", idOrName)
+	}
+	if len(resource.Containers) > 0 {
+		return errors.Errorf("network %s has active endpoints", resource.Name)
+	}
+	delete(f.networks, resource.ID)
+	return nil
+}
+
+// ContainerList implements the Engine interface.
+func (f *FakeEngine) ContainerList() ([]types.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	containers := []types.Container{}
+	for _, c := range f.containers {
+		containers = append(containers, *c)
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].ID < containers[j].ID })
+	return containers, nil
+}
+
+// ContainerInspect implements the Engine interface.
+func (f *FakeEngine) ContainerInspect(containerID string) (types.ContainerJSON, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.containers[containerID]
+	if !ok {
+		return types.ContainerJSON{}, errors.Errorf("no such container: %s", containerID)
+	}
+	networks := make(map[string]*network.EndpointSettings, len(c.NetworkSettings.Networks))
+	for name, settings := range c.NetworkSettings.Networks {
+		networks[name] = settings
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    c.ID,
+			Name:  "/" + ContainerName(c),
+			Image: c.ImageID,
+			State: &types.ContainerState{
+				Status:  c.State,
+				Running: c.State == "running",
+			},
+		},
+		Config: &container.Config{
+			Image:  c.Image,
+			Labels: c.Labels,
+			Tty:    true,
+		},
+		NetworkSettings: &types.NetworkSettings{Networks: networks},
+	}, nil
+}
+
+// ContainerRemove implements the Engine interface.
+func (f *FakeEngine) ContainerRemove(containerID string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.containers[containerID]
+	if !ok {
+		return errors.Errorf("no such container: %s", containerID)
+	}
+	if c.State == "running" && !force {
+		return errors.Errorf("cannot remove running container %s, stop it first or use force", containerID)
+	}
+	for _, settings := range c.NetworkSettings.Networks {
+		if resource, ok := f.networks[settings.NetworkID]; ok {
+			delete(resource.Containers, containerID)
+		}
+	}
+	delete(f.containers, containerID)
+	return nil
+}
+
+// ContainerLogs implements the Engine interface.
+func (f *FakeEngine) ContainerLogs(ctx context.Context, containerID string, opts types.ContainerLogsOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.containers[containerID]; !ok {
+		return nil, errors.Errorf("no such container: %s", containerID)
+	}
+	return ioutil.NopCloser(strings.NewReader(f.Logs[containerID])), nil
+}
+
+// ContainerExec implements the Engine interface. The container must be
+// running and is found by id or name.
+func (f *FakeEngine) ContainerExec(ctx context.Context, containerID string, cmd []string, stdout, stderr io.Writer) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var container *types.Container
+	for id, c := range f.containers {
+		if id == containerID || ContainerName(c) == containerID {
+			container = c
+		}
+	}
+	if container == nil {
+		return 0, errors.Errorf("no such container: %s", containerID)
+	}
+	if container.State != "running" {
+		return 0, errors.Errorf("container %s is not running", containerID)
+	}
+	f.Execs = append(f.Execs, FakeExec{ContainerID: container.ID, Cmd: cmd})
+	result := f.ExecResults[strings.Join(cmd, " ")]
+	if _, err := io.WriteString(stdout, result.Stdout); err != nil {
+		return 0, err
+	}
+	if _, err := io.WriteString(stderr, result.Stderr); err != nil {
+		return 0, err
+	}
+	return result.ExitCode, nil
+}
+
+// ImageList implements the Engine interface.
+func (f *FakeEngine) ImageList() ([]types.ImageSummary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]types.ImageSummary{}, f.Images...), nil
+}
+
+// ImageInspect implements the Engine interface.
+func (f *FakeEngine) ImageInspect(imageID string) (types.ImageInspect, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, ok := f.ImageDetails[imageID]
+	if !ok {
+		return types.ImageInspect{}, errors.Errorf("no such image: %s", imageID)
+	}
+	return info, nil
+}
+
+// ImageSave implements the Engine interface. The archive of the fake is the
+// details of the images encoded as JSON, as read by ImageLoad.
+func (f *FakeEngine) ImageSave(ctx context.Context, images []string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	saved := []types.ImageInspect{}
+	for _, image := range images {
+		info, ok := f.ImageDetails[image]
+		if !ok {
+			return nil, errors.Errorf("no such image: %s", image)
+		}
+		saved = append(saved, info)
+	}
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// ImageLoad implements the Engine interface. The images of an archive written
+// by ImageSave are added by id and by each of their tags.
+func (f *FakeEngine) ImageLoad(ctx context.Context, archive io.Reader) error {
+	loaded := []types.ImageInspect{}
+	if err := json.NewDecoder(archive).Decode(&loaded); err != nil {
+		return errors.Wrap(err, "invalid archive")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, info := range loaded {
+		f.ImageDetails[info.ID] = info
+		for _, tag := range info.RepoTags {
+			f.ImageDetails[tag] = info
+		}
+	}
+	return nil
+}
+
+// ImageTag implements the Engine interface.
+func (f *FakeEngine) ImageTag(image, target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, ok := f.ImageDetails[image]
+	if !ok {
+		return errors.Errorf("no such image: %s", image)
+	}
+	info.RepoTags = append(append([]string{}, info.RepoTags...), target)
+	f.ImageDetails[target] = info
+	if info.ID != "" {
+		f.ImageDetails[info.ID] = info
+	}
+	return nil
+}
+
+// ImageRemove implements the Engine interface. An image used by a container
+// is only removed with force, and never if the container is running.
+func (f *FakeEngine) ImageRemove(imageID string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, container := range f.containers {
+		if container.ImageID == imageID && (!force || container.State == "running") {
+			return errors.Errorf("image %s is being used by container %s", imageID, container.ID)
+		}
+	}
+	found := false
+	images := []types.ImageSummary{}
+	for _, image := range f.Images {
+		if image.ID == imageID {
+			found = true
+			continue
+		}
+		images = append(images, image)
+	}
+	f.Images = images
+	for ref, info := range f.ImageDetails {
+		if ref == imageID || info.ID == imageID {
+			found = true
+			delete(f.ImageDetails, ref)
+		}
+	}
+	if !found {
+		return errors.Errorf("no such image: %s", imageID)
+	}
+	return nil
+}
+
+// VolumeList implements the Engine interface.
+func (f *FakeEngine) VolumeList() ([]*types.Volume, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*types.Volume{}, f.Volumes...), nil
+}
+
+// VolumeRemove implements the Engine interface. A volume mounted by a
+// container is only removed with force, and never if the container is
+// running.
+func (f *FakeEngine) VolumeRemove(name string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, container := range f.containers {
+		for _, mount := range container.Mounts {
+			if mount.Name == name && (!force || container.State == "running") {
+				return errors.Errorf("volume %s is in use by container %s", name, container.ID)
+			}
+		}
+	}
+	for i, volume := range f.Volumes {
+		if volume.Name == name {
+			f.Volumes = append(f.Volumes[:i:i], f.Volumes[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Errorf("no such volume: %s", name)
+}
+
+// ServerVersion implements the Engine interface.
+func (f *FakeEngine) ServerVersion() (types.Version, error) {
+	return f.Version, nil
+}
+
+// Info implements the Engine interface.
+func (f *FakeEngine) Info() (types.Info, error) {
+	return f.SystemInfo, nil
+}
+
+// APIVersion implements the Engine interface.
+func (f *FakeEngine) APIVersion() (string, error) {
+	return f.Version.APIVersion, nil
+}
@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/pkg/errors"
+)
+
+// LogLine is a single line of output written by a container.
+type LogLine struct {
+	// Time at which the line was written by the container.
+	Time time.Time
+	// Text of the line without the timestamp and trailing newline.
+	Text string
+}
+
+// ContainerLogs reads the logs of the specified container, sending each line
+// to the lines channel as it is read. It returns when the log stream ends,
+// which for a followed stream is when the container stops or ctx is
+// cancelled. Timestamps are always requested from docker as they are used to
+// order lines from different containers.
+func ContainerLogs(ctx context.Context, e Engine, containerID string, opts types.ContainerLogsOptions,
+	lines chan<- LogLine) error {
+
+	info, err := e.ContainerInspect(containerID)
+	if err != nil {
+		return err
+	}
+
+	opts.ShowStdout = true
+	opts.ShowStderr = true
+	opts.Timestamps = true
+	rc, err := e.ContainerLogs(ctx, containerID, opts)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var reader io.Reader = rc
+	if info.Config == nil || !info.Config.Tty {
+		// without a tty docker multiplexes stdout and stderr into a
+		// single stream with a header preceding each frame
+		pr, pw := io.Pipe()
+		go func() {
+			_, err := stdcopy.StdCopy(pw, pw, rc)
+			pw.CloseWithError(err)
+		}()
+		reader = pr
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := parseLogLine(scanner.Text())
+		select {
+		case lines <- line:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return errors.Wrapf(err, "failed reading logs of container %s", containerID)
+	}
+	return nil
+}
+
+// parseLogLine splits the timestamp docker prepends to each line from the
+// text of the line.
+func parseLogLine(raw string) LogLine {
+	raw = strings.TrimRight(raw, "\r")
+	i := strings.Index(raw, " ")
+	if i < 0 {
+		i = len(raw)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, raw[:i])
+	if err != nil {
+		return LogLine{Text: raw}
+	}
+	if i < len(raw) {
+		i++
+	}
+	return LogLine{Time: ts, Text: raw[i:]}
+}
@@ -0,0 +1,29 @@
+package docker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		Raw      string
+		Time     time.Time
+		Expected string
+	}{
+		{"2019-06-01T10:20:30.123456789Z hello world", time.Date(2019, 6, 1, 10, 20, 30, 123456789, time.UTC), "hello world"},
+		{"2019-06-01T10:20:30Z ", time.Date(2019, 6, 1, 10, 20, 30, 0, time.UTC), ""},
+		{"2019-06-01T10:20:30Z\r", time.Date(2019, 6, 1, 10, 20, 30, 0, time.UTC), ""},
+		{"not a timestamp", time.Time{}, "not a timestamp"},
+	}
+
+	for _, test := range tests {
+		line := parseLogLine(test.Raw)
+		if !line.Time.Equal(test.Time) {
+			t.Errorf("Expected time of '%s' to be %s but got %s", test.Raw, test.Time, line.Time)
+		}
+		if line.Text != test.Expected {
+			t.Errorf("Expected text of '%s' to be '%s' but got '%s'", test.Raw, test.Expected, line.Text)
+		}
+	}
+}
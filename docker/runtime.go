@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// RuntimeDocker runs containers with docker and docker compose.
+	RuntimeDocker = "docker"
+	// RuntimePodman runs containers with podman and podman compose,
+	// typically rootless.
+	RuntimePodman = "podman"
+	// RuntimeNerdctl runs containers with containerd through nerdctl and
+	// nerdctl compose.
+	RuntimeNerdctl = "nerdctl"
+)
+
+// Runtime is a container runtime dev can drive: the command line tool it runs
+// compose and container commands with, and the daemon the Engine talks to.
+type Runtime struct {
+	// Name of the runtime, as used in the runtime setting.
+	Name string
+	// CLI is the command run for compose and container operations, i.e.,
+	// 'exec' and 'pull'.
+	CLI string
+	// Compatibility is whether the compose front-end of the runtime
+	// accepts the --compatibility flag.
+	Compatibility bool
+	// sockets returns the unix sockets of the docker compatible API of
+	// the runtime, in the order they are tried. None are returned if the
+	// runtime has no such API.
+	sockets func() []string
+	// authFile returns the file the runtime records the registries it has
+	// logged in to.
+	authFile func() string
+}
+
+var runtimes = map[string]*Runtime{
+	RuntimeDocker: {
+		Name:          RuntimeDocker,
+		CLI:           "docker",
+		Compatibility: true,
+		sockets:       func() []string { return []string{"/var/run/docker.sock"} },
+		authFile:      dockerAuthFile,
+	},
+	RuntimePodman: {
+		Name:     RuntimePodman,
+		CLI:      "podman",
+		sockets:  podmanSockets,
+		authFile: podmanAuthFile,
+	},
+	RuntimeNerdctl: {
+		Name:     RuntimeNerdctl,
+		CLI:      "nerdctl",
+		sockets:  func() []string { return nil },
+		authFile: dockerAuthFile,
+	},
+}
+
+var currentRuntime = runtimes[RuntimeDocker]
+
+// RuntimeNames returns the names of the supported runtimes in sorted order.
+func RuntimeNames() []string {
+	names := make([]string, 0, len(runtimes))
+	for name := range runtimes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupRuntime returns the runtime with the specified name.
+func LookupRuntime(name string) (*Runtime, error) {
+	runtime, ok := runtimes[name]
+	if !ok {
+		return nil, errors.Errorf("unknown runtime %s, must be one of %s", name,
+			strings.Join(RuntimeNames(), ", "))
+	}
+	return runtime, nil
+}
+
+// SetRuntime sets the runtime used for the rest of this run of dev. It must be
+// called before the first Engine operation is performed.
+func SetRuntime(runtime *Runtime) {
+	currentRuntime = runtime
+}
+
+// CurrentRuntime returns the runtime in use, docker unless set otherwise.
+func CurrentRuntime() *Runtime {
+	return currentRuntime
+}
+
+// ComposeArgs returns the arguments that run the compose front-end of the
+// runtime with the specified arguments.
+func (r *Runtime) ComposeArgs(args ...string) []string {
+	cmdLine := []string{"compose"}
+	if r.Compatibility {
+		cmdLine = append(cmdLine, "--compatibility")
+	}
+	return append(cmdLine, args...)
+}
+
+// ComposeInstalled checks that the cli of the runtime and its compose
+// front-end are installed, returning the reason they are not usable if not.
+func (r *Runtime) ComposeInstalled() error {
+	path, err := exec.LookPath(r.CLI)
+	if err != nil {
+		return errors.Errorf("%s is not installed", r.CLI)
+	}
+	if err := exec.Command(path, "compose", "version").Run(); err != nil {
+		return errors.Errorf("%s compose is not available: %s", r.CLI, err)
+	}
+	return nil
+}
+
+// Host returns the address of the docker compatible API of the runtime, the
+// first of its sockets that exists. An empty string is returned if none exist.
+func (r *Runtime) Host() string {
+	for _, socket := range r.sockets() {
+		if _, err := os.Stat(socket); err == nil {
+			return "unix://" + socket
+		}
+	}
+	return ""
+}
+
+// AuthFile returns the file in which the runtime records the credentials of
+// the registries it has logged in to.
+func (r *Runtime) AuthFile() string {
+	return r.authFile()
+}
+
+func dockerAuthFile() string {
+	dir := dockerConfigDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// podmanSockets returns the socket of the rootless podman service of the user
+// followed by that of the system wide service.
+func podmanSockets() []string {
+	sockets := []string{}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		sockets = append(sockets, filepath.Join(dir, "podman", "podman.sock"))
+	}
+	return append(sockets, "/run/podman/podman.sock")
+}
+
+func podmanAuthFile() string {
+	if file := os.Getenv("REGISTRY_AUTH_FILE"); file != "" {
+		return file
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "containers", "auth.json")
+	}
+	return dockerAuthFile()
+}
@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupRuntime(t *testing.T) {
+	for _, name := range []string{RuntimeDocker, RuntimePodman, RuntimeNerdctl} {
+		runtime, err := LookupRuntime(name)
+		if err != nil {
+			t.Errorf("Unexpected error looking up runtime %s: %s", name, err)
+			continue
+		}
+		if runtime.CLI != name {
+			t.Errorf("Expected the cli of runtime %s to be %s but got %s", name, name, runtime.CLI)
+		}
+	}
+
+	if _, err := LookupRuntime("rkt"); err == nil {
+		t.Error("Expected an error looking up an unknown runtime")
+	}
+}
+
+func TestRuntimeHost(t *testing.T) {
+	dir := t.TempDir()
+	setenv(t, "XDG_RUNTIME_DIR", dir)
+
+	podman, _ := LookupRuntime(RuntimePodman)
+	socket := filepath.Join(dir, "podman", "podman.sock")
+	if host := podman.Host(); host != "" && host != "unix:///run/podman/podman.sock" {
+		t.Errorf("Expected no rootless podman socket but got %s", host)
+	}
+	writeFile(t, socket, "")
+	if host := podman.Host(); host != "unix://"+socket {
+		t.Errorf("Expected host unix://%s but got %s", socket, host)
+	}
+
+	nerdctl, _ := LookupRuntime(RuntimeNerdctl)
+	if host := nerdctl.Host(); host != "" {
+		t.Errorf("Expected no docker compatible API for nerdctl but got %s", host)
+	}
+}
+
+func TestRuntimeAuthFile(t *testing.T) {
+	setenv(t, "DOCKER_CONFIG", "/home/test/.docker")
+	setenv(t, "XDG_RUNTIME_DIR", "/run/user/1000")
+	setenv(t, "REGISTRY_AUTH_FILE", "")
+
+	dockerRuntime, _ := LookupRuntime(RuntimeDocker)
+	if file := dockerRuntime.AuthFile(); file != "/home/test/.docker/config.json" {
+		t.Errorf("Expected docker auth file /home/test/.docker/config.json but got %s", file)
+	}
+	podman, _ := LookupRuntime(RuntimePodman)
+	if file := podman.AuthFile(); file != "/run/user/1000/containers/auth.json" {
+		t.Errorf("Expected podman auth file /run/user/1000/containers/auth.json but got %s", file)
+	}
+	setenv(t, "REGISTRY_AUTH_FILE", "/home/test/auth.json")
+	if file := podman.AuthFile(); file != "/home/test/auth.json" {
+		t.Errorf("Expected podman auth file /home/test/auth.json but got %s", file)
+	}
+}
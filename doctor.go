@@ -0,0 +1,354 @@
+package dev
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"github.com/wish/dev/registry"
+)
+
+const (
+	// CheckOK is the status of a diagnostic that found no problem.
+	CheckOK = "ok"
+	// CheckWarn is the status of a diagnostic that found a problem that
+	// may cause dev to misbehave.
+	CheckWarn = "warn"
+	// CheckFail is the status of a diagnostic that found a problem that
+	// prevents dev from functioning.
+	CheckFail = "fail"
+
+	// minimumComposeMajorVersion is the major version of docker compose
+	// required by dev.
+	minimumComposeMajorVersion = 2
+	// maximumClockSkew is the difference between the time on the host and
+	// the time of the docker daemon above which a warning is given.
+	maximumClockSkew = 30 * time.Second
+	// imageSizeWarning is the total size of the project images above
+	// which a warning is given.
+	imageSizeWarning = 20 * 1000 * 1000 * 1000
+)
+
+// CheckResult is the outcome of a single diagnostic performed by the doctor
+// command.
+type CheckResult struct {
+	Name    string
+	Status  string
+	Message string
+	// Remediation is what the user can do to resolve the problem found.
+	// It is not set for successful checks.
+	Remediation string
+}
+
+func checkOK(name, format string, args ...interface{}) *CheckResult {
+	return &CheckResult{Name: name, Status: CheckOK, Message: fmt.Sprintf(format, args...)}
+}
+
+func checkProblem(status, name, remediation, format string, args ...interface{}) *CheckResult {
+	return &CheckResult{Name: name, Status: status, Message: fmt.Sprintf(format, args...), Remediation: remediation}
+}
+
+// Diagnose checks the environment dev runs in and the dev configuration for
+// problems, returning the result of each check performed.
+func Diagnose(appConfig *c.Dev) []*CheckResult {
+	results := []*CheckResult{}
+	results = append(results, checkConfig(appConfig)...)
+	results = append(results, checkComposeFiles(appConfig)...)
+
+	daemon := checkDockerDaemon(appConfig)
+	results = append(results, daemon)
+	results = append(results, checkCompose())
+	if result := checkDobi(appConfig); result != nil {
+		results = append(results, result)
+	}
+	results = append(results, checkBuilders(appConfig)...)
+	results = append(results, checkRegistries(appConfig)...)
+
+	// the remaining checks need the docker daemon
+	if daemon.Status == CheckFail {
+		return results
+	}
+	results = append(results, checkSubnets(appConfig)...)
+	results = append(results, checkImageDiskUsage(appConfig))
+	results = append(results, checkClockSkew(appConfig))
+
+	return results
+}
+
+// PrintCheckResults writes the results of the checks with the remediation of
+// each problem found.
+func PrintCheckResults(out io.Writer, results []*CheckResult) {
+	for _, result := range results {
+		fmt.Fprintf(out, "[%-4s] %s: %s\n", strings.ToUpper(result.Status), result.Name, result.Message)
+		if result.Remediation != "" {
+			fmt.Fprintf(out, "       -> %s\n", result.Remediation)
+		}
+	}
+}
+
+func checkConfig(appConfig *c.Dev) []*CheckResult {
+	const name = "config"
+	if appConfig.Filename == "" {
+		return []*CheckResult{checkProblem(CheckWarn, name,
+			"Create a .dev.yaml in your project or set DEV_CONFIG to the path of one",
+			"no dev configuration file found")}
+	}
+
+	errs := c.Validate(appConfig)
+	if len(errs) == 0 {
+		return []*CheckResult{checkOK(name, "%s is valid", appConfig.Filename)}
+	}
+	results := []*CheckResult{}
+	for _, err := range errs {
+		results = append(results, checkProblem(CheckFail, name,
+			"Fix the configuration in "+appConfig.Filename, "%s", err))
+	}
+	return results
+}
+
+// checkComposeFiles cross-checks the docker-compose files of the projects with
+// the dev configuration.
+func checkComposeFiles(appConfig *c.Dev) []*CheckResult {
+	const name = "compose files"
+	if len(appConfig.Projects) == 0 {
+		return nil
+	}
+	problems := ValidateCompose(appConfig)
+	if len(problems) == 0 {
+		return []*CheckResult{checkOK(name, "the compose files of %d projects match the configuration",
+			len(appConfig.Projects))}
+	}
+	results := []*CheckResult{}
+	for _, problem := range problems {
+		status := CheckWarn
+		if problem.Fatal {
+			status = CheckFail
+		}
+		results = append(results, checkProblem(status, name+" of "+problem.Project, problem.Fix, "%s", problem))
+	}
+	return results
+}
+
+func checkDockerDaemon(appConfig *c.Dev) *CheckResult {
+	const name = "docker daemon"
+	version, err := appConfig.GetEngine().ServerVersion()
+	if err != nil {
+		remediation := "Start docker, or check DOCKER_HOST and that your user can access the docker socket"
+		switch docker.CurrentRuntime().Name {
+		case docker.RuntimePodman:
+			remediation = "Start the podman API service with 'systemctl --user start podman.socket', or set DOCKER_HOST"
+		case docker.RuntimeNerdctl:
+			remediation = "nerdctl has no docker compatible API, set DOCKER_HOST to the address of one"
+		}
+		return checkProblem(CheckFail, name, remediation, "unable to reach the docker daemon: %s", err)
+	}
+	clientVersion, err := appConfig.GetEngine().APIVersion()
+	if err != nil {
+		clientVersion = "unknown"
+	}
+	return checkOK(name, "version %s, API version %s, using API version %s", version.Version, version.APIVersion,
+		clientVersion)
+}
+
+// composeVersion returns the version reported by docker compose.
+func composeVersion() (string, error) {
+	out, err := exec.Command("docker", "compose", "version", "--short").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(out)), "v"), nil
+}
+
+func checkCompose() *CheckResult {
+	containerRuntime := docker.CurrentRuntime()
+	if containerRuntime.Name != docker.RuntimeDocker {
+		// the compose front-ends of the other runtimes do not report
+		// their versions consistently, so only check they run.
+		name := containerRuntime.CLI + " compose"
+		if err := containerRuntime.ComposeInstalled(); err != nil {
+			return checkProblem(CheckFail, name, "Install "+containerRuntime.CLI+" and its compose support",
+				"%s", err)
+		}
+		return checkOK(name, "available")
+	}
+
+	const name = "docker compose"
+	const remediation = "Install docker compose v2, see https://docs.docker.com/compose/install/"
+	version, err := composeVersion()
+	if err != nil {
+		return checkProblem(CheckFail, name, remediation, "docker compose is not available: %s", err)
+	}
+
+	var major int
+	if _, err := fmt.Sscanf(version, "%d", &major); err != nil || major < minimumComposeMajorVersion {
+		return checkProblem(CheckFail, name, remediation, "version %s found, version 2 or later required", version)
+	}
+	return checkOK(name, "version %s", version)
+}
+
+// checkDobi checks that dobi is installed when a project uses it. Nil is
+// returned when no dobi configuration exists.
+func checkDobi(appConfig *c.Dev) *CheckResult {
+	const name = "dobi"
+	dobiYamlFilename := filepath.Join(appConfig.Dir, "dobi.yaml")
+	if _, err := appConfig.GetFs().Stat(dobiYamlFilename); err != nil {
+		return nil
+	}
+	path, err := exec.LookPath("dobi")
+	if err != nil {
+		return checkProblem(CheckWarn, name,
+			"Install dobi from https://github.com/dnephin/dobi or builds will use docker compose",
+			"%s exists but dobi is not installed", dobiYamlFilename)
+	}
+	return checkOK(name, "using %s", path)
+}
+
+// checkBuilders checks that the tools of the builders configured for projects
+// are installed and that their configuration exists.
+func checkBuilders(appConfig *c.Dev) []*CheckResult {
+	results := []*CheckResult{}
+	for _, projectName := range appConfig.ProjectNames() {
+		builder := appConfig.Projects[projectName].Builder
+		if builder == nil {
+			continue
+		}
+		name := "builder of " + projectName
+		switch builder.Type {
+		case c.BuilderDobi:
+			if _, err := exec.LookPath("dobi"); err != nil {
+				results = append(results, checkProblem(CheckFail, name,
+					"Install dobi from https://github.com/dnephin/dobi", "dobi is not installed"))
+			} else if _, err := appConfig.GetFs().Stat(builder.File); err != nil {
+				results = append(results, checkProblem(CheckFail, name,
+					"Create it or set the file of the builder", "%s does not exist", builder.File))
+			} else {
+				results = append(results, checkOK(name, "dobi with %s", builder.File))
+			}
+		case c.BuilderBake:
+			cli := docker.CurrentRuntime().CLI
+			if err := exec.Command(cli, "buildx", "version").Run(); err != nil {
+				results = append(results, checkProblem(CheckFail, name,
+					"Install docker buildx, https://docs.docker.com/build/install-buildx/",
+					"%s buildx is not available: %s", cli, err))
+			} else {
+				results = append(results, checkOK(name, "%s buildx bake", cli))
+			}
+		default:
+			results = append(results, checkOK(name, "%s", builder.Type))
+		}
+	}
+	return results
+}
+
+func checkRegistries(appConfig *c.Dev) []*CheckResult {
+	results := []*CheckResult{}
+	for _, name := range appConfig.RegistryNames() {
+		reg := appConfig.Registries[name]
+		checkName := "registry " + name
+
+		// any response to the version check endpoint, including
+		// unauthorized, means the registry is reachable.
+		client := http.Client{Timeout: time.Duration(reg.TimeoutSeconds) * time.Second}
+		resp, err := client.Get(strings.TrimRight(reg.URL, "/") + "/v2/")
+		if err != nil {
+			results = append(results, checkProblem(CheckFail, checkName,
+				"Check your network connection and VPN, the registry may be firewalled",
+				"unable to reach %s: %s", reg.URL, err))
+			continue
+		}
+		resp.Body.Close()
+
+		loggedIn, err := registry.LoggedIn(reg.URL)
+		if err != nil {
+			results = append(results, checkProblem(CheckWarn, checkName,
+				"Check that your docker client configuration is valid JSON",
+				"unable to determine login state: %s", err))
+		} else if !loggedIn {
+			results = append(results, checkProblem(CheckWarn, checkName,
+				"Run 'dev <project> build' or 'docker login "+reg.URL+"'",
+				"reachable but not logged in"))
+		} else {
+			results = append(results, checkOK(checkName, "reachable and logged in"))
+		}
+	}
+	return results
+}
+
+func checkSubnets(appConfig *c.Dev) []*CheckResult {
+	const name = "subnets"
+	networks, err := appConfig.GetEngine().NetworkList()
+	if err != nil {
+		return []*CheckResult{checkProblem(CheckWarn, name, "Check that the docker daemon is healthy",
+			"unable to list docker networks: %s", err)}
+	}
+	routes, err := HostRoutes()
+	if err != nil {
+		return []*CheckResult{checkProblem(CheckWarn, name, "Check that the routing table is readable",
+			"unable to read host routes: %s", err)}
+	}
+
+	conflicts := FindSubnetConflicts(appConfig.NetworkNames(), appConfig.Networks, networks, routes)
+	if len(conflicts) == 0 {
+		return []*CheckResult{checkOK(name, "no conflicts between %d managed networks, docker networks and host routes",
+			len(appConfig.Networks))}
+	}
+	results := []*CheckResult{}
+	for _, conflict := range conflicts {
+		results = append(results, checkProblem(CheckFail, name,
+			"Change the subnet of "+conflict.Network+" in "+appConfig.Filename+" or remove the conflicting network",
+			"%s", conflict))
+	}
+	return results
+}
+
+func checkImageDiskUsage(appConfig *c.Dev) *CheckResult {
+	const name = "disk usage"
+	images, err := docker.ImageList(appConfig.GetEngine(), appConfig.ImagePrefix)
+	if err != nil {
+		return checkProblem(CheckWarn, name, "Check that the docker daemon is healthy",
+			"unable to list images: %s", err)
+	}
+
+	var size int64
+	for _, image := range images {
+		size += image.Size
+	}
+	if size > imageSizeWarning {
+		return checkProblem(CheckWarn, name, "Remove unused images with 'docker image prune'",
+			"%d images prefixed with %s use %s", len(images), appConfig.ImagePrefix, units.HumanSize(float64(size)))
+	}
+	return checkOK(name, "%d images prefixed with %s use %s", len(images), appConfig.ImagePrefix,
+		units.HumanSize(float64(size)))
+}
+
+func checkClockSkew(appConfig *c.Dev) *CheckResult {
+	const name = "clock"
+	info, err := appConfig.GetEngine().Info()
+	if err != nil {
+		return checkProblem(CheckWarn, name, "Check that the docker daemon is healthy",
+			"unable to retrieve the docker daemon time: %s", err)
+	}
+	daemonTime, err := time.Parse(time.RFC3339Nano, info.SystemTime)
+	if err != nil {
+		return checkProblem(CheckWarn, name, "Check that the docker daemon is healthy",
+			"unable to parse the docker daemon time %s", info.SystemTime)
+	}
+
+	skew := time.Since(daemonTime)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maximumClockSkew {
+		return checkProblem(CheckWarn, name,
+			"Restart docker (or its virtual machine) to resynchronize its clock, registry logins may fail",
+			"docker daemon clock differs from the host by %s", skew.Round(time.Second))
+	}
+	return checkOK(name, "docker daemon clock differs from the host by %s", skew.Round(time.Millisecond))
+}
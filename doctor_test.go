@@ -0,0 +1,135 @@
+package dev
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+)
+
+// unreachableEngine is an engine whose daemon cannot be reached.
+type unreachableEngine struct {
+	*docker.FakeEngine
+}
+
+func (e *unreachableEngine) ServerVersion() (types.Version, error) {
+	return types.Version{}, errors.New("connection refused")
+}
+
+func TestCheckConfig(t *testing.T) {
+	project := &c.Project{Name: "app", Directory: "/home/test",
+		DockerComposeFilenames: []string{"/home/test/docker-compose.yml"}, Dependencies: []string{"db"}}
+	appConfig, _ := testConfig(t, map[string]string{"/home/test/.dev.yaml": ""}, project)
+
+	results := checkConfig(appConfig)
+	if len(results) != 1 || results[0].Status != CheckWarn {
+		t.Errorf("Expected a warning without a configuration file, got %+v", results)
+	}
+
+	appConfig.Filename = "/home/test/.dev.yaml"
+	results = checkConfig(appConfig)
+	if len(results) != 2 {
+		t.Fatalf("Expected a failure for the missing compose file and the unknown dependency, got %+v", results)
+	}
+	for _, result := range results {
+		if result.Status != CheckFail || result.Remediation != "Fix the configuration in /home/test/.dev.yaml" {
+			t.Errorf("Expected a failure with a remediation, got %+v", result)
+		}
+	}
+
+	project.Dependencies = nil
+	writeFiles(t, appConfig.GetFs(), map[string]string{"/home/test/docker-compose.yml": "services:\n  app:\n    image: app\n"})
+	if results = checkConfig(appConfig); len(results) != 1 || results[0].Status != CheckOK {
+		t.Errorf("Expected the configuration to be valid, got %+v", results)
+	}
+}
+
+func TestCheckDobi(t *testing.T) {
+	appConfig, _ := testConfig(t, map[string]string{})
+	appConfig.Dir = "/home/test"
+	if result := checkDobi(appConfig); result != nil {
+		t.Errorf("Expected no check without a dobi configuration, got %+v", result)
+	}
+
+	// the configuration is looked up on the filesystem of the configuration
+	writeFiles(t, appConfig.GetFs(), map[string]string{"/home/test/dobi.yaml": "meta:\n  project: app\n"})
+	result := checkDobi(appConfig)
+	if result == nil || result.Name != "dobi" || result.Status == CheckFail {
+		t.Errorf("Expected dobi to be checked, got %+v", result)
+	}
+}
+
+func TestCheckDockerDaemon(t *testing.T) {
+	appConfig, engine := testConfig(t, map[string]string{})
+	engine.Version = types.Version{Version: "20.10.7", APIVersion: "1.41"}
+	result := checkDockerDaemon(appConfig)
+	if result.Status != CheckOK || result.Message != "version 20.10.7, API version 1.41, using API version 1.41" {
+		t.Errorf("Expected the daemon to be reachable, got %+v", result)
+	}
+
+	appConfig.SetEngine(&unreachableEngine{engine})
+	if result := checkDockerDaemon(appConfig); result.Status != CheckFail || result.Remediation == "" {
+		t.Errorf("Expected the daemon to be unreachable, got %+v", result)
+	}
+
+	// the checks that need the daemon are skipped
+	for _, result := range Diagnose(appConfig) {
+		if result.Name == "subnets" || result.Name == "disk usage" || result.Name == "clock" {
+			t.Errorf("Expected the %s check to be skipped without a daemon", result.Name)
+		}
+	}
+}
+
+func TestCheckImageDiskUsage(t *testing.T) {
+	appConfig, engine := testConfig(t, map[string]string{})
+	engine.Images = []types.ImageSummary{
+		{ID: "sha256:1", RepoTags: []string{"dev_app:latest"}, Size: 1000 * 1000},
+		{ID: "sha256:2", RepoTags: []string{"postgres:12"}, Size: 300 * 1000 * 1000},
+	}
+	result := checkImageDiskUsage(appConfig)
+	if result.Status != CheckOK || result.Message != "1 images prefixed with dev use 1MB" {
+		t.Errorf("Expected the size of the prefixed images, got %+v", result)
+	}
+
+	engine.Images[0].Size = imageSizeWarning + 1
+	if result := checkImageDiskUsage(appConfig); result.Status != CheckWarn {
+		t.Errorf("Expected a warning for images above %d bytes, got %+v", imageSizeWarning, result)
+	}
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	appConfig, engine := testConfig(t, map[string]string{})
+	tests := []struct {
+		SystemTime string
+		Status     string
+	}{
+		{time.Now().Format(time.RFC3339Nano), CheckOK},
+		{time.Now().Add(-time.Hour).Format(time.RFC3339Nano), CheckWarn},
+		{time.Now().Add(time.Hour).Format(time.RFC3339Nano), CheckWarn},
+		{"yesterday", CheckWarn},
+	}
+	for _, test := range tests {
+		engine.SystemInfo.SystemTime = test.SystemTime
+		if result := checkClockSkew(appConfig); result.Status != test.Status {
+			t.Errorf("Expected %s for the daemon time %s, got %+v", test.Status, test.SystemTime, result)
+		}
+	}
+}
+
+func TestPrintCheckResults(t *testing.T) {
+	var out bytes.Buffer
+	PrintCheckResults(&out, []*CheckResult{
+		checkOK("config", "%s is valid", ".dev.yaml"),
+		checkProblem(CheckFail, "docker daemon", "Start docker", "unable to reach the docker daemon"),
+	})
+	expected := "[OK  ] config: .dev.yaml is valid\n" +
+		"[FAIL] docker daemon: unable to reach the docker daemon\n" +
+		"       -> Start docker\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,304 @@
+package dev
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	c "github.com/wish/dev/config"
+)
+
+const (
+	// downloadConcurrency is the number of images pulled at once.
+	downloadConcurrency = 4
+	// maxTagLength is the longest tag docker accepts.
+	maxTagLength = 128
+)
+
+// invalidTagChars matches the characters not allowed in an image tag.
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// DownloadImage is the image of a service downloaded from a registry.
+type DownloadImage struct {
+	Service  string
+	Registry *c.Registry
+	// Remote is the image pulled from the registry.
+	Remote string
+	// Digest is the digest the image is pinned to by the lock file, if
+	// it is.
+	Digest string
+	// Local are the names the image is tagged with so that compose uses
+	// it rather than building the service.
+	Local []string
+	// Err is why the image could not be downloaded, set by RunDownload.
+	Err error
+}
+
+// Reference returns the reference the image is pulled by, pinned to its
+// digest if locked.
+func (d *DownloadImage) Reference() string {
+	if d.Digest == "" {
+		return d.Remote
+	}
+	return imageRepository(d.Remote) + "@" + d.Digest
+}
+
+// DownloadTagData is the data the tag templates of downloads are rendered
+// with.
+type DownloadTagData struct {
+	Service string
+	// Branch is the git branch checked out, with the characters not
+	// allowed in a tag replaced by '-'.
+	Branch   string
+	SHA      string
+	ShortSHA string
+}
+
+// gitRevision returns the branch and commit checked out in the directory.
+func gitRevision(dir string) (string, string, error) {
+	branch, err := runCommandOutput(dir, "git", "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to read the git branch of %s", dir)
+	}
+	sha, err := runCommandOutput(dir, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to read the git commit of %s", dir)
+	}
+	return strings.TrimSpace(branch), strings.TrimSpace(sha), nil
+}
+
+// sanitizeTag replaces the characters that are not allowed in an image tag,
+// i.e., the '/' of branch names, and shortens it to the length allowed.
+func sanitizeTag(tag string) string {
+	tag = invalidTagChars.ReplaceAllString(tag, "-")
+	if len(tag) > maxTagLength {
+		tag = tag[:maxTagLength]
+	}
+	return tag
+}
+
+// downloadRegistry returns the registry to download from: the one named or,
+// if none is, the only registry with a download_path.
+func downloadRegistry(appConfig *c.Dev, name string) (*c.Registry, error) {
+	if name != "" {
+		registry, ok := appConfig.Registries[name]
+		if !ok {
+			return nil, errors.Errorf("registry %s is not configured", name)
+		}
+		return registry, nil
+	}
+
+	candidates := []string{}
+	for _, name := range appConfig.RegistryNames() {
+		if appConfig.Registries[name].DownloadPath != "" {
+			candidates = append(candidates, name)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return nil, errors.New("no registry has a download_path, set the registry of the project's download")
+	case 1:
+		return appConfig.Registries[candidates[0]], nil
+	}
+	return nil, errors.Errorf("registries %s have a download_path, set the registry of the project's download",
+		strings.Join(candidates, ", "))
+}
+
+// registryImageHost returns the host of the registry as used in image names.
+func registryImageHost(registry *c.Registry) string {
+	if u, err := url.Parse(registry.URL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return strings.TrimRight(registry.URL, "/")
+}
+
+// PlanDownload returns the images to download for the selected services of the
+// project built from a Dockerfile, or for all of them if none are selected.
+// Images of other services are pulled by compose. Images locked in the lock
+// file are pinned to their digest.
+func PlanDownload(appConfig *c.Dev, project *c.Project, selected []string) ([]*DownloadImage, error) {
+	lock, err := LoadLock(appConfig)
+	if err != nil {
+		return nil, err
+	}
+	return planDownload(appConfig, project, selected, lock.Project(project.Name))
+}
+
+func planDownload(appConfig *c.Dev, project *c.Project, selected []string, lock *ProjectLock) ([]*DownloadImage, error) {
+	services, err := CreateBuildServices(appConfig, project)
+	if err != nil {
+		return nil, err
+	}
+	serviceMap := make(map[string]*BuildService)
+	for _, service := range services {
+		serviceMap[service.Name] = service
+	}
+	if len(selected) == 0 {
+		for _, service := range services {
+			selected = append(selected, service.Name)
+		}
+	}
+
+	// the git revision is only read when a tag needs it
+	var data *DownloadTagData
+	tagData := func() (*DownloadTagData, error) {
+		if data == nil {
+			branch, sha, err := gitRevision(project.Directory)
+			if err != nil {
+				return nil, err
+			}
+			data = &DownloadTagData{Branch: sanitizeTag(branch), SHA: sha, ShortSHA: sha}
+			if len(sha) > 12 {
+				data.ShortSHA = sha[:12]
+			}
+		}
+		return data, nil
+	}
+
+	images := []*DownloadImage{}
+	for _, name := range selected {
+		service, ok := serviceMap[name]
+		if !ok {
+			return nil, errors.Errorf("%s is not a service built from a Dockerfile", name)
+		}
+		source := project.Download.Source(name)
+		registry, err := downloadRegistry(appConfig, source.Registry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot download %s", name)
+		}
+
+		tag := source.Tag
+		if strings.Contains(tag, "{{") {
+			tmpl, err := template.New(name).Option("missingkey=error").Parse(tag)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid download tag of %s", name)
+			}
+			serviceData := DownloadTagData{}
+			if strings.Contains(tag, "Branch") || strings.Contains(tag, "SHA") {
+				revision, err := tagData()
+				if err != nil {
+					return nil, err
+				}
+				serviceData = *revision
+			}
+			serviceData.Service = name
+			var rendered bytes.Buffer
+			if err := tmpl.Execute(&rendered, &serviceData); err != nil {
+				return nil, errors.Wrapf(err, "failed to render the download tag of %s", name)
+			}
+			tag = sanitizeTag(rendered.String())
+		}
+
+		repository := source.Repository
+		if repository == "" {
+			repository = registry.DownloadPath
+		}
+		image := &DownloadImage{
+			Service:  name,
+			Registry: registry,
+			Remote:   path.Join(registryImageHost(registry), repository, source.Image) + ":" + tag,
+			Local:    serviceImageNames(appConfig.ImagePrefix, service),
+		}
+		if lock != nil {
+			if locked := pinned(lock.Downloads, project.Name, name, image.Remote); locked != nil {
+				image.Digest = locked.Digest
+			}
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// pullImage pulls the image and tags it with its local names, then runs the
+// post download command of the registry once, with the first local name.
+func pullImage(appConfig *c.Dev, image *DownloadImage) error {
+	ref := image.Reference()
+	if err := RunDockerPull(ref); err != nil {
+		return errors.Wrapf(err, "failed to pull %s", ref)
+	}
+	for _, local := range image.Local {
+		if err := RunDockerTag(ref, local); err != nil {
+			return errors.Wrapf(err, "failed to tag %s as %s", ref, local)
+		}
+	}
+	if cmd := image.Registry.PostDownloadCommand; cmd != "" {
+		if err := RunCommandInDir(appConfig.Dir, cmd, []string{image.Local[0]}); err != nil {
+			return errors.Wrapf(err, "post download command failed for %s", image.Local[0])
+		}
+	}
+	return nil
+}
+
+// RunDownload downloads the images in parallel with the pull function, or
+// pulls them with the container runtime if it is nil, logging the progress.
+// The error of each image that could not be downloaded is set.
+func RunDownload(appConfig *c.Dev, images []*DownloadImage, pull func(image *DownloadImage) error) {
+	if pull == nil {
+		pull = func(image *DownloadImage) error {
+			return pullImage(appConfig, image)
+		}
+	}
+
+	var mu sync.Mutex
+	done := 0
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, downloadConcurrency)
+	for _, image := range images {
+		wg.Add(1)
+		go func(image *DownloadImage) {
+			defer wg.Done()
+			limit <- struct{}{}
+			image.Err = pull(image)
+			<-limit
+
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			if image.Err != nil {
+				log.Warnf("[%d/%d] %s: %s", done, len(images), image.Service, image.Err)
+			} else {
+				log.Infof("[%d/%d] %s: downloaded %s", done, len(images), image.Service, image.Reference())
+			}
+		}(image)
+	}
+	wg.Wait()
+}
+
+// FailedDownloads returns the names of the services whose image could not be
+// downloaded.
+func FailedDownloads(images []*DownloadImage) []string {
+	services := []string{}
+	for _, image := range images {
+		if image.Err != nil {
+			services = append(services, image.Service)
+		}
+	}
+	return services
+}
+
+// PrintDownloadSummary writes the outcome of the download of each image. The
+// services built locally instead are reported as such.
+func PrintDownloadSummary(out io.Writer, images []*DownloadImage, built []string) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tIMAGE\tRESULT")
+	for _, image := range images {
+		result := "downloaded"
+		if image.Err != nil {
+			result = "failed"
+			if SliceContainsString(built, image.Service) {
+				result = "built locally"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", image.Service, image.Reference(), result)
+	}
+	w.Flush()
+}
@@ -0,0 +1,185 @@
+package dev
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+)
+
+// downloadTestConfig returns a configuration with the ecr registry images are
+// downloaded from, the hub registry and the app project, of which the app and
+// worker services are built.
+func downloadTestConfig(t *testing.T) (*c.Dev, *c.Project, *docker.FakeEngine) {
+	project := &c.Project{
+		Name:                   "app",
+		Directory:              "/home/test",
+		DockerComposeFilenames: []string{"/home/test/docker-compose.yml"},
+	}
+	appConfig, engine := testConfig(t, map[string]string{
+		"/home/test/docker-compose.yml": `version: "3.6"
+services:
+  app:
+    build: ./app
+  worker:
+    build: ./worker
+    image: dev/worker
+  db:
+    image: postgres
+`,
+		"/home/test/app/Dockerfile":    "FROM alpine\n",
+		"/home/test/worker/Dockerfile": "FROM alpine\n",
+	}, project)
+	appConfig.Registries["ecr"] = &c.Registry{Name: "ecr", URL: "https://ecr.example.com", DownloadPath: "dev/images"}
+	appConfig.Registries["hub"] = &c.Registry{Name: "hub", URL: "https://hub.example.com"}
+	return appConfig, project, engine
+}
+
+func TestPlanDownload(t *testing.T) {
+	appConfig, project, _ := downloadTestConfig(t)
+	defer setExecutor(nil)
+	setup()
+	tc.Outputs = map[string]string{
+		"git rev-parse --abbrev-ref HEAD": "feature/login\n",
+		"git rev-parse HEAD":              "0123456789abcdef0123456789abcdef01234567\n",
+	}
+	setExecutor(tc.NewCommand)
+
+	tests := []struct {
+		Name     string
+		Download *c.Download
+		Expected map[string]string
+	}{
+		{
+			"default",
+			nil,
+			map[string]string{
+				"app":    "ecr.example.com/dev/images/app:current",
+				"worker": "ecr.example.com/dev/images/worker:current",
+			},
+		},
+		{
+			"mapped",
+			&c.Download{
+				Tag: "{{.Service}}-stable",
+				Services: map[string]*c.DownloadSource{
+					"worker": {Registry: "hub", Repository: "tools", Image: "queue-worker", Tag: "v2"},
+				},
+			},
+			map[string]string{
+				"app":    "ecr.example.com/dev/images/app:app-stable",
+				"worker": "hub.example.com/tools/queue-worker:v2",
+			},
+		},
+		{
+			"revision",
+			&c.Download{Tag: "{{.Branch}}-{{.ShortSHA}}"},
+			map[string]string{
+				"app":    "ecr.example.com/dev/images/app:feature-login-0123456789ab",
+				"worker": "ecr.example.com/dev/images/worker:feature-login-0123456789ab",
+			},
+		},
+	}
+	for _, test := range tests {
+		project.Download = test.Download
+		images, err := PlanDownload(appConfig, project, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.Name, err)
+			continue
+		}
+		got := map[string]string{}
+		for _, image := range images {
+			got[image.Service] = image.Remote
+		}
+		if !reflect.DeepEqual(got, test.Expected) {
+			t.Errorf("%s: expected images %v but got %v", test.Name, test.Expected, got)
+		}
+	}
+
+	project.Download = nil
+	images, err := PlanDownload(appConfig, project, []string{"worker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || !reflect.DeepEqual(images[0].Local, []string{"dev/worker"}) {
+		t.Errorf("Expected the worker image to be tagged dev/worker but got %+v", images)
+	}
+	if _, err := PlanDownload(appConfig, project, []string{"db"}); err == nil {
+		t.Errorf("Expected an error downloading a service without a Dockerfile")
+	}
+
+	appConfig.Registries["hub"].DownloadPath = "images"
+	if _, err := PlanDownload(appConfig, project, nil); err == nil {
+		t.Errorf("Expected an error when more than one registry has a download_path")
+	}
+}
+
+func TestSanitizeTag(t *testing.T) {
+	if got := sanitizeTag("feature/login+sso"); got != "feature-login-sso" {
+		t.Errorf("Expected feature-login-sso but got %s", got)
+	}
+	if got := sanitizeTag(strings.Repeat("a", 200)); len(got) != maxTagLength {
+		t.Errorf("Expected the tag to be shortened to %d characters but got %d", maxTagLength, len(got))
+	}
+}
+
+func TestPullImage(t *testing.T) {
+	defer setExecutor(nil)
+	setup()
+	setExecutor(tc.NewCommand)
+
+	appConfig, _, _ := downloadTestConfig(t)
+	registry := &c.Registry{Name: "ecr", URL: "https://ecr.example.com", PostDownloadCommand: "./scripts/verify"}
+	image := &DownloadImage{Service: "app", Registry: registry, Remote: "ecr.example.com/dev/images/app:current",
+		Local: []string{"dev-app", "dev_app"}}
+	if err := pullImage(appConfig, image); err != nil {
+		t.Fatal(err)
+	}
+	// the post download command runs once, however many names the image has
+	expected := []string{
+		"docker pull --quiet ecr.example.com/dev/images/app:current",
+		"docker tag ecr.example.com/dev/images/app:current dev-app",
+		"docker tag ecr.example.com/dev/images/app:current dev_app",
+		"./scripts/verify dev-app",
+	}
+	if !reflect.DeepEqual(tc.Commands, expected) {
+		t.Errorf("Expected commands %v but got %v", expected, tc.Commands)
+	}
+}
+
+func TestRunDownload(t *testing.T) {
+	appConfig, project, _ := downloadTestConfig(t)
+	images, err := PlanDownload(appConfig, project, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RunDownload(appConfig, images, func(image *DownloadImage) error {
+		if image.Service == "worker" {
+			return errors.New("manifest unknown")
+		}
+		return nil
+	})
+	if failed := FailedDownloads(images); !reflect.DeepEqual(failed, []string{"worker"}) {
+		t.Errorf("Expected the worker download to fail but got %v", failed)
+	}
+
+	var out bytes.Buffer
+	PrintDownloadSummary(&out, images, []string{"worker"})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := [][]string{
+		{"SERVICE", "IMAGE", "RESULT"},
+		{"app", "ecr.example.com/dev/images/app:current", "downloaded"},
+		{"worker", "ecr.example.com/dev/images/worker:current", "built", "locally"},
+	}
+	for i, line := range lines {
+		if i >= len(expected) || !reflect.DeepEqual(strings.Fields(line), expected[i]) {
+			t.Errorf("Unexpected summary:\n%s", out.String())
+			break
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package dev
+
+import (
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+	"github.com/wish/dev/compose"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/state"
+	"gopkg.in/yaml.v2"
+)
+
+// composeSections are the top-level keys of the exported compose file that
+// are merged across projects.
+var composeSections = []string{"services", "networks", "volumes", "secrets", "configs"}
+
+// ExportCompose returns a compose file with the effective configuration of
+// the projects, which compose can run without dev. The docker-compose files
+// of each project are merged with the images pinned by the lock file, as they
+// are written rather than as dev loads them, so that no setting is lost, and
+// relative paths are made absolute. Later projects override the services,
+// networks and volumes of earlier ones with the same name, as they do when
+// dev brings them up. The compose project is named after the image prefix
+// and the external networks managed by dev are defined in the file, so
+// compose creates them.
+func ExportCompose(appConfig *c.Dev, projects []*c.Project) ([]byte, error) {
+	localState, err := state.Load(appConfig.GetFs(), appConfig.ImagePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sections := make(map[string]map[string]interface{})
+	for _, section := range composeSections {
+		sections[section] = make(map[string]interface{})
+	}
+	for _, project := range projects {
+		// the images are pinned by digest, which the loaded bundles of
+		// this host do not have
+		files := lockedComposeFiles(appConfig, project, nil)
+		if len(files) == 0 {
+			continue
+		}
+		dict, err := compose.Merge(appConfig.GetFs(), filepath.Dir(files[0]), files)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse the docker-compose files of %s", project.Name)
+		}
+		for _, section := range composeSections {
+			values, _ := dict[section].(map[string]interface{})
+			for name, value := range values {
+				sections[section][name] = value
+			}
+		}
+	}
+
+	for key, value := range sections["networks"] {
+		network, _ := value.(map[string]interface{})
+		name := key
+		switch external := network["external"].(type) {
+		case bool:
+			if !external {
+				continue
+			}
+			if networkName, ok := network["name"].(string); ok {
+				name = networkName
+			}
+		case map[string]interface{}:
+			if externalName, ok := external["name"].(string); ok {
+				name = externalName
+			}
+		default:
+			continue
+		}
+		if config, ok := appConfig.Networks[name]; ok {
+			sections["networks"][key] = networkDefinition(appConfig.NetworkName(name), config,
+				localState.Subnets[name])
+		}
+	}
+
+	exported := yaml.MapSlice{{Key: "name", Value: appConfig.ImagePrefix}}
+	for _, section := range composeSections {
+		if section == "services" || len(sections[section]) > 0 {
+			exported = append(exported, yaml.MapItem{Key: section, Value: sections[section]})
+		}
+	}
+	return yaml.Marshal(exported)
+}
+
+// networkDefinition returns the compose definition of the network managed by
+// dev. An automatic subnet is replaced with the subnet recorded for the
+// network, or left to docker when none is.
+func networkDefinition(name string, config *types.NetworkCreate, subnet string) map[string]interface{} {
+	network := map[string]interface{}{"name": name}
+	if config == nil {
+		return network
+	}
+	if config.Driver != "" {
+		network["driver"] = config.Driver
+	}
+	if len(config.Options) > 0 {
+		network["driver_opts"] = config.Options
+	}
+	if len(config.Labels) > 0 {
+		network["labels"] = config.Labels
+	}
+	if config.Internal {
+		network["internal"] = true
+	}
+	if config.Attachable {
+		network["attachable"] = true
+	}
+	if config.EnableIPv6 {
+		network["enable_ipv6"] = true
+	}
+	if config.IPAM == nil {
+		return network
+	}
+
+	ipam := map[string]interface{}{}
+	if config.IPAM.Driver != "" {
+		ipam["driver"] = config.IPAM.Driver
+	}
+	if len(config.IPAM.Options) > 0 {
+		ipam["options"] = config.IPAM.Options
+	}
+	pools := []interface{}{}
+	for _, ipamConfig := range config.IPAM.Config {
+		pool := map[string]interface{}{}
+		switch {
+		case ipamConfig.Subnet != c.SubnetAuto:
+			pool["subnet"] = ipamConfig.Subnet
+		case subnet != "":
+			pool["subnet"] = subnet
+		}
+		if ipamConfig.IPRange != "" {
+			pool["ip_range"] = ipamConfig.IPRange
+		}
+		if ipamConfig.Gateway != "" {
+			pool["gateway"] = ipamConfig.Gateway
+		}
+		if len(ipamConfig.AuxAddress) > 0 {
+			pool["aux_addresses"] = ipamConfig.AuxAddress
+		}
+		if len(pool) > 0 {
+			pools = append(pools, pool)
+		}
+	}
+	if len(pools) > 0 {
+		ipam["config"] = pools
+	}
+	if len(ipam) > 0 {
+		network["ipam"] = ipam
+	}
+	return network
+}
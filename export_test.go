@@ -0,0 +1,203 @@
+package dev
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/spf13/afero"
+	"github.com/wish/dev/compose"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/state"
+	"gopkg.in/yaml.v2"
+	"gotest.tools/v3/env"
+)
+
+func TestExportCompose(t *testing.T) {
+	defer env.Patch(t, "DEV_STATE_HOME", "/state")()
+	defer env.Patch(t, "DB_VERSION", "12")()
+	appConfig := c.NewConfig()
+	appConfig.ImagePrefix = "dev"
+	appConfig.SetFs(afero.NewMemMapFs())
+	appConfig.Networks["shared"] = &types.NetworkCreate{
+		Driver: "bridge",
+		IPAM:   &network.IPAM{Config: []network.IPAMConfig{{Subnet: c.SubnetAuto}}},
+	}
+	writeFiles(t, appConfig.GetFs(), map[string]string{
+		"/home/db/docker-compose.yml": `services:
+  db:
+    image: "postgres:${DB_VERSION}"
+    volumes:
+      - data:/var/lib/postgresql/data
+    networks:
+      - shared
+volumes:
+  data:
+networks:
+  shared:
+    external: true
+`,
+		"/home/app/docker-compose.yml": `services:
+  app:
+    build: ./app
+    command: sh -c 'echo $$HOME'
+    networks:
+      - shared
+      - default
+networks:
+  shared:
+    external:
+      name: shared
+`,
+		"/home/app/docker/docker-compose.override.yml": `services:
+  app:
+    volumes:
+      - ./src:/src
+`,
+	})
+	localState, err := state.Load(appConfig.GetFs(), appConfig.ImagePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localState.Subnets["shared"] = "10.242.3.0/24"
+	if err := localState.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	projects := []*c.Project{
+		{Name: "db", DockerComposeFilenames: []string{"/home/db/docker-compose.yml"}},
+		{Name: "app", DockerComposeFilenames: []string{"/home/app/docker-compose.yml",
+			"/home/app/docker/docker-compose.override.yml"}},
+	}
+	b, err := ExportCompose(appConfig, projects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := string(b)
+	if !strings.HasPrefix(exported, "name: dev\n") {
+		t.Errorf("Expected the compose project to be named after the image prefix, got %q", exported)
+	}
+	for _, expected := range []string{
+		"build: /home/app/app",
+		"- /home/app/src:/src",
+		"image: postgres:12",
+		"echo $$HOME",
+		`  shared:
+    driver: bridge
+    ipam:
+      config:
+      - subnet: 10.242.3.0/24
+    name: shared
+`,
+	} {
+		if !strings.Contains(exported, expected) {
+			t.Errorf("Expected the exported compose file to contain %q, got %q", expected, exported)
+		}
+	}
+	if strings.Contains(exported, "external") {
+		t.Errorf("Expected the managed network to be defined, got %q", exported)
+	}
+
+	// the exported file is a compose file of its own
+	if err := afero.WriteFile(appConfig.GetFs(), "/export/docker-compose.yml", b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	composeConfig, err := compose.Parse(appConfig.GetFs(), "/export", "/export/docker-compose.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(composeConfig.Services) != 2 || len(composeConfig.Volumes) != 1 {
+		t.Errorf("Expected the services and volumes of both projects, got %v %v",
+			composeConfig.Services, composeConfig.Volumes)
+	}
+}
+
+func TestExportComposeKeepsSettings(t *testing.T) {
+	defer env.Patch(t, "DEV_STATE_HOME", "/state")()
+	appConfig := c.NewConfig()
+	appConfig.ImagePrefix = "dev"
+	appConfig.SetFs(afero.NewMemMapFs())
+	writeFiles(t, appConfig.GetFs(), map[string]string{
+		"/home/app/.env": "MEMORY=512m\n",
+		"/home/app/docker-compose.yml": `services:
+  app:
+    image: app
+    mem_limit: ${MEMORY}
+    volumes_from:
+      - data
+    platform: linux/amd64
+    pull_policy: always
+    env_file: app.env
+    depends_on:
+      - cache
+  db:
+    image: postgres
+  cache:
+    image: redis
+  data:
+    image: busybox
+`,
+		"/home/app/docker-compose.override.yml": `services:
+  app:
+    depends_on:
+      db:
+        condition: service_healthy
+secrets:
+  password:
+    file: ./secrets/password.txt
+`,
+		"/home/app/config/docker-compose.config.yml": `configs:
+  nginx:
+    file: nginx.conf
+  external:
+    external: true
+`,
+	})
+
+	projects := []*c.Project{{Name: "app", DockerComposeFilenames: []string{
+		"/home/app/docker-compose.yml", "/home/app/docker-compose.override.yml",
+		"/home/app/config/docker-compose.config.yml"}}}
+	b, err := ExportCompose(appConfig, projects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported struct {
+		Services map[string]map[string]interface{}
+		Secrets  map[string]map[string]interface{}
+		Configs  map[string]map[string]interface{}
+	}
+	if err := yaml.Unmarshal(b, &exported); err != nil {
+		t.Fatal(err)
+	}
+	app := exported.Services["app"]
+	expected := map[string]interface{}{
+		"mem_limit":    "512m",
+		"volumes_from": []interface{}{"data"},
+		"platform":     "linux/amd64",
+		"pull_policy":  "always",
+		"env_file":     []interface{}{"/home/app/app.env"},
+		"depends_on": map[interface{}]interface{}{
+			"cache": map[interface{}]interface{}{"condition": "service_started"},
+			"db":    map[interface{}]interface{}{"condition": "service_healthy"},
+		},
+	}
+	for key, value := range expected {
+		if !reflect.DeepEqual(app[key], value) {
+			t.Errorf("Expected %s of the exported service to be %v, got %v", key, value, app[key])
+		}
+	}
+
+	// the files of secrets and configs are relative to the project
+	// directory, as the paths of the services are, in every compose file
+	if file := exported.Secrets["password"]["file"]; file != "/home/app/secrets/password.txt" {
+		t.Errorf("Expected the secret file /home/app/secrets/password.txt, got %v", file)
+	}
+	if file := exported.Configs["nginx"]["file"]; file != "/home/app/nginx.conf" {
+		t.Errorf("Expected the config file /home/app/nginx.conf, got %v", file)
+	}
+	if external := exported.Configs["external"]["external"]; external != true {
+		t.Errorf("Expected the external config to be kept, got %v", exported.Configs["external"])
+	}
+}
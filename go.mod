@@ -8,8 +8,8 @@ require (
 	github.com/docker/cli v0.0.0-20190529200812-c02f389c787f
 	github.com/docker/distribution v2.7.1+incompatible // indirect
 	github.com/docker/docker v0.7.3-0.20190521182756-12b837e474e2
-	github.com/docker/go-connections v0.4.0 // indirect
-	github.com/docker/go-units v0.4.0 // indirect
+	github.com/docker/go-connections v0.4.0
+	github.com/docker/go-units v0.4.0
 	github.com/gogo/protobuf v1.2.1 // indirect
 	github.com/google/go-cmp v0.5.5
 	github.com/goombaio/dag v0.0.0-20181006234417-a8874b1f72ff
@@ -32,6 +32,7 @@ require (
 	github.com/xeipuuv/gojsonschema v0.0.0-20160323030313-93e72a773fad // indirect
 	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4 // indirect
 	google.golang.org/grpc v1.20.1 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 	gotest.tools v2.2.0+incompatible // indirect
 	gotest.tools/v3 v3.3.0
 )
@@ -0,0 +1,252 @@
+package dev
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"github.com/wish/dev/state"
+	"gopkg.in/yaml.v2"
+)
+
+// invalidInstanceChars matches the characters not allowed in instance names.
+var invalidInstanceChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// InstanceSummary describes an instance of the projects.
+type InstanceSummary struct {
+	Name   string
+	Number int
+	// PortOffset is how far the published host ports of the instance are
+	// moved.
+	PortOffset int
+	Directory  string
+	// Time the instance was last brought up.
+	Time       time.Time
+	Running    int
+	Containers int
+}
+
+// WorktreeInstance returns the name of the instance for the linked git
+// worktree the directory is in, that of the worktree. An empty name is
+// returned for the main worktree and for directories outside of git
+// repositories.
+func WorktreeInstance(fs afero.Fs, dir string) (string, error) {
+	for {
+		gitPath := filepath.Join(dir, ".git")
+		info, err := fs.Stat(gitPath)
+		if err == nil {
+			if info.IsDir() {
+				return "", nil
+			}
+			b, err := afero.ReadFile(fs, gitPath)
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to read %s", gitPath)
+			}
+			// a linked worktree has a .git file pointing to its
+			// directory in the worktrees of the main repository
+			gitDir := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(b)), "gitdir:"))
+			if filepath.Base(filepath.Dir(gitDir)) != "worktrees" {
+				return "", nil
+			}
+			name := invalidInstanceChars.ReplaceAllString(strings.ToLower(filepath.Base(gitDir)), "-")
+			return strings.TrimLeft(name, "-_"), nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// PrepareInstance records the selected instance of the projects, which are
+// brought up together, numbering it if it is new. Services with a fixed
+// container name are reported as they conflict with other instances.
+func PrepareInstance(appConfig *c.Dev, projects []*c.Project) error {
+	if appConfig.Instance == "" {
+		return nil
+	}
+	localState, err := state.Load(appConfig.GetFs(), appConfig.BaseImagePrefix())
+	if err != nil {
+		return err
+	}
+	instance, ok := localState.Instances[appConfig.Instance]
+	if !ok {
+		used := make(map[int]bool)
+		for _, other := range localState.Instances {
+			used[other.Number] = true
+		}
+		instance = &state.Instance{Number: 1}
+		for used[instance.Number] {
+			instance.Number++
+		}
+		localState.Instances[appConfig.Instance] = instance
+		log.Infof("Created instance %s, its ports are moved by %d", appConfig.Instance,
+			instance.Number*appConfig.InstancePortOffset)
+	}
+	instance.Directory = appConfig.Dir
+	instance.Time = time.Now()
+	if err := localState.Save(); err != nil {
+		return err
+	}
+
+	for _, project := range projects {
+		composeConfig, err := parseCompose(appConfig, project)
+		if err != nil {
+			return err
+		}
+		for _, service := range composeConfig.Services {
+			if service.ContainerName != "" {
+				log.Warnf("Service %s of %s sets container_name %s, which every instance uses",
+					service.Name, project.Name, service.ContainerName)
+			}
+		}
+	}
+	return nil
+}
+
+// instancePortShift returns how far the published host ports of the selected
+// instance are moved, zero for the default instance.
+func instancePortShift(appConfig *c.Dev) (int, error) {
+	if appConfig.Instance == "" {
+		return 0, nil
+	}
+	localState, err := state.Load(appConfig.GetFs(), appConfig.BaseImagePrefix())
+	if err != nil {
+		return 0, err
+	}
+	instance, ok := localState.Instances[appConfig.Instance]
+	if !ok {
+		return 0, nil
+	}
+	return instance.Number * appConfig.InstancePortOffset, nil
+}
+
+// otherInstancePrefixes returns the image prefixes of the recorded instances
+// but the selected one. Their images and containers match the image prefix of
+// the default instance, or of instances whose name is a prefix of theirs.
+func otherInstancePrefixes(appConfig *c.Dev) ([]string, error) {
+	localState, err := state.Load(appConfig.GetFs(), appConfig.BaseImagePrefix())
+	if err != nil {
+		return nil, err
+	}
+	prefixes := []string{}
+	for name := range localState.Instances {
+		if name != appConfig.Instance {
+			prefixes = append(prefixes, appConfig.BaseImagePrefix()+"-"+name)
+		}
+	}
+	sort.Strings(prefixes)
+	return prefixes, nil
+}
+
+// ListInstances describes the recorded instances of the projects, in the
+// order they were created, with their containers.
+func ListInstances(appConfig *c.Dev) ([]*InstanceSummary, error) {
+	localState, err := state.Load(appConfig.GetFs(), appConfig.BaseImagePrefix())
+	if err != nil {
+		return nil, err
+	}
+	containers, err := appConfig.GetEngine().ContainerList()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := []*InstanceSummary{}
+	for name, instance := range localState.Instances {
+		summary := &InstanceSummary{
+			Name:       name,
+			Number:     instance.Number,
+			PortOffset: instance.Number * appConfig.InstancePortOffset,
+			Directory:  instance.Directory,
+			Time:       instance.Time,
+		}
+		prefix := appConfig.BaseImagePrefix() + "-" + name
+		for _, container := range containers {
+			if container.Labels[docker.ComposeProjectLabel] != prefix {
+				continue
+			}
+			summary.Containers++
+			if container.State == StateRunning {
+				summary.Running++
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Number < summaries[j].Number })
+	return summaries, nil
+}
+
+// PrintInstances writes the instances, the selected one marked with a '*'.
+func PrintInstances(out io.Writer, summaries []*InstanceSummary, selected string) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPORTS\tCONTAINERS\tLAST UP\tDIRECTORY")
+	for _, summary := range summaries {
+		name := summary.Name
+		if name == selected {
+			name += " *"
+		}
+		fmt.Fprintf(w, "%s\t+%d\t%d/%d running\t%s ago\t%s\n", name, summary.PortOffset, summary.Running,
+			summary.Containers, units.HumanDuration(time.Since(summary.Time)), summary.Directory)
+	}
+	w.Flush()
+}
+
+// instanceComposeFile returns the compose file that points the external
+// networks of the project that are managed by dev to the networks of the
+// selected instance, if there are any. The file is written to the state
+// directory.
+func instanceComposeFile(appConfig *c.Dev, project *c.Project) (string, error) {
+	if appConfig.Instance == "" {
+		return "", nil
+	}
+	type network struct {
+		Name     string `yaml:"name"`
+		External bool   `yaml:"external"`
+	}
+	override := struct {
+		Networks map[string]network `yaml:"networks"`
+	}{make(map[string]network)}
+	composeConfig, err := parseCompose(appConfig, project)
+	if err != nil {
+		return "", err
+	}
+	for key, config := range composeConfig.Networks {
+		name := key
+		if config.External.Name != "" {
+			name = config.External.Name
+		} else if config.Name != "" {
+			name = config.Name
+		}
+		if _, ok := appConfig.Networks[name]; ok {
+			override.Networks[key] = network{Name: appConfig.NetworkName(name), External: true}
+		}
+	}
+	if len(override.Networks) == 0 {
+		return "", nil
+	}
+
+	b, err := yaml.Marshal(&override)
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(state.Directory(appConfig.ImagePrefix), project.Name+".instance.docker-compose.yml")
+	if err := appConfig.GetFs().MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return "", err
+	}
+	if err := afero.WriteFile(appConfig.GetFs(), filename, b, 0644); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
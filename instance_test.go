@@ -0,0 +1,146 @@
+package dev
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/spf13/afero"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"gotest.tools/v3/env"
+)
+
+func TestWorktreeInstance(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := fs.MkdirAll("/home/test/repo/.git/worktrees/Feature.X", 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, fs, map[string]string{
+		"/home/test/feature/.git":   "gitdir: /home/test/repo/.git/worktrees/Feature.X\n",
+		"/home/test/module/.git":    "gitdir: /home/test/repo/.git/modules/module\n",
+		"/home/test/feature/app/.x": "",
+		"/home/test/repo/app/.x":    "",
+	})
+
+	tests := []struct {
+		Dir      string
+		Expected string
+	}{
+		{"/home/test/feature/app", "feature-x"},
+		{"/home/test/feature", "feature-x"},
+		{"/home/test/repo/app", ""},
+		{"/home/test/module", ""},
+		{"/home/other", ""},
+	}
+	for _, tc := range tests {
+		instance, err := WorktreeInstance(fs, tc.Dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance != tc.Expected {
+			t.Errorf("Expected instance '%s' for %s, got '%s'", tc.Expected, tc.Dir, instance)
+		}
+	}
+}
+
+func instanceTestConfig(t *testing.T) (*c.Dev, *c.Project, *docker.FakeEngine) {
+	project := &c.Project{Name: "app", Directory: "/home/test",
+		DockerComposeFilenames: []string{"/home/test/docker-compose.yml"}}
+	appConfig, engine := testConfig(t, map[string]string{
+		"/home/test/docker-compose.yml": `version: "3.6"
+services:
+  web:
+    image: nginx
+    ports:
+      - "8080:80"
+    networks:
+      - backend
+      - shared
+networks:
+  backend:
+  shared:
+    external: true
+`,
+	}, project)
+	appConfig.Dir = "/home/test"
+	appConfig.InstancePortOffset = 1000
+	appConfig.Networks["shared"] = &types.NetworkCreate{}
+	return appConfig, project, engine
+}
+
+func TestPrepareInstance(t *testing.T) {
+	defer env.Patch(t, "DEV_STATE_HOME", "/state")()
+	defer func(available func(string, string, int) bool) { portAvailable = available }(portAvailable)
+	portAvailable = func(protocol, hostIP string, port int) bool { return true }
+	appConfig, project, engine := instanceTestConfig(t)
+
+	if files, err := ComposeFiles(appConfig, project); err != nil || len(files) != 1 {
+		t.Errorf("Expected no overrides for the default instance, got %v", files)
+	}
+
+	for _, instance := range []string{"feature", "bugfix"} {
+		if err := appConfig.SetInstance(instance); err != nil {
+			t.Fatal(err)
+		}
+		if err := PrepareInstance(appConfig, []*c.Project{project}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if appConfig.ImagePrefix != "dev-bugfix" {
+		t.Errorf("Expected the image prefix of the instance, got %s", appConfig.ImagePrefix)
+	}
+	conflicts, err := PreparePorts(appConfig, []*c.Project{project}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts, got %v", conflicts)
+	}
+
+	files, err := ComposeFiles(appConfig, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Expected the networks and ports overrides of the instance, got %v", files)
+	}
+	expected := map[string]string{
+		"/state/dev-bugfix/app.instance.docker-compose.yml": `networks:
+  shared:
+    name: shared-bugfix
+    external: true
+`,
+		"/state/dev-bugfix/app.ports.docker-compose.yml": `    ports: !override
+      - "10080:80/tcp"
+`,
+	}
+	for _, filename := range files[1:] {
+		b, err := afero.ReadFile(appConfig.GetFs(), filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := expected[filename]; !ok || !strings.Contains(string(b), expected[filename]) {
+			t.Errorf("Expected %s to contain %q, got %q", filename, expected[filename], string(b))
+		}
+	}
+
+	engine.AddContainer(types.Container{ID: "web", Names: []string{"/dev-bugfix_web_1"}, State: "running",
+		Labels: map[string]string{docker.ComposeProjectLabel: "dev-bugfix"}})
+	summaries, err := ListInstances(appConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 || summaries[0].Name != "feature" || summaries[1].Name != "bugfix" {
+		t.Fatalf("Expected the feature and bugfix instances in order, got %v", summaries)
+	}
+	if summaries[1].PortOffset != 2000 || summaries[1].Running != 1 || summaries[1].Directory != "/home/test" {
+		t.Errorf("Unexpected summary of the bugfix instance: %+v", summaries[1])
+	}
+	var out bytes.Buffer
+	PrintInstances(&out, summaries, appConfig.Instance)
+	if !strings.Contains(out.String(), "bugfix *  +2000") {
+		t.Errorf("Expected the selected instance to be marked, got %q", out.String())
+	}
+}
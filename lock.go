@@ -0,0 +1,340 @@
+package dev
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"github.com/wish/dev/state"
+	"gopkg.in/yaml.v2"
+)
+
+// LockFilename is the name of the file, next to the dev configuration, in
+// which the digests of the images of the projects are pinned.
+const LockFilename = "dev.lock.yaml"
+
+// Lock pins the images used by projects to the digests they resolved to when
+// locked, so that everyone using the configuration runs the same images.
+type Lock struct {
+	Projects map[string]*ProjectLock `yaml:"projects"`
+
+	filename string
+	fs       afero.Fs
+}
+
+// ProjectLock holds the pinned images of a project by service.
+type ProjectLock struct {
+	// Images are the images of the services that are not built, as
+	// pulled by compose.
+	Images map[string]*LockedImage `yaml:"images,omitempty"`
+	// Downloads are the images of the services that are built, as
+	// downloaded by the download command.
+	Downloads map[string]*LockedImage `yaml:"downloads,omitempty"`
+}
+
+// LockedImage is an image reference and the digest it resolved to.
+type LockedImage struct {
+	Image  string `yaml:"image"`
+	Digest string `yaml:"digest"`
+}
+
+// imageRepository returns the repository of the image reference, without its
+// tag or digest.
+func imageRepository(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// normalizeRepository removes the implicit docker hub registry from the
+// repository so that the names docker reports compare equal to those
+// configured.
+func normalizeRepository(repository string) string {
+	repository = strings.TrimPrefix(repository, "docker.io/")
+	return strings.TrimPrefix(repository, "library/")
+}
+
+// Reference returns the reference of the pinned image, its repository and
+// digest.
+func (l *LockedImage) Reference() string {
+	return imageRepository(l.Image) + "@" + l.Digest
+}
+
+// BundledReference returns the tag the pinned image is loaded from a bundle
+// under. Images loaded with 'docker load' have no digest, so the reference of
+// the pinned image does not resolve to them.
+func (l *LockedImage) BundledReference() string {
+	return imageRepository(l.Image) + ":" + strings.Replace(l.Digest, ":", "-", 1)
+}
+
+// LoadLock reads the lock file next to the dev configuration. An empty lock is
+// returned if there is none.
+func LoadLock(appConfig *c.Dev) (*Lock, error) {
+	l := &Lock{
+		Projects: make(map[string]*ProjectLock),
+		filename: filepath.Join(appConfig.Dir, LockFilename),
+		fs:       appConfig.GetFs(),
+	}
+	b, err := afero.ReadFile(l.fs, l.filename)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", l.filename)
+	}
+	if err := yaml.Unmarshal(b, l); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", l.filename)
+	}
+	if l.Projects == nil {
+		l.Projects = make(map[string]*ProjectLock)
+	}
+	return l, nil
+}
+
+// Save writes the lock file.
+func (l *Lock) Save() error {
+	b, err := yaml.Marshal(l)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode lock")
+	}
+	header := "# Generated by 'dev <project> lock', do not edit.\n"
+	if err := afero.WriteFile(l.fs, l.filename, append([]byte(header), b...), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write %s", l.filename)
+	}
+	return nil
+}
+
+// Project returns the pinned images of the project, nil if it is not locked.
+func (l *Lock) Project(name string) *ProjectLock {
+	return l.Projects[name]
+}
+
+// pinned returns the image pinned for the service if it was locked from the
+// same reference, warning when the lock is out of date.
+func pinned(images map[string]*LockedImage, project, service, ref string) *LockedImage {
+	locked, ok := images[service]
+	if !ok {
+		return nil
+	}
+	if locked.Image != ref {
+		log.Warnf("The lock of %s is for %s rather than %s, run 'dev %s lock' to update it",
+			service, locked.Image, ref, project)
+		return nil
+	}
+	return locked
+}
+
+// composeImages returns the images of the services of the project that are
+// not built, by service.
+func composeImages(appConfig *c.Dev, project *c.Project) (map[string]string, error) {
+	images := make(map[string]string)
+	composeConfig, err := parseCompose(appConfig, project)
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range composeConfig.Services {
+		if service.Build.Context == "" && service.Image != "" {
+			images[service.Name] = service.Image
+		}
+	}
+	return images, nil
+}
+
+// DigestResolver returns the digest of the image reference.
+type DigestResolver func(ref string) (string, error)
+
+// ResolveDigest pulls the image and returns the digest the registry reported
+// for it.
+func ResolveDigest(appConfig *c.Dev) DigestResolver {
+	return func(ref string) (string, error) {
+		if err := RunDockerPull(ref); err != nil {
+			return "", errors.Wrapf(err, "failed to pull %s", ref)
+		}
+		image, err := appConfig.GetEngine().ImageInspect(ref)
+		if err != nil {
+			return "", err
+		}
+		repository := normalizeRepository(imageRepository(ref))
+		for _, repoDigest := range image.RepoDigests {
+			if normalizeRepository(imageRepository(repoDigest)) == repository {
+				return repoDigest[strings.Index(repoDigest, "@")+1:], nil
+			}
+		}
+		return "", errors.Errorf("the registry did not report a digest for %s", ref)
+	}
+}
+
+// UpdateLock pins the images of the project that are not pinned, or whose
+// reference changed since they were. With update, the images of the selected
+// services, or all if none are, are resolved again. The services whose pinned
+// digest changed are returned.
+func UpdateLock(appConfig *c.Dev, project *c.Project, lock *Lock, update bool, selected []string,
+	resolve DigestResolver) ([]string, error) {
+	images, err := composeImages(appConfig, project)
+	if err != nil {
+		return nil, err
+	}
+
+	// downloaded images are only locked if the project downloads from a
+	// registry
+	downloads := make(map[string]string)
+	if planned, err := planDownload(appConfig, project, nil, nil); err == nil {
+		for _, image := range planned {
+			downloads[image.Service] = image.Remote
+		}
+	} else {
+		log.Debugf("Not locking downloaded images of %s: %s", project.Name, err)
+	}
+
+	for _, service := range selected {
+		_, isImage := images[service]
+		_, isDownload := downloads[service]
+		if !isImage && !isDownload {
+			return nil, errors.Errorf("%s is not a service of %s with an image to lock", service, project.Name)
+		}
+	}
+
+	projectLock := lock.Projects[project.Name]
+	if projectLock == nil {
+		projectLock = &ProjectLock{}
+		lock.Projects[project.Name] = projectLock
+	}
+	changed := []string{}
+	lockImages := func(refs map[string]string, locked map[string]*LockedImage) (map[string]*LockedImage, error) {
+		result := make(map[string]*LockedImage)
+		services := make([]string, 0, len(refs))
+		for service := range refs {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+
+		for _, service := range services {
+			ref := refs[service]
+			current, ok := locked[service]
+			refresh := update && (len(selected) == 0 || SliceContainsString(selected, service))
+			if ok && current.Image == ref && !refresh {
+				result[service] = current
+				continue
+			}
+			digest, err := resolve(ref)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to lock %s", service)
+			}
+			if !ok || current.Digest != digest || current.Image != ref {
+				changed = append(changed, service)
+			}
+			result[service] = &LockedImage{Image: ref, Digest: digest}
+		}
+		return result, nil
+	}
+
+	if projectLock.Images, err = lockImages(images, projectLock.Images); err != nil {
+		return nil, err
+	}
+	if projectLock.Downloads, err = lockImages(downloads, projectLock.Downloads); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// ComposeFiles returns the docker compose files of the project along with, if
+// the images of the project are locked, a compose file that overrides the
+// image of each service with its pinned digest, for an instance, a compose file
+// pointing to the managed networks of the instance and, if ports of the
+// project were remapped by PreparePorts, the compose file with the remapped
+// ports. The overrides are written to the state directory. An override that
+// cannot be written is ignored with a warning, but for that of an instance,
+// without which the instance would use the networks of the default instance.
+func ComposeFiles(appConfig *c.Dev, project *c.Project) ([]string, error) {
+	files := lockedComposeFiles(appConfig, project, appConfig.GetEngine())
+	filename, err := instanceComposeFile(appConfig, project)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to write the networks of instance %s", appConfig.Instance)
+	}
+	if filename != "" {
+		files = append(append([]string{}, files...), filename)
+	}
+	if filename, ok := remappedPortsFile(appConfig, project); ok {
+		files = append(append([]string{}, files...), filename)
+	}
+	return files, nil
+}
+
+// lockedReference returns the reference of the pinned image, or the tag it was
+// loaded from a bundle under if only that is available.
+func lockedReference(engine docker.Engine, locked *LockedImage) string {
+	ref := locked.Reference()
+	if engine == nil {
+		return ref
+	}
+	if _, err := engine.ImageInspect(ref); err == nil {
+		return ref
+	}
+	if _, err := engine.ImageInspect(locked.BundledReference()); err == nil {
+		return locked.BundledReference()
+	}
+	return ref
+}
+
+// lockedComposeFiles returns the docker compose files of the project and the
+// override with the pinned images of the project, if it is locked. With an
+// engine, the pinned images that were loaded from a bundle rather than pulled
+// are referred to by the tag they were loaded under.
+func lockedComposeFiles(appConfig *c.Dev, project *c.Project, engine docker.Engine) []string {
+	files := project.DockerComposeFilenames
+	lock, err := LoadLock(appConfig)
+	if err != nil {
+		log.Warnf("Ignoring the lock of %s: %s", project.Name, err)
+		return files
+	}
+	projectLock := lock.Project(project.Name)
+	if projectLock == nil || len(projectLock.Images) == 0 {
+		return files
+	}
+	images, err := composeImages(appConfig, project)
+	if err != nil {
+		log.Warnf("Ignoring the lock of %s: %s", project.Name, err)
+		return files
+	}
+
+	type service struct {
+		Image string `yaml:"image"`
+	}
+	override := struct {
+		Services map[string]service `yaml:"services"`
+	}{make(map[string]service)}
+	for name, ref := range images {
+		if locked := pinned(projectLock.Images, project.Name, name, ref); locked != nil {
+			override.Services[name] = service{Image: lockedReference(engine, locked)}
+		}
+	}
+	if len(override.Services) == 0 {
+		return files
+	}
+
+	b, err := yaml.Marshal(&override)
+	if err != nil {
+		log.Warnf("Ignoring the lock of %s: %s", project.Name, err)
+		return files
+	}
+	filename := filepath.Join(state.Directory(appConfig.ImagePrefix), project.Name+".lock.docker-compose.yml")
+	if err := appConfig.GetFs().MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		log.Warnf("Ignoring the lock of %s: %s", project.Name, err)
+		return files
+	}
+	if err := afero.WriteFile(appConfig.GetFs(), filename, b, 0644); err != nil {
+		log.Warnf("Ignoring the lock of %s: %s", project.Name, err)
+		return files
+	}
+	return append(append([]string{}, files...), filename)
+}
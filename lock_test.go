@@ -0,0 +1,135 @@
+package dev
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/spf13/afero"
+	"gotest.tools/v3/env"
+)
+
+func TestImageRepository(t *testing.T) {
+	tests := []struct {
+		Ref      string
+		Expected string
+	}{
+		{"postgres", "postgres"},
+		{"postgres:13", "postgres"},
+		{"localhost:5000/app", "localhost:5000/app"},
+		{"localhost:5000/app:current", "localhost:5000/app"},
+		{"ecr.example.com/app@sha256:abc", "ecr.example.com/app"},
+	}
+	for _, test := range tests {
+		if got := imageRepository(test.Ref); got != test.Expected {
+			t.Errorf("Expected repository %s of %s but got %s", test.Expected, test.Ref, got)
+		}
+	}
+}
+
+func TestUpdateLock(t *testing.T) {
+	defer env.Patch(t, "DEV_STATE_HOME", "/state")()
+	appConfig, project, _ := downloadTestConfig(t)
+	appConfig.Dir = "/home/test"
+
+	digests := map[string]string{
+		"postgres":                                  "sha256:1",
+		"ecr.example.com/dev/images/app:current":    "sha256:2",
+		"ecr.example.com/dev/images/worker:current": "sha256:3",
+	}
+	resolved := []string{}
+	resolve := func(ref string) (string, error) {
+		resolved = append(resolved, ref)
+		return digests[ref], nil
+	}
+
+	lock, err := LoadLock(appConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := UpdateLock(appConfig, project, lock, false, nil, resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"db", "app", "worker"}) {
+		t.Errorf("Expected every service to be locked but got %v", changed)
+	}
+	if err := lock.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// locked images are kept unless updated
+	resolved = []string{}
+	digests["postgres"] = "sha256:4"
+	lock, err = LoadLock(appConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err = UpdateLock(appConfig, project, lock, false, nil, resolve); err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 0 || len(resolved) != 0 {
+		t.Errorf("Expected nothing to be resolved but resolved %v", resolved)
+	}
+	if changed, err = UpdateLock(appConfig, project, lock, true, []string{"db"}, resolve); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resolved, []string{"postgres"}) || !reflect.DeepEqual(changed, []string{"db"}) {
+		t.Errorf("Expected only db to be updated but resolved %v and changed %v", resolved, changed)
+	}
+	if _, err := UpdateLock(appConfig, project, lock, true, []string{"cache"}, resolve); err == nil {
+		t.Errorf("Expected an error updating an unknown service")
+	}
+	if err := lock.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pinned digests are used by up and download
+	files, err := ComposeFiles(appConfig, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[1] != "/state/dev/app.lock.docker-compose.yml" {
+		t.Fatalf("Expected a compose file overriding the locked images but got %v", files)
+	}
+	override, err := afero.ReadFile(appConfig.GetFs(), files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "services:\n  db:\n    image: postgres@sha256:4\n"; string(override) != expected {
+		t.Errorf("Expected override:\n%s\nbut got:\n%s", expected, override)
+	}
+	images, err := PlanDownload(appConfig, project, []string{"app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref := images[0].Reference(); ref != "ecr.example.com/dev/images/app@sha256:2" {
+		t.Errorf("Expected the download of app to be pinned but got %s", ref)
+	}
+}
+
+func TestResolveDigest(t *testing.T) {
+	defer setExecutor(nil)
+	setup()
+	setExecutor(tc.NewCommand)
+
+	appConfig, _, engine := downloadTestConfig(t)
+	engine.ImageDetails["postgres:13"] = types.ImageInspect{
+		RepoDigests: []string{"mirror.example.com/postgres@sha256:1", "postgres@sha256:2"},
+	}
+
+	digest, err := ResolveDigest(appConfig)("docker.io/library/postgres:13")
+	if err == nil {
+		t.Errorf("Expected an error for an image that was not pulled but got %s", digest)
+	}
+	digest, err = ResolveDigest(appConfig)("postgres:13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != "sha256:2" {
+		t.Errorf("Expected digest sha256:2 but got %s", digest)
+	}
+	if !reflect.DeepEqual(tc.Args, []string{"pull", "--quiet", "docker.io/library/postgres:13", "pull", "--quiet", "postgres:13"}) {
+		t.Errorf("Unexpected pulls %v", tc.Args)
+	}
+}
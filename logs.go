@@ -0,0 +1,255 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/mattn/go-isatty"
+	log "github.com/sirupsen/logrus"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+)
+
+// logColors are the ANSI colors used to tell the services apart in an
+// aggregated log stream, in the order they are handed out.
+var logColors = []string{"36", "33", "32", "35", "34", "96", "93", "92", "95", "94"}
+
+// LogOptions are the options accepted by the logs command.
+type LogOptions struct {
+	// Services to show the logs of, all services if empty.
+	Services []string
+	// Since shows logs since a timestamp or relative time (i.e., 10m).
+	Since string
+	// Tail is the number of lines to show from the end of the logs of
+	// each container, or "all".
+	Tail string
+	// Grep is a regular expression the lines must match to be shown.
+	Grep string
+	// Follow the log output.
+	Follow bool
+	// WithDeps aggregates the logs of all the projects the project
+	// depends on.
+	WithDeps bool
+}
+
+// serviceLogLine is a line of log output along with the service that wrote
+// it.
+type serviceLogLine struct {
+	docker.LogLine
+	service string
+}
+
+// logPrinter prints lines prefixed with the name of the service that wrote
+// them, colorized when writing to a terminal.
+type logPrinter struct {
+	width  int
+	colors map[string]string
+	grep   *regexp.Regexp
+}
+
+func newLogPrinter(services []string, grep *regexp.Regexp) *logPrinter {
+	useColor := isatty.IsTerminal(os.Stdout.Fd())
+	printer := &logPrinter{
+		colors: make(map[string]string, len(services)),
+		grep:   grep,
+	}
+
+	sorted := append([]string{}, services...)
+	sort.Strings(sorted)
+	for i, service := range sorted {
+		if len(service) > printer.width {
+			printer.width = len(service)
+		}
+		if useColor {
+			printer.colors[service] = logColors[i%len(logColors)]
+		}
+	}
+	return printer
+}
+
+func (lp *logPrinter) print(line serviceLogLine) {
+	if lp.grep != nil && !lp.grep.MatchString(line.Text) {
+		return
+	}
+	prefix := fmt.Sprintf("%-*s |", lp.width, line.service)
+	if color, ok := lp.colors[line.service]; ok {
+		prefix = "\033[" + color + "m" + prefix + "\033[0m"
+	}
+	fmt.Println(prefix, line.Text)
+}
+
+// Logs shows the logs of the project containers. Logs are read with
+// docker-compose unless they must be filtered or aggregated across
+// projects, in which case they are read from the docker daemon directly.
+func (p *Project) Logs(appConfig *c.Dev, objMap map[string]Dependency, opts *LogOptions) {
+	if !opts.WithDeps && opts.Grep == "" {
+		args := []string{}
+		if opts.Follow {
+			args = append(args, "-f")
+		}
+		if opts.Since != "" {
+			args = append(args, "--since", opts.Since)
+		}
+		if opts.Tail != "" {
+			args = append(args, "--tail", opts.Tail)
+		}
+		args = append(args, opts.Services...)
+		RunComposeLogs(appConfig.ImagePrefix, ProjectProfiles(p.Config), p.Config.DockerComposeFilenames, args...)
+		return
+	}
+
+	var grep *regexp.Regexp
+	if opts.Grep != "" {
+		var err error
+		if grep, err = regexp.Compile(opts.Grep); err != nil {
+			log.Fatalf("Invalid --grep expression: %s", err)
+		}
+	}
+
+	projects := []*Project{p}
+	if opts.WithDeps {
+		deps, err := DependencyProjects(objMap, p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		projects = append(deps, projects...)
+	}
+
+	services := []string{}
+	for _, project := range projects {
+		for _, service := range CreateServiceList(appConfig, project.Config) {
+			if len(opts.Services) > 0 && !SliceContainsString(opts.Services, service) {
+				continue
+			}
+			if !SliceContainsString(services, service) {
+				services = append(services, service)
+			}
+		}
+	}
+	if len(services) == 0 {
+		log.Warnf("No services found for %s", p.Name)
+		return
+	}
+
+	containers, err := docker.ComposeContainers(appConfig.GetEngine(), appConfig.ImagePrefix, services)
+	if err != nil {
+		log.Fatalf("Error communicating with docker daemon, is it up? %s", err)
+	}
+	if len(containers) == 0 {
+		log.Warnf("No containers found for %s", strings.Join(services, ", "))
+		return
+	}
+
+	printer := newLogPrinter(services, grep)
+	start := time.Now()
+
+	// print what has been logged so far ordered by timestamp across all
+	// containers.
+	history := readContainerLogs(context.Background(), appConfig.GetEngine(), containers, types.ContainerLogsOptions{
+		Since: opts.Since,
+		Until: start.Format(time.RFC3339Nano),
+		Tail:  opts.Tail,
+	})
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Time.Before(history[j].Time)
+	})
+	for _, line := range history {
+		if line.Time.After(start) {
+			continue
+		}
+		printer.print(line)
+	}
+
+	if !opts.Follow {
+		return
+	}
+
+	// after that, print lines as they are written, which is close enough
+	// to timestamp order for lines written from this point on.
+	lines := make(chan serviceLogLine)
+	var wg sync.WaitGroup
+	for _, container := range containers {
+		if container.State != "running" {
+			continue
+		}
+		wg.Add(1)
+		go func(container types.Container) {
+			defer wg.Done()
+			followContainerLogs(context.Background(), appConfig.GetEngine(), container, start, lines)
+		}(container)
+	}
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+	for line := range lines {
+		printer.print(line)
+	}
+}
+
+// readContainerLogs reads the logs of each of the containers concurrently
+// and returns all the lines read.
+func readContainerLogs(ctx context.Context, engine docker.Engine, containers []types.Container, opts types.ContainerLogsOptions) []serviceLogLine {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	all := []serviceLogLine{}
+
+	for _, container := range containers {
+		wg.Add(1)
+		go func(container types.Container) {
+			defer wg.Done()
+			service := container.Labels[docker.ComposeServiceLabel]
+			lines := make(chan docker.LogLine)
+			done := make(chan struct{})
+			go func() {
+				for line := range lines {
+					mu.Lock()
+					all = append(all, serviceLogLine{LogLine: line, service: service})
+					mu.Unlock()
+				}
+				close(done)
+			}()
+			if err := docker.ContainerLogs(ctx, engine, container.ID, opts, lines); err != nil {
+				log.Warn(err)
+			}
+			close(lines)
+			<-done
+		}(container)
+	}
+	wg.Wait()
+	return all
+}
+
+// followContainerLogs sends the lines written by container after start to
+// the lines channel until the container stops.
+func followContainerLogs(ctx context.Context, engine docker.Engine, container types.Container, start time.Time, lines chan<- serviceLogLine) {
+	service := container.Labels[docker.ComposeServiceLabel]
+	raw := make(chan docker.LogLine)
+	done := make(chan struct{})
+	go func() {
+		for line := range raw {
+			if !line.Time.After(start) {
+				continue
+			}
+			lines <- serviceLogLine{LogLine: line, service: service}
+		}
+		close(done)
+	}()
+
+	opts := types.ContainerLogsOptions{
+		Follow: true,
+		Since:  start.Format(time.RFC3339Nano),
+	}
+	if err := docker.ContainerLogs(ctx, engine, container.ID, opts, raw); err != nil {
+		log.Warn(err)
+	}
+	close(raw)
+	<-done
+}
@@ -1,13 +1,19 @@
 package dev
 
 import (
+	"fmt"
+	"net"
+	"strings"
+
+	composetypes "github.com/docker/cli/cli/compose/types"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"github.com/wish/dev/compose"
 	"github.com/wish/dev/config"
 	c "github.com/wish/dev/config"
 	"github.com/wish/dev/docker"
+	"github.com/wish/dev/state"
 )
 
 // Network is an external docker network that dev manages.
@@ -24,44 +30,327 @@ func NewNetwork(name string, config *types.NetworkCreate) *Network {
 	}
 }
 
-// create any external network configured in the dev tool if it does not exist
-// already. It returns the network id used to indentify the network by docker.
-func (n *Network) create() string {
-	networkID, err := docker.NetworkIDFromName(n.Name)
+// Create any external network configured in the dev tool if it does not exist
+// already. If the network exists but its configuration has drifted from the
+// dev configuration it is recreated when allowed. It returns the network id
+// used to indentify the network by docker.
+func (n *Network) Create(appConfig *c.Dev) string {
+	name := appConfig.NetworkName(n.Name)
+	networkID, err := docker.NetworkIDFromName(appConfig.GetEngine(), name)
 	if err != nil {
-		err = errors.Wrapf(err, "Error checking if network %s exists", n.Name)
+		err = errors.Wrapf(err, "Error checking if network %s exists", name)
 		log.Fatal(err)
 	}
+	config := n.resolveConfig(appConfig, networkID != "")
 	if networkID == "" {
-		networkID, err = docker.NetworkCreate(n.Name, n.Config)
+		n.checkSubnetConflicts(appConfig, config)
+		networkID, err = docker.NetworkCreate(appConfig.GetEngine(), name, config)
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Infof("Created %s network %s", n.Name, networkID)
-	} else {
-		log.Debugf("Network %s already exists with id %s", n.Name, networkID)
+		log.Infof("Created %s network %s", name, networkID)
+		return networkID
+	}
+	log.Debugf("Network %s already exists with id %s", name, networkID)
+
+	existing, err := appConfig.GetEngine().NetworkInspect(networkID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	drift := networkDrift(config, existing)
+	if len(drift) == 0 {
+		return networkID
+	}
+
+	log.Warnf("Network %s does not match its configuration: %s", name, strings.Join(drift, ", "))
+	if !appConfig.RecreateNetworks && !confirm(fmt.Sprintf("Recreate network %s?", name)) {
+		log.Warnf("Using network %s as is, run with --recreate-networks to recreate it", name)
+		return networkID
 	}
 
+	n.checkSubnetConflicts(appConfig, config)
+	networkID, err = docker.NetworkRecreate(appConfig.GetEngine(), name, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Recreated %s network %s", name, networkID)
 	return networkID
 }
 
-// createNetworkServiceMap creates a mapping from the networks configured by dev
-// to a list of the services that use them in the projects docker-compose files.
-func (n *Network) createNetworkServiceMap(devConfig *config.Dev, project *config.Project,
-	networkIDMap map[string]string) map[string][]string {
+// hasAutoSubnet reports whether dev must pick the subnet of the network.
+func hasAutoSubnet(config *types.NetworkCreate) bool {
+	if config == nil || config.IPAM == nil {
+		return false
+	}
+	for _, ipamConfig := range config.IPAM.Config {
+		if ipamConfig.Subnet == c.SubnetAuto {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveConfig returns the configuration the network is created with. A
+// subnet configured as 'auto' is replaced with the subnet recorded for the
+// network in the local state. A new subnet is picked from the subnet pool and
+// recorded when none has been recorded, or when the network does not exist and
+// the recorded subnet is no longer free.
+func (n *Network) resolveConfig(appConfig *c.Dev, exists bool) *types.NetworkCreate {
+	if !hasAutoSubnet(n.Config) {
+		return n.Config
+	}
+
+	localState, err := state.Load(appConfig.GetFs(), appConfig.ImagePrefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	networks, err := appConfig.GetEngine().NetworkList()
+	if err != nil {
+		log.Fatal(err)
+	}
+	routes, err := HostRoutes()
+	if err != nil {
+		log.Fatal(err)
+	}
+	used := n.usedSubnets(appConfig, localState.Subnets, networks, routes)
 
-	serviceNetworkMap := make(map[string][]string, len(networkIDMap))
-	for _, composeFilename := range project.DockerComposeFilenames {
-		composeConfig, err := compose.Parse(devConfig.GetFs(), project.Directory, composeFilename)
+	subnet := localState.Subnets[n.Name]
+	if subnet != "" && !exists {
+		if _, recorded, err := net.ParseCIDR(subnet); err != nil || !subnetIsFree(recorded, used) {
+			log.Warnf("Subnet %s recorded for network %s is no longer free, picking another", subnet, n.Name)
+			subnet = ""
+		}
+	}
+	if subnet == "" {
+		allocated, err := AllocateSubnet(appConfig.SubnetPool, appConfig.SubnetPoolPrefix, used)
 		if err != nil {
-			log.Fatal("Failed to parse docker-compose appConfig file: ", err)
+			log.Fatalf("Unable to pick a subnet for network %s: %s", n.Name, err)
+		}
+		subnet = allocated.String()
+		localState.Subnets[n.Name] = subnet
+		if err := localState.Save(); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("Picked subnet %s for network %s", subnet, n.Name)
+	}
+
+	config := *n.Config
+	ipam := *n.Config.IPAM
+	ipam.Config = make([]network.IPAMConfig, len(n.Config.IPAM.Config))
+	for i, ipamConfig := range n.Config.IPAM.Config {
+		if ipamConfig.Subnet == c.SubnetAuto {
+			ipamConfig.Subnet = subnet
+		}
+		ipam.Config[i] = ipamConfig
+	}
+	config.IPAM = &ipam
+	return &config
+}
+
+// usedSubnets returns the subnets an automatically picked subnet of the
+// network must not overlap with: those of the other docker networks, the other
+// managed networks, including the automatic subnets recorded for them, and the
+// routes of the host.
+func (n *Network) usedSubnets(appConfig *c.Dev, recorded map[string]string,
+	networks []types.NetworkResource, routes []Route) []*net.IPNet {
+
+	used := []*net.IPNet{}
+	for _, resource := range networks {
+		if resource.Name == appConfig.NetworkName(n.Name) {
+			continue
+		}
+		for _, ipamConfig := range resource.IPAM.Config {
+			if _, subnet, err := net.ParseCIDR(ipamConfig.Subnet); err == nil {
+				used = append(used, subnet)
+			}
+		}
+	}
+	for name, config := range appConfig.Networks {
+		if name != n.Name {
+			used = append(used, managedSubnets(config)...)
+		}
+	}
+	for name, subnet := range recorded {
+		if _, recordedNet, err := net.ParseCIDR(subnet); err == nil && name != n.Name {
+			used = append(used, recordedNet)
+		}
+	}
+	for _, route := range routes {
+		if ones, _ := route.Destination.Mask.Size(); ones > 0 {
+			used = append(used, route.Destination)
+		}
+	}
+	return used
+}
+
+func subnetIsFree(subnet *net.IPNet, used []*net.IPNet) bool {
+	for _, other := range used {
+		if subnetsOverlap(subnet, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkSubnetConflicts exits with a description of the conflicts if the
+// subnets of the network overlap with those of other docker networks or the
+// routes of the host. Docker's own error in this case is hard to decipher.
+func (n *Network) checkSubnetConflicts(appConfig *c.Dev, config *types.NetworkCreate) {
+	networks, err := appConfig.GetEngine().NetworkList()
+	if err != nil {
+		log.Fatal(err)
+	}
+	routes, err := HostRoutes()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := appConfig.NetworkName(n.Name)
+	conflicts := FindSubnetConflicts([]string{name},
+		map[string]*types.NetworkCreate{name: config}, networks, routes)
+	if len(conflicts) == 0 {
+		return
+	}
+	for _, conflict := range conflicts {
+		log.Error(conflict)
+	}
+	log.Fatalf("Unable to create network %s. Change its subnet, use 'subnet: %s' to have dev pick one, "+
+		"or remove the conflicting network", name, c.SubnetAuto)
+}
+
+// sameSubnet reports whether two subnets in CIDR notation are the same
+// network, even when written differently.
+func sameSubnet(a, b string) bool {
+	_, netA, errA := net.ParseCIDR(a)
+	_, netB, errB := net.ParseCIDR(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return netA.String() == netB.String()
+}
+
+// networkDrift compares the configuration of an existing network with the
+// configuration dev would create it with and returns a description of each
+// difference. Settings that are not configured are left to docker's defaults
+// and are not compared.
+func networkDrift(config *types.NetworkCreate, existing types.NetworkResource) []string {
+	drift := []string{}
+	if config == nil {
+		return drift
+	}
+
+	driver := config.Driver
+	if driver == "" {
+		driver = "bridge"
+	}
+	if driver != existing.Driver {
+		drift = append(drift, fmt.Sprintf("driver is %s, expected %s", existing.Driver, driver))
+	}
+	if config.Internal != existing.Internal {
+		drift = append(drift, fmt.Sprintf("internal is %t, expected %t", existing.Internal, config.Internal))
+	}
+	if config.Attachable != existing.Attachable {
+		drift = append(drift, fmt.Sprintf("attachable is %t, expected %t", existing.Attachable, config.Attachable))
+	}
+	if config.EnableIPv6 != existing.EnableIPv6 {
+		drift = append(drift, fmt.Sprintf("ipv6 is %t, expected %t", existing.EnableIPv6, config.EnableIPv6))
+	}
+
+	for key, value := range config.Options {
+		if existing.Options[key] != value {
+			drift = append(drift, fmt.Sprintf("option %s is '%s', expected '%s'", key, existing.Options[key], value))
+		}
+	}
+
+	if config.IPAM == nil {
+		return drift
+	}
+	ipamDriver := config.IPAM.Driver
+	if ipamDriver == "" {
+		ipamDriver = "default"
+	}
+	if ipamDriver != existing.IPAM.Driver {
+		drift = append(drift, fmt.Sprintf("ipam driver is %s, expected %s", existing.IPAM.Driver, ipamDriver))
+	}
+	for _, want := range config.IPAM.Config {
+		if want.Subnet == "" {
+			continue
+		}
+		found := false
+		for _, have := range existing.IPAM.Config {
+			if !sameSubnet(have.Subnet, want.Subnet) {
+				continue
+			}
+			found = true
+			if want.Gateway != "" && have.Gateway != want.Gateway {
+				drift = append(drift, fmt.Sprintf("gateway of %s is %s, expected %s", want.Subnet, have.Gateway, want.Gateway))
+			}
+			if want.IPRange != "" && have.IPRange != want.IPRange {
+				drift = append(drift, fmt.Sprintf("ip range of %s is %s, expected %s", want.Subnet, have.IPRange, want.IPRange))
+			}
+		}
+		if !found {
+			drift = append(drift, fmt.Sprintf("subnet %s is missing", want.Subnet))
+		}
+	}
+	if len(existing.IPAM.Config) > len(config.IPAM.Config) && len(config.IPAM.Config) > 0 {
+		drift = append(drift, fmt.Sprintf("has %d subnets, expected %d", len(existing.IPAM.Config), len(config.IPAM.Config)))
+	}
+
+	return drift
+}
+
+// serviceEndpoint returns the settings the container of a compose service is
+// attached to a network with: the service name and the aliases and addresses
+// configured for the network in the compose file.
+func serviceEndpoint(service composetypes.ServiceConfig, networkName string) *network.EndpointSettings {
+	endpoint := &network.EndpointSettings{
+		Aliases: []string{service.Name},
+	}
+	config := service.Networks[networkName]
+	if config == nil {
+		return endpoint
+	}
+	for _, alias := range config.Aliases {
+		if !SliceContainsString(endpoint.Aliases, alias) {
+			endpoint.Aliases = append(endpoint.Aliases, alias)
+		}
+	}
+	if config.Ipv4Address != "" || config.Ipv6Address != "" {
+		endpoint.IPAMConfig = &network.EndpointIPAMConfig{
+			IPv4Address: config.Ipv4Address,
+			IPv6Address: config.Ipv6Address,
 		}
+	}
+	return endpoint
+}
+
+// createNetworkServiceMap creates a mapping from the networks configured by dev
+// to the services that use them in the projects docker-compose files. The
+// services are mapped to the settings of their network endpoint, by service
+// name and by container name when one is configured. Only the services enabled
+// by the profiles of the project are mapped.
+func (n *Network) createNetworkServiceMap(devConfig *config.Dev, project *config.Project,
+	networkIDMap map[string]string) map[string]map[string]*network.EndpointSettings {
 
-		for _, service := range composeConfig.Services {
-			for name := range service.Networks {
-				if _, ok := networkIDMap[name]; ok {
-					serviceNetworkMap[name] = append(serviceNetworkMap[name], service.Name)
-				}
+	serviceNetworkMap := make(map[string]map[string]*network.EndpointSettings, len(networkIDMap))
+	composeConfig, err := parseCompose(devConfig, project)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, service := range enabledServices(composeConfig, project) {
+		for name := range service.Networks {
+			if _, ok := networkIDMap[name]; !ok {
+				continue
+			}
+			if serviceNetworkMap[name] == nil {
+				serviceNetworkMap[name] = make(map[string]*network.EndpointSettings)
+			}
+			endpoint := serviceEndpoint(service, name)
+			serviceNetworkMap[name][service.Name] = endpoint
+			if service.ContainerName != "" {
+				serviceNetworkMap[name][service.ContainerName] = endpoint
 			}
 		}
 	}
@@ -73,31 +362,35 @@ func (n *Network) createNetworkServiceMap(devConfig *config.Dev, project *config
 //
 // Networks do not persist reboots. Container configured with an old network id
 // that no longer exists will not be able to start (docker-compose up will fail
-// when it attempts to start the container). These containers must be removed
-// before we attempt to start the container.
+// when it attempts to start the container). These containers are removed
+// before we attempt to start the container, or reconnected to the current
+// network if so configured. Running containers are reconnected as they would
+// otherwise be unreachable on the network.
 func (n *Network) verifyContainerConfig(appConfig *config.Dev, project *config.Project, networkID string) {
 	networkIDMap := map[string]string{
 		n.Name: networkID,
 	}
 
+	reconnectExited := appConfig.NetworkRepair == c.NetworkRepairReconnect
 	networkServiceMap := n.createNetworkServiceMap(appConfig, project, networkIDMap)
-	for networkName, services := range networkServiceMap {
+	for networkName, endpoints := range networkServiceMap {
 		networkID := networkIDMap[networkName]
-		err := docker.RemoveContainerIfRequired(networkName, networkID, services)
+		err := docker.RepairContainerNetwork(appConfig.GetEngine(), appConfig.ImagePrefix, appConfig.NetworkName(networkName),
+			networkID, endpoints, reconnectExited)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 }
 
-// PreRun implements the Dependency interface. It will destroy any containers
+// PreRun implements the Dependency interface. It will repair any containers
 // that are attached to a no longer existing network of the same name such that
-// the containers can be created with the correct network.
+// the containers can run with the correct network.
 func (n *Network) PreRun(command string, appConfig *c.Dev, project *Project) {
 	if !SliceContainsString([]string{UP, SH}, command) {
 		return
 	}
-	networkID := n.create()
+	networkID := n.Create(appConfig)
 	n.verifyContainerConfig(appConfig, project.Config, networkID)
 }
 
@@ -0,0 +1,283 @@
+package dev
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+)
+
+// NetworkSummary describes a managed network and the containers attached to
+// it.
+type NetworkSummary struct {
+	Name        string               `json:"name"`
+	ID          string               `json:"id,omitempty"`
+	State       string               `json:"state"`
+	Driver      string               `json:"driver,omitempty"`
+	IPAMDriver  string               `json:"ipam_driver,omitempty"`
+	IPAM        []NetworkIPAMSummary `json:"ipam,omitempty"`
+	Options     map[string]string    `json:"options,omitempty"`
+	Attachments []*NetworkAttachment `json:"attachments,omitempty"`
+}
+
+// NetworkIPAMSummary is the address configuration of a network.
+type NetworkIPAMSummary struct {
+	Subnet  string `json:"subnet,omitempty"`
+	Gateway string `json:"gateway,omitempty"`
+	IPRange string `json:"ip_range,omitempty"`
+}
+
+// NetworkAttachment is a container attached to a network.
+type NetworkAttachment struct {
+	Container string `json:"container"`
+	// Projects whose docker-compose files define the service of the
+	// container, if it was created by compose.
+	Projects    []string `json:"projects,omitempty"`
+	Service     string   `json:"service,omitempty"`
+	State       string   `json:"state"`
+	IPv4Address string   `json:"ipv4_address,omitempty"`
+}
+
+// Running reports the number of attached containers that are running.
+func (ns *NetworkSummary) Running() int {
+	running := 0
+	for _, attachment := range ns.Attachments {
+		if attachment.State == StateRunning {
+			running++
+		}
+	}
+	return running
+}
+
+// serviceProjectMap maps each compose service to the names of the projects
+// whose docker-compose files define it.
+func serviceProjectMap(appConfig *c.Dev) map[string][]string {
+	serviceProjects := make(map[string][]string)
+	for _, name := range appConfig.ProjectNames() {
+		project := appConfig.Projects[name]
+		for _, service := range CreateServiceList(appConfig, project) {
+			serviceProjects[service] = append(serviceProjects[service], project.Name)
+		}
+	}
+	return serviceProjects
+}
+
+// InspectNetworks describes the specified managed networks, or all of them if
+// none are specified.
+func InspectNetworks(appConfig *c.Dev, names []string) ([]*NetworkSummary, error) {
+	if len(names) == 0 {
+		names = appConfig.NetworkNames()
+	}
+	for _, name := range names {
+		if _, ok := appConfig.Networks[name]; !ok {
+			return nil, errors.Errorf("%s is not a network managed by dev", name)
+		}
+	}
+
+	networks, err := appConfig.GetEngine().NetworkList()
+	if err != nil {
+		return nil, err
+	}
+	networkIDs := make(map[string]string, len(networks))
+	for _, network := range networks {
+		networkIDs[network.Name] = network.ID
+	}
+
+	containers, err := appConfig.GetEngine().ContainerList()
+	if err != nil {
+		return nil, err
+	}
+	containerMap := make(map[string]types.Container, len(containers))
+	for _, container := range containers {
+		containerMap[container.ID] = container
+	}
+	serviceProjects := serviceProjectMap(appConfig)
+
+	summaries := []*NetworkSummary{}
+	for _, name := range names {
+		summary := &NetworkSummary{Name: appConfig.NetworkName(name), State: StateMissing}
+		summaries = append(summaries, summary)
+
+		networkID, ok := networkIDs[summary.Name]
+		if !ok {
+			continue
+		}
+		resource, err := appConfig.GetEngine().NetworkInspect(networkID)
+		if err != nil {
+			return nil, err
+		}
+		summary.ID = resource.ID
+		summary.State = StateCreated
+		summary.Driver = resource.Driver
+		summary.IPAMDriver = resource.IPAM.Driver
+		summary.Options = resource.Options
+		for _, config := range resource.IPAM.Config {
+			summary.IPAM = append(summary.IPAM, NetworkIPAMSummary{
+				Subnet:  config.Subnet,
+				Gateway: config.Gateway,
+				IPRange: config.IPRange,
+			})
+		}
+
+		for containerID, endpoint := range resource.Containers {
+			attachment := &NetworkAttachment{
+				Container:   endpoint.Name,
+				State:       StateExited,
+				IPv4Address: endpoint.IPv4Address,
+			}
+			if container, ok := containerMap[containerID]; ok {
+				if container.State == StateRunning {
+					attachment.State = StateRunning
+				}
+				if container.Labels[docker.ComposeProjectLabel] == appConfig.ImagePrefix {
+					attachment.Service = container.Labels[docker.ComposeServiceLabel]
+					attachment.Projects = serviceProjects[attachment.Service]
+				}
+			}
+			summary.Attachments = append(summary.Attachments, attachment)
+		}
+		sort.Slice(summary.Attachments, func(i, j int) bool {
+			return summary.Attachments[i].Container < summary.Attachments[j].Container
+		})
+	}
+	return summaries, nil
+}
+
+// PrintNetworkList writes a table with a line describing each network.
+func PrintNetworkList(out io.Writer, summaries []*NetworkSummary) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NETWORK\tSTATE\tDRIVER\tSUBNETS\tCONTAINERS\tPROJECTS")
+	for _, summary := range summaries {
+		subnets := []string{}
+		for _, ipam := range summary.IPAM {
+			subnets = append(subnets, ipam.Subnet)
+		}
+		projects := []string{}
+		for _, attachment := range summary.Attachments {
+			for _, project := range attachment.Projects {
+				if !SliceContainsString(projects, project) {
+					projects = append(projects, project)
+				}
+			}
+		}
+		sort.Strings(projects)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d running, %d total\t%s\n", summary.Name, summary.State,
+			summary.Driver, strings.Join(subnets, ", "), summary.Running(), len(summary.Attachments),
+			strings.Join(projects, ", "))
+	}
+	w.Flush()
+}
+
+// PrintNetworkDetails writes the full description of each network.
+func PrintNetworkDetails(out io.Writer, summaries []*NetworkSummary) {
+	for i, summary := range summaries {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "Name:\t%s\n", summary.Name)
+		fmt.Fprintf(w, "State:\t%s\n", summary.State)
+		if summary.State == StateMissing {
+			w.Flush()
+			continue
+		}
+		fmt.Fprintf(w, "ID:\t%s\n", summary.ID)
+		fmt.Fprintf(w, "Driver:\t%s\n", summary.Driver)
+		fmt.Fprintf(w, "IPAM driver:\t%s\n", summary.IPAMDriver)
+		for _, ipam := range summary.IPAM {
+			fmt.Fprintf(w, "Subnet:\t%s\n", ipam.Subnet)
+			if ipam.Gateway != "" {
+				fmt.Fprintf(w, "  Gateway:\t%s\n", ipam.Gateway)
+			}
+			if ipam.IPRange != "" {
+				fmt.Fprintf(w, "  IP range:\t%s\n", ipam.IPRange)
+			}
+		}
+		keys := []string{}
+		for key := range summary.Options {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "Option:\t%s=%s\n", key, summary.Options[key])
+		}
+		w.Flush()
+
+		if len(summary.Attachments) == 0 {
+			fmt.Fprintln(out, "No containers attached")
+			continue
+		}
+		fmt.Fprintln(out)
+		w = tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "CONTAINER\tSTATE\tADDRESS\tSERVICE\tPROJECTS")
+		for _, attachment := range summary.Attachments {
+			service := attachment.Service
+			if service == "" {
+				service = "-"
+			}
+			projects := strings.Join(attachment.Projects, ", ")
+			if projects == "" {
+				projects = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", attachment.Container, attachment.State,
+				attachment.IPv4Address, service, projects)
+		}
+		w.Flush()
+	}
+}
+
+// RemoveNetworks removes the specified managed networks. A network with
+// running containers attached is not removed unless force is true, in which
+// case the containers are disconnected from it first. It returns the names of
+// the networks removed.
+func RemoveNetworks(appConfig *c.Dev, names []string, force bool) ([]string, error) {
+	summaries, err := InspectNetworks(appConfig, names)
+	if err != nil {
+		return nil, err
+	}
+	for _, summary := range summaries {
+		if summary.State == StateMissing {
+			return nil, errors.Errorf("network %s does not exist", summary.Name)
+		}
+		if running := summary.Running(); running > 0 && !force {
+			return nil, errors.Errorf("network %s has %d running containers attached, use --force to remove it anyway",
+				summary.Name, running)
+		}
+	}
+
+	removed := []string{}
+	for _, summary := range summaries {
+		if err := docker.NetworkRemove(appConfig.GetEngine(), summary.ID, force || len(summary.Attachments) > 0); err != nil {
+			return removed, err
+		}
+		removed = append(removed, summary.Name)
+	}
+	return removed, nil
+}
+
+// PruneNetworks removes the managed networks that have no containers
+// attached. It returns the names of the networks removed.
+func PruneNetworks(appConfig *c.Dev) ([]string, error) {
+	summaries, err := InspectNetworks(appConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	for _, summary := range summaries {
+		if summary.State == StateMissing || len(summary.Attachments) > 0 {
+			continue
+		}
+		if err := docker.NetworkRemove(appConfig.GetEngine(), summary.ID, false); err != nil {
+			return removed, err
+		}
+		removed = append(removed, summary.Name)
+	}
+	return removed, nil
+}
@@ -0,0 +1,196 @@
+package dev
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+)
+
+// networkInspectTestConfig returns a configuration managing the backend
+// network, which the containers of the app project and of another compose
+// project are attached to, the empty frontend network and the cache network,
+// which is missing.
+func networkInspectTestConfig(t *testing.T) (*c.Dev, *docker.FakeEngine) {
+	project := &c.Project{Name: "app", Directory: "/home/test",
+		DockerComposeFilenames: []string{"/home/test/docker-compose.yml"}}
+	appConfig, engine := testConfig(t, map[string]string{
+		"/home/test/docker-compose.yml": `version: "3.6"
+services:
+  web:
+    image: nginx
+  db:
+    image: postgres
+`,
+	}, project)
+	for _, name := range []string{"backend", "frontend", "cache"} {
+		appConfig.Networks[name] = &types.NetworkCreate{}
+	}
+
+	backendID := engine.AddNetwork("backend", types.NetworkCreate{Options: map[string]string{"mtu": "1400"},
+		IPAM: &network.IPAM{Config: []network.IPAMConfig{{Subnet: "10.242.0.0/24", Gateway: "10.242.0.1"}}}})
+	engine.AddNetwork("frontend", types.NetworkCreate{})
+	attach := func(id, name, state, project, service, address string) {
+		engine.AddContainer(types.Container{ID: id, Names: []string{"/" + name}, State: state,
+			Labels: map[string]string{docker.ComposeProjectLabel: project, docker.ComposeServiceLabel: service},
+			NetworkSettings: &types.SummaryNetworkSettings{Networks: map[string]*network.EndpointSettings{
+				"backend": {NetworkID: backendID, IPAddress: address},
+			}}})
+	}
+	attach("web", "dev_web_1", "running", "dev", "web", "10.242.0.2")
+	attach("db", "dev_db_1", "exited", "dev", "db", "10.242.0.3")
+	attach("proxy", "other_proxy_1", "running", "other", "proxy", "10.242.0.4")
+	return appConfig, engine
+}
+
+func TestInspectNetworks(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Networks []string
+		// Expected are the summaries, without their ids.
+		Expected []*NetworkSummary
+		Error    bool
+	}{
+		{
+			Name:     "attachments",
+			Networks: []string{"backend"},
+			Expected: []*NetworkSummary{{
+				Name: "backend", State: StateCreated, Driver: "bridge", IPAMDriver: "default",
+				IPAM:    []NetworkIPAMSummary{{Subnet: "10.242.0.0/24", Gateway: "10.242.0.1"}},
+				Options: map[string]string{"mtu": "1400"},
+				Attachments: []*NetworkAttachment{
+					{Container: "dev_db_1", Projects: []string{"app"}, Service: "db", State: StateExited,
+						IPv4Address: "10.242.0.3"},
+					{Container: "dev_web_1", Projects: []string{"app"}, Service: "web", State: StateRunning,
+						IPv4Address: "10.242.0.2"},
+					// the services of other compose projects are not
+					// those of the projects
+					{Container: "other_proxy_1", State: StateRunning, IPv4Address: "10.242.0.4"},
+				},
+			}},
+		},
+		{
+			Name:     "missing",
+			Networks: []string{"cache", "frontend"},
+			Expected: []*NetworkSummary{
+				{Name: "cache", State: StateMissing},
+				{Name: "frontend", State: StateCreated, Driver: "bridge", IPAMDriver: "default"},
+			},
+		},
+		{
+			Name: "all",
+			Expected: []*NetworkSummary{
+				{Name: "backend", State: StateCreated},
+				{Name: "cache", State: StateMissing},
+				{Name: "frontend", State: StateCreated},
+			},
+		},
+		{
+			Name:     "unmanaged",
+			Networks: []string{"bridge"},
+			Error:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			appConfig, _ := networkInspectTestConfig(t)
+			summaries, err := InspectNetworks(appConfig, test.Networks)
+			if test.Error {
+				if err == nil {
+					t.Errorf("Expected an error inspecting %v", test.Networks)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(summaries) != len(test.Expected) {
+				t.Fatalf("Expected %d networks, got %d", len(test.Expected), len(summaries))
+			}
+			for i, summary := range summaries {
+				if (summary.ID != "") != (summary.State == StateCreated) {
+					t.Errorf("Expected only created networks to have an id, got %+v", summary)
+				}
+				summary.ID = ""
+				expected := test.Expected[i]
+				if test.Networks == nil {
+					// only the names and states of every network
+					summary = &NetworkSummary{Name: summary.Name, State: summary.State}
+				}
+				if !reflect.DeepEqual(summary, expected) {
+					t.Errorf("Expected %+v, got %+v", expected, summary)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveNetworks(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Networks []string
+		Force    bool
+		Removed  []string
+		Error    bool
+	}{
+		{Name: "empty", Networks: []string{"frontend"}, Removed: []string{"frontend"}},
+		{Name: "running containers", Networks: []string{"backend"}, Error: true},
+		{Name: "forced", Networks: []string{"backend", "frontend"}, Force: true, Removed: []string{"backend", "frontend"}},
+		{Name: "missing", Networks: []string{"cache"}, Error: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			appConfig, engine := networkInspectTestConfig(t)
+			removed, err := RemoveNetworks(appConfig, test.Networks, test.Force)
+			if test.Error != (err != nil) {
+				t.Fatalf("Expected an error: %t, got %v", test.Error, err)
+			}
+			if len(removed) != len(test.Removed) || (len(removed) > 0 && !reflect.DeepEqual(removed, test.Removed)) {
+				t.Errorf("Expected the networks %v to be removed, got %v", test.Removed, removed)
+			}
+			for _, name := range []string{"backend", "frontend"} {
+				_, exists := engine.Network(name)
+				if removed := SliceContainsString(test.Removed, name); exists == removed {
+					t.Errorf("Expected %s to be removed: %t", name, removed)
+				}
+			}
+			if test.Force {
+				if container, _ := engine.Container("web"); len(container.NetworkSettings.Networks) != 0 {
+					t.Errorf("Expected the containers to be disconnected, got %v", container.NetworkSettings.Networks)
+				}
+			}
+		})
+	}
+}
+
+func TestPruneNetworks(t *testing.T) {
+	appConfig, engine := networkInspectTestConfig(t)
+	removed, err := PruneNetworks(appConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the network with containers, running or not, is kept
+	if !reflect.DeepEqual(removed, []string{"frontend"}) {
+		t.Errorf("Expected only the empty network to be pruned, got %v", removed)
+	}
+	if _, ok := engine.Network("backend"); !ok {
+		t.Errorf("Expected the network with containers attached to be kept")
+	}
+
+	for _, id := range []string{"web", "db", "proxy"} {
+		if err := engine.ContainerRemove(id, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if removed, err = PruneNetworks(appConfig); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{"backend"}) {
+		t.Errorf("Expected the network to be pruned once empty, got %v", removed)
+	}
+}
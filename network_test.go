@@ -0,0 +1,199 @@
+package dev
+
+import (
+	"reflect"
+	"testing"
+
+	composetypes "github.com/docker/cli/cli/compose/types"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/spf13/afero"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+)
+
+func TestNetworkDrift(t *testing.T) {
+	config := &types.NetworkCreate{
+		Driver:  "bridge",
+		Options: map[string]string{"com.docker.network.bridge.enable_icc": "true"},
+		IPAM: &network.IPAM{
+			Driver: "default",
+			Config: []network.IPAMConfig{{Subnet: "173.16.242.0/16", Gateway: "173.16.0.1"}},
+		},
+	}
+
+	tests := []struct {
+		Name     string
+		Existing types.NetworkResource
+		Expected []string
+	}{
+		{
+			"unchanged",
+			types.NetworkResource{
+				Driver: "bridge",
+				Options: map[string]string{
+					"com.docker.network.bridge.enable_icc": "true",
+					"com.docker.network.bridge.name":       "br-app",
+				},
+				IPAM: network.IPAM{
+					Driver: "default",
+					Config: []network.IPAMConfig{{Subnet: "173.16.0.0/16", Gateway: "173.16.0.1"}},
+				},
+			},
+			[]string{},
+		},
+		{
+			"changed",
+			types.NetworkResource{
+				Driver:   "overlay",
+				Internal: true,
+				IPAM: network.IPAM{
+					Driver: "default",
+					Config: []network.IPAMConfig{{Subnet: "192.168.5.0/24", Gateway: "192.168.5.1"}},
+				},
+			},
+			[]string{
+				"driver is overlay, expected bridge",
+				"internal is true, expected false",
+				"option com.docker.network.bridge.enable_icc is '', expected 'true'",
+				"subnet 173.16.242.0/16 is missing",
+			},
+		},
+		{
+			"gateway",
+			types.NetworkResource{
+				Driver:  "bridge",
+				Options: map[string]string{"com.docker.network.bridge.enable_icc": "true"},
+				IPAM: network.IPAM{
+					Driver: "default",
+					Config: []network.IPAMConfig{{Subnet: "173.16.242.0/16", Gateway: "173.16.242.1"}},
+				},
+			},
+			[]string{"gateway of 173.16.242.0/16 is 173.16.242.1, expected 173.16.0.1"},
+		},
+	}
+
+	for _, test := range tests {
+		drift := networkDrift(config, test.Existing)
+		if len(drift) != len(test.Expected) {
+			t.Errorf("%s: expected %d differences but got %d: %v", test.Name, len(test.Expected), len(drift), drift)
+			continue
+		}
+		for i, difference := range drift {
+			if difference != test.Expected[i] {
+				t.Errorf("%s: expected difference '%s' but got '%s'", test.Name, test.Expected[i], difference)
+			}
+		}
+	}
+}
+
+func TestServiceEndpoint(t *testing.T) {
+	service := composetypes.ServiceConfig{
+		Name: "app",
+		Networks: map[string]*composetypes.ServiceNetworkConfig{
+			"app-net": {
+				Aliases:     []string{"app", "api"},
+				Ipv4Address: "173.16.242.10",
+			},
+			"other-net": nil,
+		},
+	}
+
+	endpoint := serviceEndpoint(service, "app-net")
+	if !reflect.DeepEqual(endpoint.Aliases, []string{"app", "api"}) {
+		t.Errorf("Expected aliases [app api] but got %v", endpoint.Aliases)
+	}
+	if endpoint.IPAMConfig == nil || endpoint.IPAMConfig.IPv4Address != "173.16.242.10" {
+		t.Errorf("Expected ipv4 address 173.16.242.10 but got %v", endpoint.IPAMConfig)
+	}
+
+	endpoint = serviceEndpoint(service, "other-net")
+	if !reflect.DeepEqual(endpoint.Aliases, []string{"app"}) {
+		t.Errorf("Expected aliases [app] but got %v", endpoint.Aliases)
+	}
+	if endpoint.IPAMConfig != nil {
+		t.Errorf("Expected no IPAM config but got %v", endpoint.IPAMConfig)
+	}
+}
+
+func TestNetworkCreateRecreatesDriftedNetwork(t *testing.T) {
+	engine := docker.NewFakeEngine()
+	oldID := engine.AddNetwork("app-net", types.NetworkCreate{Driver: "bridge"})
+	containerID := engine.AddContainer(types.Container{Names: []string{"/dev-app-1"}, State: "running"})
+	if err := engine.NetworkConnect(oldID, containerID, &network.EndpointSettings{Aliases: []string{"app"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	appConfig := c.NewConfig()
+	appConfig.SetEngine(engine)
+	config := &types.NetworkCreate{Driver: "bridge", Options: map[string]string{"com.docker.network.bridge.enable_icc": "true"}}
+
+	// stdin is not a terminal so the user is not asked and the network is
+	// left as is
+	if networkID := NewNetwork("app-net", config).Create(appConfig); networkID != oldID {
+		t.Errorf("Expected drifted network to be kept without --recreate-networks")
+	}
+
+	appConfig.RecreateNetworks = true
+	networkID := NewNetwork("app-net", config).Create(appConfig)
+	if networkID == oldID {
+		t.Fatalf("Expected drifted network to be recreated")
+	}
+	resource, _ := engine.Network(networkID)
+	if drift := networkDrift(config, resource); len(drift) > 0 {
+		t.Errorf("Expected recreated network to match its configuration but got %v", drift)
+	}
+	container, _ := engine.Container(containerID)
+	if settings := container.NetworkSettings.Networks["app-net"]; settings == nil || settings.NetworkID != networkID {
+		t.Errorf("Expected container to be reconnected to the recreated network but got %+v", settings)
+	}
+}
+
+func TestVerifyContainerConfigRemovesStaleContainers(t *testing.T) {
+	engine := docker.NewFakeEngine()
+	networkID := engine.AddNetwork("app-net", types.NetworkCreate{})
+	stale := map[string]*network.EndpointSettings{"app-net": {NetworkID: "removed-network"}}
+	exitedID := engine.AddContainer(types.Container{
+		Names:           []string{"/dev-app-1"},
+		State:           "exited",
+		Labels:          map[string]string{docker.ComposeProjectLabel: "dev", docker.ComposeServiceLabel: "app"},
+		NetworkSettings: &types.SummaryNetworkSettings{Networks: stale},
+	})
+	unrelatedID := engine.AddContainer(types.Container{
+		Names:           []string{"/dev-db-1"},
+		State:           "exited",
+		Labels:          map[string]string{docker.ComposeProjectLabel: "dev", docker.ComposeServiceLabel: "db"},
+		NetworkSettings: &types.SummaryNetworkSettings{Networks: stale},
+	})
+
+	appConfig := c.NewConfig()
+	appConfig.ImagePrefix = "dev"
+	appConfig.SetEngine(engine)
+	appConfig.SetFs(afero.NewMemMapFs())
+	if err := afero.WriteFile(appConfig.GetFs(), "/home/test/docker-compose.yml", []byte(`version: "3.6"
+services:
+  app:
+    image: app
+    networks:
+      - app-net
+networks:
+  app-net:
+    external: true
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	project := &c.Project{
+		Name:                   "app",
+		Directory:              "/home/test",
+		DockerComposeFilenames: []string{"/home/test/docker-compose.yml"},
+	}
+
+	NewNetwork("app-net", &types.NetworkCreate{}).verifyContainerConfig(appConfig, project, networkID)
+
+	if _, ok := engine.Container(exitedID); ok {
+		t.Errorf("Expected exited container attached to a stale network to be removed")
+	}
+	if _, ok := engine.Container(unrelatedID); !ok {
+		t.Errorf("Expected container of a service not using the network to be kept")
+	}
+}
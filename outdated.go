@@ -0,0 +1,202 @@
+package dev
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/registry"
+)
+
+// outdatedTimeout is the time allowed for each request to a registry that is
+// not configured, e.g., docker hub.
+const outdatedTimeout = 10 * time.Second
+
+// OutdatedImage compares the local image of a service with the image its tag
+// refers to in its registry.
+type OutdatedImage struct {
+	Service string
+	// Image is the reference of the image compared.
+	Image string
+	// LocalDigest is the digest of the local image, empty if it has not
+	// been pulled.
+	LocalDigest   string
+	LocalCreated  time.Time
+	RemoteDigest  string
+	RemoteCreated time.Time
+	// Err is why the image could not be compared.
+	Err error
+
+	// download is how the image is downloaded, nil for the images pulled
+	// by compose.
+	download *DownloadImage
+}
+
+// Outdated reports whether the local image differs from the one in the
+// registry.
+func (o *OutdatedImage) Outdated() bool {
+	return o.Err == nil && o.LocalDigest != o.RemoteDigest
+}
+
+// Behind returns how much older the local image is than the one in the
+// registry, zero if it is not outdated or was not pulled.
+func (o *OutdatedImage) Behind() time.Duration {
+	if !o.Outdated() || o.LocalCreated.IsZero() || o.RemoteCreated.Before(o.LocalCreated) {
+		return 0
+	}
+	return o.RemoteCreated.Sub(o.LocalCreated)
+}
+
+// registryClients holds a client by registry host, with the credentials of
+// the configured registry of the host if there is one.
+type registryClients struct {
+	appConfig *c.Dev
+	clients   map[string]*registry.Client
+}
+
+func (r *registryClients) get(host string) *registry.Client {
+	if client, ok := r.clients[host]; ok {
+		return client
+	}
+	client := registry.NewClient(host, "", "", outdatedTimeout)
+	for _, name := range r.appConfig.RegistryNames() {
+		reg := r.appConfig.Registries[name]
+		if registryImageHost(reg) != host {
+			continue
+		}
+		// the login of the registry uses its name when no username is
+		// set
+		username := reg.Username
+		if username == "" {
+			username = reg.Name
+		}
+		client = registry.NewClient(reg.URL, username, reg.Password, time.Duration(reg.TimeoutSeconds)*time.Second)
+		break
+	}
+	r.clients[host] = client
+	return client
+}
+
+// CheckOutdated compares the images of the selected services of the project,
+// or of all of them if none are selected, with their registries. The images
+// pulled by compose and those downloaded are compared, but for images pinned
+// to a digest in the compose files.
+func CheckOutdated(appConfig *c.Dev, project *c.Project, selected []string) ([]*OutdatedImage, error) {
+	images, err := composeImages(appConfig, project)
+	if err != nil {
+		return nil, err
+	}
+	// downloaded images are planned in the order of their services
+	planned, err := PlanDownload(appConfig, project, nil)
+	if err != nil {
+		planned = nil
+		log.Debugf("Not checking downloaded images of %s: %s", project.Name, err)
+	}
+	for _, service := range selected {
+		_, isImage := images[service]
+		isDownload := false
+		for _, download := range planned {
+			isDownload = isDownload || download.Service == service
+		}
+		if !isImage && !isDownload {
+			return nil, errors.Errorf("%s is not a service of %s with an image to check", service, project.Name)
+		}
+	}
+
+	outdated := []*OutdatedImage{}
+	for _, service := range sortedKeys(images) {
+		if len(selected) > 0 && !SliceContainsString(selected, service) {
+			continue
+		}
+		if strings.Contains(images[service], "@") {
+			log.Debugf("Not checking %s, its image is pinned to a digest", service)
+			continue
+		}
+		outdated = append(outdated, &OutdatedImage{Service: service, Image: images[service]})
+	}
+	for _, download := range planned {
+		if len(selected) > 0 && !SliceContainsString(selected, download.Service) {
+			continue
+		}
+		outdated = append(outdated, &OutdatedImage{Service: download.Service, Image: download.Remote, download: download})
+	}
+
+	clients := &registryClients{appConfig: appConfig, clients: make(map[string]*registry.Client)}
+	engine := appConfig.GetEngine()
+	for _, image := range outdated {
+		host, repository, tag := registry.ParseReference(image.Image)
+		remote, err := clients.get(host).Image(repository, tag)
+		if err != nil {
+			image.Err = err
+			continue
+		}
+		image.RemoteDigest = remote.Digest
+		image.RemoteCreated = remote.Created
+
+		local, err := engine.ImageInspect(image.Image)
+		if err != nil {
+			continue
+		}
+		if created, err := time.Parse(time.RFC3339Nano, local.Created); err == nil {
+			image.LocalCreated = created
+		}
+		for _, repoDigest := range local.RepoDigests {
+			if normalizeRepository(imageRepository(repoDigest)) == normalizeRepository(imageRepository(image.Image)) {
+				image.LocalDigest = repoDigest[strings.Index(repoDigest, "@")+1:]
+				break
+			}
+		}
+	}
+	return outdated, nil
+}
+
+// PullOutdated pulls the images that are outdated, tagging those downloaded
+// with the names compose expects.
+func PullOutdated(appConfig *c.Dev, images []*OutdatedImage) error {
+	for _, image := range images {
+		if !image.Outdated() {
+			continue
+		}
+		log.Infof("Pulling %s for %s", image.Image, image.Service)
+		if image.download != nil {
+			// the tag is pulled, rather than the digest it is locked to
+			download := *image.download
+			download.Digest = ""
+			if err := pullImage(appConfig, &download); err != nil {
+				return err
+			}
+		} else if err := RunDockerPull(image.Image); err != nil {
+			return errors.Wrapf(err, "failed to pull %s", image.Image)
+		}
+	}
+	return nil
+}
+
+// PrintOutdated writes whether the image of each service is up to date and, if
+// it is not, how far behind it is.
+func PrintOutdated(out io.Writer, images []*OutdatedImage) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tIMAGE\tSTATUS\tBEHIND")
+	for _, image := range images {
+		status, behind := "up to date", "-"
+		switch {
+		case image.Err != nil:
+			status = "error: " + image.Err.Error()
+		case image.LocalDigest == "":
+			status = "not pulled"
+		case image.Outdated():
+			status = "outdated"
+			if d := image.Behind(); d > 0 {
+				behind = units.HumanDuration(d)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", image.Service, image.Image, status, behind)
+	}
+	w.Flush()
+}
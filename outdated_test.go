@@ -0,0 +1,121 @@
+package dev
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	c "github.com/wish/dev/config"
+)
+
+func TestCheckOutdated(t *testing.T) {
+	created := time.Date(2020, 5, 4, 12, 0, 0, 0, time.UTC)
+	// a stand-in for a registry:2 with basic auth, with the images of
+	// the app and worker services
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "ecr" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/dev/images/app/manifests/current", "/v2/dev/images/worker/manifests/current":
+			service := strings.Split(r.URL.Path, "/")[4]
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			w.Header().Set("Docker-Content-Digest", "sha256:"+service+"-new")
+			fmt.Fprint(w, `{"config":{"digest":"sha256:config"}}`)
+		case "/v2/dev/images/app/blobs/sha256:config", "/v2/dev/images/worker/blobs/sha256:config":
+			fmt.Fprintf(w, `{"created":%q}`, created.Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	appConfig, project, engine := downloadTestConfig(t)
+	delete(appConfig.Registries, "hub")
+	appConfig.Registries["ecr"] = &c.Registry{Name: "ecr", URL: server.URL, DownloadPath: "dev/images",
+		Password: "secret", TimeoutSeconds: 2}
+	engine.ImageDetails[host+"/dev/images/app:current"] = types.ImageInspect{
+		Created:     created.Add(-50 * time.Hour).Format(time.RFC3339Nano),
+		RepoDigests: []string{host + "/dev/images/app@sha256:app-old"},
+	}
+	engine.ImageDetails[host+"/dev/images/worker:current"] = types.ImageInspect{
+		Created:     created.Format(time.RFC3339Nano),
+		RepoDigests: []string{host + "/dev/images/worker@sha256:worker-new"},
+	}
+
+	images, err := CheckOutdated(appConfig, project, []string{"app", "worker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outdated := []string{}
+	for _, image := range images {
+		if image.Err != nil {
+			t.Errorf("Unexpected error checking %s: %s", image.Service, image.Err)
+		}
+		if image.Outdated() {
+			outdated = append(outdated, image.Service)
+		}
+	}
+	if !reflect.DeepEqual(outdated, []string{"app"}) {
+		t.Errorf("Expected only app to be outdated but got %v", outdated)
+	}
+	if behind := images[0].Behind(); behind != 50*time.Hour {
+		t.Errorf("Expected app to be 50 hours behind but got %s", behind)
+	}
+
+	var out bytes.Buffer
+	PrintOutdated(&out, images)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := [][]string{
+		{"SERVICE", "IMAGE", "STATUS", "BEHIND"},
+		{"app", host + "/dev/images/app:current", "outdated", "2", "days"},
+		{"worker", host + "/dev/images/worker:current", "up", "to", "date", "-"},
+	}
+	for i, line := range lines {
+		if i >= len(expected) || !reflect.DeepEqual(strings.Fields(line), expected[i]) {
+			t.Errorf("Unexpected report:\n%s", out.String())
+			break
+		}
+	}
+
+	if _, err := CheckOutdated(appConfig, project, []string{"cache"}); err == nil {
+		t.Errorf("Expected an error checking an unknown service")
+	}
+}
+
+func TestPullOutdated(t *testing.T) {
+	defer setExecutor(nil)
+	setup()
+	setExecutor(tc.NewCommand)
+
+	appConfig, project, _ := downloadTestConfig(t)
+	downloads, err := PlanDownload(appConfig, project, []string{"worker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := []*OutdatedImage{
+		{Service: "db", Image: "postgres", LocalDigest: "sha256:1", RemoteDigest: "sha256:2"},
+		{Service: "app", Image: "app", LocalDigest: "sha256:1", RemoteDigest: "sha256:1"},
+		{Service: "worker", Image: downloads[0].Remote, RemoteDigest: "sha256:3", download: downloads[0]},
+	}
+	if err := PullOutdated(appConfig, images); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"pull", "--quiet", "postgres",
+		"pull", "--quiet", "ecr.example.com/dev/images/worker:current",
+		"tag", "ecr.example.com/dev/images/worker:current", "dev/worker",
+	}
+	if !reflect.DeepEqual(tc.Args, expected) {
+		t.Errorf("Expected %v but got %v", expected, tc.Args)
+	}
+}
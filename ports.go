@@ -0,0 +1,471 @@
+package dev
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"github.com/wish/dev/compose"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"github.com/wish/dev/state"
+)
+
+// maxPort is the highest port that can be published.
+const maxPort = 65535
+
+// PublishedPort is a port of a service published on the host.
+type PublishedPort struct {
+	Project string
+	Service string
+	// HostIP is the address the port is bound to, empty for all of them.
+	HostIP    string
+	Published int
+	Target    int
+	Protocol  string
+}
+
+func (p *PublishedPort) String() string {
+	return fmt.Sprintf("%d/%s", p.Published, p.Protocol)
+}
+
+// spec returns the port in the short syntax of compose files.
+func (p *PublishedPort) spec() string {
+	spec := fmt.Sprintf("%d/%s", p.Target, p.Protocol)
+	if p.Published != 0 {
+		spec = fmt.Sprintf("%d:%s", p.Published, spec)
+	} else if p.HostIP != "" {
+		spec = ":" + spec
+	}
+	if p.HostIP != "" {
+		spec = p.HostIP + ":" + spec
+	}
+	return spec
+}
+
+// overlaps reports whether the ports cannot both be bound.
+func (p *PublishedPort) overlaps(protocol, hostIP string, port int) bool {
+	if p.Published != port || p.Protocol != protocol {
+		return false
+	}
+	wildcard := func(ip string) bool { return ip == "" || ip == "0.0.0.0" || ip == "::" }
+	return wildcard(p.HostIP) || wildcard(hostIP) || p.HostIP == hostIP
+}
+
+// PortConflict is a published port of a project that is already in use.
+type PortConflict struct {
+	Port *PublishedPort
+	// Owner describes what uses the port.
+	Owner string
+}
+
+func (pc *PortConflict) String() string {
+	return fmt.Sprintf("port %s of service %s of project %s is used by %s",
+		pc.Port, pc.Port.Service, pc.Port.Project, pc.Owner)
+}
+
+// portAvailable reports whether the port can be bound on the host. It is a
+// variable so that tests do not depend on the ports in use on the host.
+var portAvailable = func(protocol, hostIP string, port int) bool {
+	addr := net.JoinHostPort(hostIP, strconv.Itoa(port))
+	if protocol == "udp" {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// parsePort returns the ports of an entry of the ports of a service in a
+// compose file, in the short or long syntax. Ports published on a random host
+// port have no published port.
+func parsePort(entry interface{}) ([]*PublishedPort, error) {
+	ports := []*PublishedPort{}
+	switch entry := entry.(type) {
+	case string, int:
+		mappings, err := nat.ParsePortSpec(fmt.Sprint(entry))
+		if err != nil {
+			return nil, err
+		}
+		for _, mapping := range mappings {
+			published := 0
+			if mapping.Binding.HostPort != "" {
+				if published, err = strconv.Atoi(mapping.Binding.HostPort); err != nil {
+					return nil, err
+				}
+			}
+			ports = append(ports, &PublishedPort{HostIP: mapping.Binding.HostIP, Published: published,
+				Target: mapping.Port.Int(), Protocol: mapping.Port.Proto()})
+		}
+	case map[string]interface{}:
+		published := 0
+		if value, ok := entry["published"]; ok {
+			var err error
+			if published, err = strconv.Atoi(fmt.Sprint(value)); err != nil {
+				return nil, errors.Errorf("invalid published port %v", value)
+			}
+		}
+		target, err := strconv.Atoi(fmt.Sprint(entry["target"]))
+		if err != nil {
+			return nil, errors.Errorf("invalid target port %v", entry["target"])
+		}
+		port := &PublishedPort{Published: published, Target: target, Protocol: "tcp"}
+		if protocol, ok := entry["protocol"].(string); ok {
+			port.Protocol = protocol
+		}
+		if hostIP, ok := entry["host_ip"].(string); ok {
+			port.HostIP = hostIP
+		}
+		ports = append(ports, port)
+	default:
+		return nil, errors.Errorf("invalid port %v", entry)
+	}
+	return ports, nil
+}
+
+// ProjectPorts returns the host ports published by the services of the
+// project in its compose files, those of the services of disabled profiles
+// aside.
+func ProjectPorts(appConfig *c.Dev, project *c.Project) ([]*PublishedPort, error) {
+	ports, err := servicePorts(appConfig, project)
+	if err != nil {
+		return nil, err
+	}
+	published := []*PublishedPort{}
+	for _, port := range ports {
+		if port.Published != 0 {
+			published = append(published, port)
+		}
+	}
+	return published, nil
+}
+
+// servicePorts returns every port of the enabled services of the project in
+// its compose files, published or not. The published ports of an instance are
+// moved by the port offset of the instance.
+func servicePorts(appConfig *c.Dev, project *c.Project) ([]*PublishedPort, error) {
+	shift, err := instancePortShift(appConfig)
+	if err != nil {
+		return nil, err
+	}
+	composeConfig, err := parseCompose(appConfig, project)
+	if err != nil {
+		return nil, err
+	}
+	// the loader drops the host addresses of the ports, which are read from
+	// the merged compose files instead
+	files := project.DockerComposeFilenames
+	dict, err := compose.Merge(appConfig.GetFs(), filepath.Dir(files[0]), files)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse docker-compose appConfig file")
+	}
+	services, _ := dict["services"].(map[string]interface{})
+	enabled := enabledServices(composeConfig, project)
+	sort.Slice(enabled, func(i, j int) bool { return enabled[i].Name < enabled[j].Name })
+
+	ports := []*PublishedPort{}
+	seen := make(map[string]bool)
+	for _, serviceConfig := range enabled {
+		name := serviceConfig.Name
+		service, _ := services[name].(map[string]interface{})
+		entries, _ := service["ports"].([]interface{})
+		for _, entry := range entries {
+			parsed, err := parsePort(entry)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid port of service %s of project %s", name, project.Name)
+			}
+			for _, port := range parsed {
+				port.Project, port.Service = project.Name, name
+				if port.Published != 0 {
+					port.Published += shift
+				}
+				if port.Published > maxPort {
+					return nil, errors.Errorf("port %d of service %s moved by %d for instance %s is above %d",
+						port.Published-shift, name, shift, appConfig.Instance, maxPort)
+				}
+				if key := name + " " + port.spec(); !seen[key] {
+					seen[key] = true
+					ports = append(ports, port)
+				}
+			}
+		}
+	}
+	return ports, nil
+}
+
+// portsOverrideFilename returns the compose file in which the remapped ports
+// of the project are stored.
+func portsOverrideFilename(appConfig *c.Dev, project *c.Project) string {
+	return filepath.Join(state.Directory(appConfig.ImagePrefix), project.Name+".ports.docker-compose.yml")
+}
+
+// portUsage is what uses the host ports, but for the services of the projects
+// being brought up.
+type portUsage struct {
+	// containers are the ports published by the running containers that
+	// are not of the projects, and what they belong to.
+	containers map[*PublishedPort]string
+	// own are the ports published by the running containers of the
+	// projects, which are bound on the host but not in conflict.
+	own []*PublishedPort
+}
+
+// owner returns what uses the port, or an empty string if it is free.
+func (u *portUsage) owner(protocol, hostIP string, port int) string {
+	for used, owner := range u.containers {
+		if used.overlaps(protocol, hostIP, port) {
+			return owner
+		}
+	}
+	for _, used := range u.own {
+		if used.overlaps(protocol, hostIP, port) {
+			return ""
+		}
+	}
+	if !portAvailable(protocol, hostIP, port) {
+		return "another process on the host"
+	}
+	return ""
+}
+
+// usedPorts returns the ports published by running containers, telling those
+// of the services of the projects apart.
+func usedPorts(appConfig *c.Dev, projects []*c.Project) (*portUsage, error) {
+	ownServices := make(map[string]bool)
+	for _, project := range projects {
+		for _, service := range CreateServiceList(appConfig, project) {
+			ownServices[service] = true
+		}
+	}
+	serviceProjects := serviceProjectMap(appConfig)
+
+	containers, err := appConfig.GetEngine().ContainerList()
+	if err != nil {
+		return nil, err
+	}
+	usage := &portUsage{containers: make(map[*PublishedPort]string)}
+	for i, container := range containers {
+		if container.State != "running" {
+			continue
+		}
+		composeProject := container.Labels[docker.ComposeProjectLabel]
+		service := container.Labels[docker.ComposeServiceLabel]
+		own := composeProject == appConfig.ImagePrefix && ownServices[service]
+
+		owner := "container " + docker.ContainerName(&containers[i])
+		if composeProject == appConfig.ImagePrefix && len(serviceProjects[service]) > 0 {
+			owner = fmt.Sprintf("service %s of project %s", service, strings.Join(serviceProjects[service], ", "))
+		} else if composeProject != "" {
+			owner = fmt.Sprintf("service %s of compose project %s", service, composeProject)
+		}
+		for _, port := range container.Ports {
+			if port.PublicPort == 0 {
+				continue
+			}
+			used := &PublishedPort{HostIP: port.IP, Published: int(port.PublicPort), Target: int(port.PrivatePort),
+				Protocol: port.Type}
+			if own {
+				usage.own = append(usage.own, used)
+			} else {
+				usage.containers[used] = owner
+			}
+		}
+	}
+	return usage, nil
+}
+
+// FindPortConflicts returns the host ports published by the projects that are
+// already used by other projects being brought up, by the running containers
+// of other projects or by processes on the host. The running containers of
+// the projects themselves are not conflicts.
+func FindPortConflicts(appConfig *c.Dev, projects []*c.Project) ([]*PortConflict, error) {
+	usage, err := usedPorts(appConfig, projects)
+	if err != nil {
+		return nil, err
+	}
+	conflicts, _, err := findPortConflicts(appConfig, projects, usage)
+	return conflicts, err
+}
+
+func findPortConflicts(appConfig *c.Dev, projects []*c.Project, usage *portUsage) ([]*PortConflict, []*PublishedPort, error) {
+	conflicts := []*PortConflict{}
+	planned := []*PublishedPort{}
+	for _, project := range projects {
+		ports, err := ProjectPorts(appConfig, project)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, port := range ports {
+			owner := ""
+			for _, other := range planned {
+				if other.Service != port.Service && other.overlaps(port.Protocol, port.HostIP, port.Published) {
+					owner = fmt.Sprintf("service %s of project %s", other.Service, other.Project)
+					break
+				}
+			}
+			if owner == "" {
+				owner = usage.owner(port.Protocol, port.HostIP, port.Published)
+			}
+			if owner != "" {
+				conflicts = append(conflicts, &PortConflict{Port: port, Owner: owner})
+			}
+			planned = append(planned, port)
+		}
+	}
+	return conflicts, planned, nil
+}
+
+// PreparePorts checks the host ports published by the projects, which are
+// brought up together, before anything starts. Without conflicts the ports of
+// the projects are used as configured, moved by the port offset of an
+// instance. With an offset, the conflicting ports
+// are moved by multiples of the offset until they are free, with a compose
+// file overriding the ports of the services that ComposeFiles returns. The
+// conflicts that remain are returned.
+func PreparePorts(appConfig *c.Dev, projects []*c.Project, offset int) ([]*PortConflict, error) {
+	if offset < 0 || offset > maxPort {
+		return nil, errors.Errorf("port offset %d must be between 0 and %d", offset, maxPort)
+	}
+	usage, err := usedPorts(appConfig, projects)
+	if err != nil {
+		return nil, err
+	}
+	conflicts, planned, err := findPortConflicts(appConfig, projects, usage)
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 && offset == 0 {
+		return conflicts, nil
+	}
+
+	remapped := make(map[*PublishedPort]int)
+	taken := func(port *PublishedPort, candidate int) bool {
+		for _, other := range planned {
+			moved := *other
+			if published, ok := remapped[other]; ok {
+				moved.Published = published
+			}
+			if other != port && moved.overlaps(port.Protocol, port.HostIP, candidate) {
+				return true
+			}
+		}
+		return usage.owner(port.Protocol, port.HostIP, candidate) != ""
+	}
+	unresolved := []*PortConflict{}
+	for _, conflict := range conflicts {
+		port := conflict.Port
+		candidate := port.Published + offset
+		for candidate <= maxPort && taken(port, candidate) {
+			candidate += offset
+		}
+		if candidate > maxPort {
+			unresolved = append(unresolved, conflict)
+			continue
+		}
+		log.Infof("Publishing port %s of service %s of project %s on %d instead", port, port.Service, port.Project, candidate)
+		remapped[port] = candidate
+	}
+
+	for _, project := range projects {
+		if err := writePortsOverride(appConfig, project, planned, remapped); err != nil {
+			return nil, err
+		}
+	}
+	return unresolved, nil
+}
+
+// writePortsOverride writes the compose file that overrides the ports of the
+// services of the project with ports remapped, or that publish ports in an
+// instance, or removes it if there are none. Every port of those services is
+// listed, remapped or not.
+func writePortsOverride(appConfig *c.Dev, project *c.Project, planned []*PublishedPort, remapped map[*PublishedPort]int) error {
+	shift, err := instancePortShift(appConfig)
+	if err != nil {
+		return err
+	}
+	services := []string{}
+	moved := make(map[string]int)
+	for _, port := range planned {
+		if port.Project != project.Name {
+			continue
+		}
+		published, ok := remapped[port]
+		if ok {
+			moved[port.Service+" "+port.spec()] = published
+		}
+		if (ok || shift != 0) && !SliceContainsString(services, port.Service) {
+			services = append(services, port.Service)
+		}
+	}
+	ports := make(map[string][]string)
+	if len(services) > 0 {
+		all, err := servicePorts(appConfig, project)
+		if err != nil {
+			return err
+		}
+		for _, port := range all {
+			if !SliceContainsString(services, port.Service) {
+				continue
+			}
+			spec := *port
+			if published, ok := moved[port.Service+" "+port.spec()]; ok {
+				spec.Published = published
+			}
+			ports[port.Service] = append(ports[port.Service], strconv.Quote(spec.spec()))
+		}
+	}
+
+	fs := appConfig.GetFs()
+	filename := portsOverrideFilename(appConfig, project)
+	if len(services) == 0 {
+		if err := fs.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to remove the remapped ports")
+		}
+		return nil
+	}
+
+	// the ports of a service in the files compose merges are combined, so
+	// they are replaced with the !override tag
+	var b strings.Builder
+	b.WriteString("# Generated by dev to remap conflicting ports, do not edit.\nservices:\n")
+	sort.Strings(services)
+	for _, service := range services {
+		fmt.Fprintf(&b, "  %s:\n    ports: !override\n", service)
+		for _, spec := range ports[service] {
+			fmt.Fprintf(&b, "      - %s\n", spec)
+		}
+	}
+	if err := fs.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return errors.Wrap(err, "failed to write the remapped ports")
+	}
+	if err := afero.WriteFile(fs, filename, []byte(b.String()), 0644); err != nil {
+		return errors.Wrap(err, "failed to write the remapped ports")
+	}
+	return nil
+}
+
+// remappedPortsFile returns the compose file with the remapped ports of the
+// project if there is one.
+func remappedPortsFile(appConfig *c.Dev, project *c.Project) (string, bool) {
+	filename := portsOverrideFilename(appConfig, project)
+	if _, err := appConfig.GetFs().Stat(filename); err != nil {
+		return "", false
+	}
+	return filename, true
+}
@@ -0,0 +1,181 @@
+package dev
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/spf13/afero"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"gotest.tools/v3/env"
+)
+
+func portsTestConfig(t *testing.T) (*c.Dev, []*c.Project, *docker.FakeEngine) {
+	projects := []*c.Project{
+		{Name: "app", Directory: "/home/app", DockerComposeFilenames: []string{"/home/app/docker-compose.yml"}},
+		{Name: "api", Directory: "/home/api", DockerComposeFilenames: []string{"/home/api/docker-compose.yml"}},
+	}
+	appConfig, engine := testConfig(t, map[string]string{
+		"/home/app/.env": "ADMIN_PORT=8081\n",
+		"/home/app/docker-compose.yml": `version: "3.6"
+services:
+  web:
+    image: nginx
+    ports:
+      - "8080:80"
+      - "8443:443"
+      - "9000"
+      - "${ADMIN_PORT}:8000"
+  db:
+    image: postgres
+    ports:
+      - "127.0.0.1:5432:5432"
+  dns:
+    image: coredns
+    ports:
+      - target: 53
+        published: 5353
+        protocol: udp
+      - "${DNS_PORT}:53"
+  debug:
+    image: debugger
+    profiles:
+      - debug
+    ports:
+      - "5432:5432"
+`,
+		"/home/api/docker-compose.yml": `version: "3.6"
+services:
+  api:
+    image: api
+    ports:
+      - 8080:8000
+`,
+	}, projects...)
+	return appConfig, projects, engine
+}
+
+func TestProjectPorts(t *testing.T) {
+	appConfig, projects, _ := portsTestConfig(t)
+	ports, err := ProjectPorts(appConfig, projects[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, port := range ports {
+		got = append(got, port.Service+" "+port.spec())
+	}
+	// the ports are interpolated and those of disabled services left out
+	expected := []string{"db 127.0.0.1:5432:5432/tcp", "dns 5353:53/udp", "web 8080:80/tcp", "web 8443:443/tcp",
+		"web 8081:8000/tcp"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected ports %v, got %v", expected, got)
+	}
+}
+
+func TestPreparePorts(t *testing.T) {
+	defer env.Patch(t, "DEV_STATE_HOME", "/state")()
+	appConfig, projects, engine := portsTestConfig(t)
+
+	// 5432 is used on the host, 8443 by a container of another compose
+	// project and 5353 by a running container of the app project itself
+	defer func(available func(string, string, int) bool) { portAvailable = available }(portAvailable)
+	portAvailable = func(protocol, hostIP string, port int) bool {
+		return port != 5432
+	}
+	engine.AddContainer(types.Container{ID: "other", Names: []string{"/other_proxy_1"}, State: "running",
+		Labels: map[string]string{docker.ComposeProjectLabel: "other", docker.ComposeServiceLabel: "proxy"},
+		Ports:  []types.Port{{IP: "0.0.0.0", PublicPort: 8443, PrivatePort: 443, Type: "tcp"}}})
+	engine.AddContainer(types.Container{ID: "dns", Names: []string{"/dev_dns_1"}, State: "running",
+		Labels: map[string]string{docker.ComposeProjectLabel: "dev", docker.ComposeServiceLabel: "dns"},
+		Ports:  []types.Port{{IP: "0.0.0.0", PublicPort: 5353, PrivatePort: 53, Type: "udp"}}})
+
+	conflicts, err := PreparePorts(appConfig, projects, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, conflict := range conflicts {
+		got = append(got, conflict.String())
+	}
+	expected := []string{
+		"port 5432/tcp of service db of project app is used by another process on the host",
+		"port 8443/tcp of service web of project app is used by service proxy of compose project other",
+		"port 8080/tcp of service api of project api is used by service web of project app",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected conflicts %v, got %v", expected, got)
+	}
+	if files, err := ComposeFiles(appConfig, projects[0]); err != nil || len(files) != 1 {
+		t.Errorf("Expected no override without an offset, got %v", files)
+	}
+
+	conflicts, err = PreparePorts(appConfig, projects, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("Expected the conflicts to be resolved, got %v", conflicts)
+	}
+	files, err := ComposeFiles(appConfig, projects[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected the ports override, got %v", files)
+	}
+	b, err := afero.ReadFile(appConfig.GetFs(), files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`  db:
+    ports: !override
+      - "127.0.0.1:6432:5432/tcp"
+`,
+		`  web:
+    ports: !override
+      - "8080:80/tcp"
+      - "9443:443/tcp"
+      - "9000/tcp"
+      - "8081:8000/tcp"
+`,
+	} {
+		if !strings.Contains(string(b), expected) {
+			t.Errorf("Expected override to contain %q, got %q", expected, string(b))
+		}
+	}
+	if strings.Contains(string(b), "dns") {
+		t.Errorf("Expected services without remapped ports not to be overridden, got %q", string(b))
+	}
+	if files, err = ComposeFiles(appConfig, projects[1]); err != nil {
+		t.Fatal(err)
+	}
+	b, err = afero.ReadFile(appConfig.GetFs(), files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `- "9080:8000/tcp"`) {
+		t.Errorf("Expected the port of api to be remapped, got %q", string(b))
+	}
+
+	// once the ports are free the ports of the projects are used again
+	for _, id := range []string{"other", "dns"} {
+		if err := engine.ContainerRemove(id, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	portAvailable = func(protocol, hostIP string, port int) bool { return true }
+	conflicts, err = PreparePorts(appConfig, projects[:1], 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts, got %v", conflicts)
+	}
+	if files, err := ComposeFiles(appConfig, projects[0]); err != nil || len(files) != 1 {
+		t.Errorf("Expected the ports override to be removed, got %v", files)
+	}
+}
@@ -1,10 +1,12 @@
 package dev
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 
+	"github.com/mattn/go-isatty"
 	log "github.com/sirupsen/logrus"
 	c "github.com/wish/dev/config"
 	"github.com/wish/dev/docker"
@@ -49,28 +51,47 @@ func (p *Project) GetName() string {
 	return p.Name
 }
 
-// Up brings up the specified project container with its dependencies.
+// Up brings up the specified project container with its dependencies, with the
+// images pinned by the lock file.
 func (p *Project) Up(appConfig *c.Dev) {
-	RunComposeUp(appConfig.ImagePrefix, p.Config.DockerComposeFilenames, "-d", "--no-build")
+	files, err := ComposeFiles(appConfig, p.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	RunComposeUp(appConfig.ImagePrefix, ProjectProfiles(p.Config), files, "-d", "--no-build")
 }
 
 // UpFollowProjectLogs brings up the specified project with its dependencies
 // and tails the logs of the project container.
 func (p *Project) UpFollowProjectLogs(appConfig *c.Dev) {
 	p.Up(appConfig)
-	RunComposeLogs(appConfig.ImagePrefix, p.Config.DockerComposeFilenames, "-f", p.Config.Name)
+	RunComposeLogs(appConfig.ImagePrefix, ProjectProfiles(p.Config), p.Config.DockerComposeFilenames, "-f", p.Config.Name)
 }
 
-// Shell runs commands or creates an interfactive shell on the Project
-// container.
-func (p *Project) Shell(appConfig *c.Dev, args []string) {
-	running, err := docker.IsContainerRunning(p.Config.Name)
+// shellContainer returns the name of the running project container, the
+// container of the compose service with the same name as the project. An empty
+// string is returned if it is not running.
+func (p *Project) shellContainer(appConfig *c.Dev) string {
+	container, err := docker.ServiceContainer(appConfig.GetEngine(), appConfig.ImagePrefix, p.Config.Name)
 	if err != nil {
 		log.Fatalf("Error communicating with docker daemon, is it up? %s", err)
 	}
-	if !running {
+	if container == nil {
+		return ""
+	}
+	return docker.ContainerName(container)
+}
+
+// Shell runs commands or creates an interfactive shell on the Project
+// container.
+func (p *Project) Shell(appConfig *c.Dev, args []string) {
+	containerName := p.shellContainer(appConfig)
+	if containerName == "" {
 		log.Infof("Project %s not running, bringing it up", p.Config.Name)
 		p.Up(appConfig)
+		if containerName = p.shellContainer(appConfig); containerName == "" {
+			log.Fatalf("No running container found for the %s service", p.Config.Name)
+		}
 	}
 
 	// Get current directory, attempt to find its location
@@ -101,5 +122,17 @@ func (p *Project) Shell(appConfig *c.Dev, args []string) {
 	cmdLine := []string{p.Config.Shell, "-c",
 		fmt.Sprintf("cd %s ; %s", relativePath, strings.Join(args, " "))}
 
-	RunOnContainer(p.Name, cmdLine...)
+	if isatty.IsTerminal(os.Stdout.Fd()) {
+		RunOnContainer(containerName, cmdLine...)
+		return
+	}
+	// without a terminal the commands run through the engine, as the cli
+	// would, without stdin
+	code, err := appConfig.GetEngine().ContainerExec(context.Background(), containerName, cmdLine, os.Stdout, os.Stderr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
 }
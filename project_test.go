@@ -0,0 +1,59 @@
+package dev
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+)
+
+func TestShellContainer(t *testing.T) {
+	engine := docker.NewFakeEngine()
+	appConfig := c.NewConfig()
+	appConfig.ImagePrefix = "dev"
+	appConfig.SetEngine(engine)
+	project := NewProject(&c.Project{Name: "app"})
+
+	if name := project.shellContainer(appConfig); name != "" {
+		t.Errorf("Expected no container without running containers but got %s", name)
+	}
+
+	engine.AddContainer(types.Container{
+		Names:  []string{"/other-app-1"},
+		State:  "running",
+		Labels: map[string]string{docker.ComposeProjectLabel: "other", docker.ComposeServiceLabel: "app"},
+	})
+	if name := project.shellContainer(appConfig); name != "" {
+		t.Errorf("Expected container of another compose project to be ignored but got %s", name)
+	}
+
+	engine.AddContainer(types.Container{
+		Names:  []string{"/dev-app-1"},
+		State:  "running",
+		Labels: map[string]string{docker.ComposeProjectLabel: "dev", docker.ComposeServiceLabel: "app"},
+	})
+	if name := project.shellContainer(appConfig); name != "dev-app-1" {
+		t.Errorf("Expected container dev-app-1 but got %s", name)
+	}
+}
+
+func TestShell(t *testing.T) {
+	appConfig, engine := testConfig(t, map[string]string{})
+	project := NewProject(&c.Project{Name: "app", Directory: "/home/test", Shell: "/bin/bash"})
+	engine.AddContainer(types.Container{
+		ID:     "app",
+		Names:  []string{"/dev-app-1"},
+		State:  "running",
+		Labels: map[string]string{docker.ComposeProjectLabel: "dev", docker.ComposeServiceLabel: "app"},
+	})
+
+	// the tests do not run in a terminal, so the commands run through the
+	// engine
+	project.Shell(appConfig, []string{"ls", "-al"})
+	expected := []docker.FakeExec{{ContainerID: "app", Cmd: []string{"/bin/bash", "-c", "cd  ; ls -al"}}}
+	if !reflect.DeepEqual(engine.Execs, expected) {
+		t.Errorf("Expected commands %+v but got %+v", expected, engine.Execs)
+	}
+}
@@ -23,7 +23,7 @@ func NewRegistry(config *c.Registry) *Registry {
 
 // PreRun implements the Dependency interface.
 func (r *Registry) PreRun(command string, appConfig *c.Dev, project *Project) {
-	if !SliceContainsString([]string{BUILD, UP}, command) {
+	if !SliceContainsString([]string{BUILD, DOWNLOAD, LOCK, OUTDATED, SAVE, UP}, command) {
 		return
 	}
 
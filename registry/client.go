@@ -0,0 +1,292 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// the media types of the manifests the client accepts, image indexes first
+const (
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	mediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+)
+
+var acceptedManifests = []string{mediaTypeManifestList, mediaTypeOCIIndex, mediaTypeManifest, mediaTypeOCIManifest}
+
+// Client reads the images of a registry with the registry HTTP API. It
+// authenticates with basic auth or, if the registry requires it, with a bearer
+// token requested with the credentials.
+type Client struct {
+	// URL is the base URL of the registry.
+	URL      string
+	Username string
+	Password string
+
+	client *http.Client
+	// tokens are the bearer tokens obtained, by scope.
+	tokens map[string]string
+}
+
+// RemoteImage is the state of an image tag in a registry.
+type RemoteImage struct {
+	// Digest is the digest of the manifest the tag refers to, as reported
+	// by docker in the repo digests of the image once pulled.
+	Digest string
+	// Created is when the image of the current platform was built.
+	Created time.Time
+}
+
+// NewClient returns a client for the registry at URL. Registries without a
+// scheme are reached over https, but for those on localhost, which docker
+// also treats as insecure.
+func NewClient(URL, username, password string, timeout time.Duration) *Client {
+	if !strings.Contains(URL, "://") {
+		host := registryHost(URL)
+		scheme := "https://"
+		if strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.") {
+			scheme = "http://"
+		}
+		URL = scheme + URL
+	}
+	return &Client{
+		URL:      strings.TrimRight(URL, "/"),
+		Username: username,
+		Password: password,
+		client:   &http.Client{Timeout: timeout},
+		tokens:   make(map[string]string),
+	}
+}
+
+// token requests a bearer token from the authorization service named in the
+// challenge of the registry.
+func (c *Client) token(challenge string) (string, error) {
+	params := make(map[string]string)
+	for _, param := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		if i := strings.Index(param, "="); i > 0 {
+			params[strings.TrimSpace(param[:i])] = strings.Trim(strings.TrimSpace(param[i+1:]), `"`)
+		}
+	}
+	if token, ok := c.tokens[params["scope"]]; ok {
+		return token, nil
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", errors.Errorf("invalid authentication challenge %q", challenge)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to request a registry token")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("failed to request a registry token: %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", errors.Wrap(err, "failed to read the registry token")
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	c.tokens[params["scope"]] = token
+	return token, nil
+}
+
+// get requests the path of the registry API, authenticating when challenged.
+// The caller closes the body of the response.
+func (c *Client) get(path string, accept ...string) (*http.Response, error) {
+	do := func(authorize func(req *http.Request)) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, c.URL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(accept) > 0 {
+			req.Header.Set("Accept", strings.Join(accept, ", "))
+		}
+		if authorize != nil {
+			authorize(req)
+		}
+		return c.client.Do(req)
+	}
+
+	resp, err := do(nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to reach %s", c.URL)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		switch {
+		case strings.HasPrefix(challenge, "Bearer "):
+			token, err := c.token(challenge)
+			if err != nil {
+				return nil, err
+			}
+			resp, err = do(func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+token)
+			})
+		case c.Username != "":
+			resp, err = do(func(req *http.Request) {
+				req.SetBasicAuth(c.Username, c.Password)
+			})
+		default:
+			return nil, errors.Errorf("%s requires credentials", c.URL)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to reach %s", c.URL)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("GET %s: %s", c.URL+path, resp.Status)
+	}
+	return resp, nil
+}
+
+// manifest is the subset of image manifests and indexes the client reads.
+type manifest struct {
+	MediaType string `json:"mediaType"`
+	Config    struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// getManifest returns the manifest of the reference, a tag or digest, and its
+// digest.
+func (c *Client) getManifest(repository, reference string) (*manifest, string, error) {
+	resp, err := c.get("/v2/"+repository+"/manifests/"+reference, acceptedManifests...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to read the manifest of %s:%s", repository, reference)
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, "", errors.Wrapf(err, "failed to parse the manifest of %s:%s", repository, reference)
+	}
+	if m.MediaType == "" {
+		m.MediaType = resp.Header.Get("Content-Type")
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+	}
+	return m, digest, nil
+}
+
+// Image returns the digest of the tag of the repository and when its image
+// for the current platform was created.
+func (c *Client) Image(repository, tag string) (*RemoteImage, error) {
+	m, digest, err := c.getManifest(repository, tag)
+	if err != nil {
+		return nil, err
+	}
+	image := &RemoteImage{Digest: digest}
+
+	if m.MediaType == mediaTypeManifestList || m.MediaType == mediaTypeOCIIndex {
+		if len(m.Manifests) == 0 {
+			return nil, errors.Errorf("%s:%s has no images", repository, tag)
+		}
+		platform := m.Manifests[0].Digest
+		for _, candidate := range m.Manifests {
+			if candidate.Platform.OS == runtime.GOOS && candidate.Platform.Architecture == runtime.GOARCH {
+				platform = candidate.Digest
+				break
+			}
+		}
+		if m, _, err = c.getManifest(repository, platform); err != nil {
+			return nil, err
+		}
+	}
+	if m.Config.Digest == "" {
+		return nil, errors.Errorf("the manifest of %s:%s has no image configuration", repository, tag)
+	}
+
+	resp, err := c.get("/v2/" + repository + "/blobs/" + m.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var config struct {
+		Created time.Time `json:"created"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&config); err != nil {
+		return nil, errors.Wrapf(err, "failed to read the image configuration of %s:%s", repository, tag)
+	}
+	image.Created = config.Created
+	return image, nil
+}
+
+// DockerHub is the host of the registry of images whose reference does not
+// name one.
+const DockerHub = "registry-1.docker.io"
+
+// ParseReference splits the image reference into the host of its registry, its
+// repository and its tag, "latest" if it has none. The digest of references
+// pinned to one is returned instead of the tag.
+func ParseReference(ref string) (host, repository, tag string) {
+	repository, tag = ref, "latest"
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository, tag = repository[:i], repository[i+1:]
+	} else if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, tag = repository[:i], repository[i+1:]
+	}
+
+	host = DockerHub
+	if i := strings.Index(repository, "/"); i >= 0 {
+		first := repository[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			host, repository = first, repository[i+1:]
+		}
+	}
+	if host == "docker.io" || host == "index.docker.io" {
+		host = DockerHub
+	}
+	if host == DockerHub {
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+	return host, repository, tag
+}
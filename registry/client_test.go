@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRegistry returns a stand-in for a registry:2 serving the tag
+// app:current as an index of the image of the current platform, created at
+// created. The registry requires a bearer token, issued to the user test.
+func newTestRegistry(t *testing.T, created time.Time) *httptest.Server {
+	index := fmt.Sprintf(`{"mediaType":%q,"manifests":[
+		{"digest":"sha256:other","platform":{"os":"windows","architecture":"arm"}},
+		{"digest":"sha256:platform","platform":{"os":%q,"architecture":%q}}]}`,
+		mediaTypeManifestList, runtime.GOOS, runtime.GOARCH)
+	image := fmt.Sprintf(`{"mediaType":%q,"config":{"digest":"sha256:config"}}`, mediaTypeManifest)
+	config := fmt.Sprintf(`{"created":%q}`, created.Format(time.RFC3339Nano))
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, password, ok := r.BasicAuth(); !ok || user != "test" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("scope") != "repository:dev/app:pull" {
+				t.Errorf("Unexpected token scope %s", r.URL.Query().Get("scope"))
+			}
+			fmt.Fprint(w, `{"token":"t0ken"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s/token",service="registry",scope="repository:dev/app:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/dev/app/manifests/current":
+			if !strings.Contains(r.Header.Get("Accept"), mediaTypeManifestList) {
+				w.Header().Set("Content-Type", mediaTypeManifest)
+				fmt.Fprint(w, image)
+				return
+			}
+			w.Header().Set("Content-Type", mediaTypeManifestList)
+			w.Header().Set("Docker-Content-Digest", "sha256:index")
+			fmt.Fprint(w, index)
+		case "/v2/dev/app/manifests/sha256:platform":
+			w.Header().Set("Content-Type", mediaTypeManifest)
+			fmt.Fprint(w, image)
+		case "/v2/dev/app/blobs/sha256:config":
+			fmt.Fprint(w, config)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func TestClientImage(t *testing.T) {
+	created := time.Date(2020, 5, 4, 12, 0, 0, 0, time.UTC)
+	server := newTestRegistry(t, created)
+	defer server.Close()
+
+	client := NewClient(server.URL, "test", "secret", time.Second)
+	image, err := client.Image("dev/app", "current")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Digest != "sha256:index" || !image.Created.Equal(created) {
+		t.Errorf("Unexpected image %+v", image)
+	}
+	if _, err := client.Image("dev/app", "missing"); err == nil {
+		t.Errorf("Expected an error for a tag that does not exist")
+	}
+
+	client = NewClient(server.URL, "test", "wrong", time.Second)
+	if _, err := client.Image("dev/app", "current"); err == nil {
+		t.Errorf("Expected an error with the wrong credentials")
+	}
+}
+
+func TestNewClientScheme(t *testing.T) {
+	tests := []struct {
+		URL      string
+		Expected string
+	}{
+		{"localhost:5000", "http://localhost:5000"},
+		{"127.0.0.1:5000/", "http://127.0.0.1:5000"},
+		{"ecr.example.com", "https://ecr.example.com"},
+		{"http://registry.internal/", "http://registry.internal"},
+	}
+	for _, test := range tests {
+		if got := NewClient(test.URL, "", "", time.Second).URL; got != test.Expected {
+			t.Errorf("Expected %s for %s but got %s", test.Expected, test.URL, got)
+		}
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		Ref                   string
+		Host, Repository, Tag string
+	}{
+		{"postgres", DockerHub, "library/postgres", "latest"},
+		{"bitnami/redis:6", DockerHub, "bitnami/redis", "6"},
+		{"docker.io/library/postgres:13", DockerHub, "library/postgres", "13"},
+		{"localhost:5000/dev/app:current", "localhost:5000", "dev/app", "current"},
+		{"ecr.example.com/app@sha256:abc", "ecr.example.com", "app", "sha256:abc"},
+	}
+	for _, test := range tests {
+		host, repository, tag := ParseReference(test.Ref)
+		if host != test.Host || repository != test.Repository || tag != test.Tag {
+			t.Errorf("Expected %s %s %s for %s but got %s %s %s", test.Host, test.Repository, test.Tag,
+				test.Ref, host, repository, tag)
+		}
+	}
+}
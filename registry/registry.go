@@ -3,9 +3,16 @@ package registry
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wish/dev/docker"
 )
 
 // Login attempts to perform a user/password login to the registry provided.
@@ -13,10 +20,52 @@ import (
 func Login(URL, username, password string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
 	defer cancel()
-	command := exec.CommandContext(ctx, "docker", "login", URL,
+	command := exec.CommandContext(ctx, docker.CurrentRuntime().CLI, "login", URL,
 		"--username", username, "--password-stdin")
 	command.Stdin = bytes.NewBuffer([]byte(password))
 	command.Stdout = os.Stdout
 	command.Stderr = os.Stderr
 	return command.Run()
 }
+
+// registryHost returns the host portion of a registry URL, which is how
+// docker keys the credentials it stores.
+func registryHost(URL string) string {
+	if !strings.Contains(URL, "://") {
+		URL = "https://" + URL
+	}
+	u, err := url.Parse(URL)
+	if err != nil {
+		return URL
+	}
+	return u.Host
+}
+
+// LoggedIn reports whether the container runtime has credentials stored for
+// the registry at URL, i.e., whether a login to it has been performed.
+func LoggedIn(URL string) (bool, error) {
+	b, err := ioutil.ReadFile(docker.CurrentRuntime().AuthFile())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read docker config")
+	}
+
+	var dockerConfig struct {
+		Auths       map[string]json.RawMessage `json:"auths"`
+		CredHelpers map[string]string          `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(b, &dockerConfig); err != nil {
+		return false, errors.Wrap(err, "failed to parse docker config")
+	}
+
+	host := registryHost(URL)
+	for key := range dockerConfig.Auths {
+		if registryHost(key) == host {
+			return true, nil
+		}
+	}
+	_, ok := dockerConfig.CredHelpers[host]
+	return ok, nil
+}
@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/env"
+)
+
+const dockerConfig = `{
+	"auths": {
+		"https://index.docker.io/v1/": {},
+		"aws.ecr.my-region": {"auth": "Zm9vOmJhcg=="}
+	},
+	"credHelpers": {
+		"gcr.io": "gcloud"
+	}
+}`
+
+func TestLoggedIn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dev-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer env.Patch(t, "DOCKER_CONFIG", dir)()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(dockerConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		URL      string
+		Expected bool
+	}{
+		{"https://aws.ecr.my-region", true},
+		{"aws.ecr.my-region", true},
+		{"https://index.docker.io/v1/", true},
+		{"https://gcr.io", true},
+		{"https://my-registry.personal.com", false},
+	}
+
+	for _, test := range tests {
+		loggedIn, err := LoggedIn(test.URL)
+		if err != nil {
+			t.Errorf("Unexpected error checking login state of %s: %s", test.URL, err)
+		}
+		if loggedIn != test.Expected {
+			t.Errorf("Expected LoggedIn(%s) to be %t but got %t", test.URL, test.Expected, loggedIn)
+		}
+	}
+}
+
+func TestLoggedInWithoutDockerConfig(t *testing.T) {
+	defer env.Patch(t, "DOCKER_CONFIG", "/does/not/exist")()
+
+	loggedIn, err := LoggedIn("https://aws.ecr.my-region")
+	if err != nil {
+		t.Errorf("Unexpected error without a docker config: %s", err)
+	}
+	if loggedIn {
+		t.Error("Expected not to be logged in without a docker config")
+	}
+}
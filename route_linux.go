@@ -0,0 +1,17 @@
+package dev
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// HostRoutes returns the IPv4 routing table of the host.
+func HostRoutes() ([]Route, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read routing table")
+	}
+	defer f.Close()
+	return parseRoutes(f)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package dev
+
+// HostRoutes returns the IPv4 routing table of the host. Reading the routing
+// table is only supported on linux, where docker networks share the host
+// network stack. Elsewhere docker runs in a virtual machine and no routes are
+// returned.
+func HostRoutes() ([]Route, error) {
+	return []Route{}, nil
+}
@@ -1,26 +1,86 @@
 package dev
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/cli/cli/compose/types"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/wish/dev/compose"
 	"github.com/wish/dev/config"
 )
 
-// CreateBuildableServiceList creates a list of buildable services in the projects docker-compose files.
+// parseCompose parses the docker-compose files of the project together, as
+// compose does. The project directory of compose is the directory of the
+// first file.
+func parseCompose(devConfig *config.Dev, project *config.Project) (*types.Config, error) {
+	if len(project.DockerComposeFilenames) == 0 {
+		return &types.Config{}, nil
+	}
+	composeConfig, err := compose.ParseFiles(devConfig.GetFs(),
+		filepath.Dir(project.DockerComposeFilenames[0]), project.DockerComposeFilenames)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse docker-compose appConfig file")
+	}
+	return composeConfig, nil
+}
+
+// ProjectProfiles returns the compose profiles enabled for the project: those
+// of its configuration and of the COMPOSE_PROFILES environment variable, which
+// compose ignores when profiles are passed to it.
+func ProjectProfiles(project *config.Project) []string {
+	profiles := []string{}
+	for _, profile := range append(project.Profiles, strings.Split(os.Getenv("COMPOSE_PROFILES"), ",")...) {
+		profile = strings.TrimSpace(profile)
+		if profile != "" && !SliceContainsString(profiles, profile) {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// enabledServices returns the services of the project enabled by its
+// profiles.
+func enabledServices(composeConfig *types.Config, project *config.Project) []types.ServiceConfig {
+	profiles := ProjectProfiles(project)
+	services := []types.ServiceConfig{}
+	for _, service := range composeConfig.Services {
+		if compose.Enabled(service, profiles) {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+// CreateBuildableServiceList creates a list of buildable services in the projects docker-compose files
+// that are enabled by the profiles of the project.
 func CreateBuildableServiceList(devConfig *config.Dev, project *config.Project) []string {
 
 	serviceList := []string{}
-	for _, composeFilename := range project.DockerComposeFilenames {
-		composeConfig, err := compose.Parse(devConfig.GetFs(), project.Directory, composeFilename)
-		if err != nil {
-			log.Fatal("Failed to parse docker-compose appConfig file: ", err)
+	composeConfig, err := parseCompose(devConfig, project)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, service := range enabledServices(composeConfig, project) {
+		if service.Build.Context != "" {
+			serviceList = append(serviceList, service.Name)
 		}
+	}
+	return serviceList
+}
 
-		for _, service := range composeConfig.Services {
-			if service.Build.Context != "" {
-				serviceList = append(serviceList, service.Name)
-			}
-		}
+// CreateServiceList creates a list of all the services in the projects
+// docker-compose files.
+func CreateServiceList(devConfig *config.Dev, project *config.Project) []string {
+	serviceList := []string{}
+	composeConfig, err := parseCompose(devConfig, project)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, service := range composeConfig.Services {
+		serviceList = append(serviceList, service.Name)
 	}
 	return serviceList
 }
@@ -5,8 +5,10 @@ import (
 	"github.com/spf13/afero"
 	"github.com/wish/dev"
 	"github.com/wish/dev/cmd"
+	"github.com/wish/dev/config"
 	"github.com/wish/dev/test"
 	"gotest.tools/v3/env"
+	"sort"
 	"testing"
 )
 
@@ -30,3 +32,41 @@ func TestCreateBuildableServiceList(t *testing.T) {
 		t.Errorf("CreateBuildableServiceList() mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestCreateBuildableServiceListProfiles(t *testing.T) {
+	defer env.Patch(t, "COMPOSE_PROFILES", "")()
+	appConfig := config.NewConfig()
+	appConfig.SetFs(afero.NewMemMapFs())
+	test.CreateConfigFile(appConfig.GetFs(), `services:
+  app:
+    build: .
+  debug:
+    build: ./debug
+    profiles: ["debug"]
+  mock:
+    build: ./mock
+    profiles: ["mocks", "test"]
+`, "/home/test/docker-compose.yml")
+	project := &config.Project{Name: "app", DockerComposeFilenames: []string{"/home/test/docker-compose.yml"}}
+
+	tests := []struct {
+		Profiles    []string
+		EnvProfiles string
+		Want        []string
+	}{
+		{nil, "", []string{"app"}},
+		{[]string{"debug"}, "", []string{"app", "debug"}},
+		{nil, "test", []string{"app", "mock"}},
+		{[]string{"*"}, "", []string{"app", "debug", "mock"}},
+	}
+	for _, tc := range tests {
+		defer env.Patch(t, "COMPOSE_PROFILES", tc.EnvProfiles)()
+		project.Profiles = tc.Profiles
+		got := dev.CreateBuildableServiceList(appConfig, project)
+		sort.Strings(got)
+		if diff := cmp.Diff(tc.Want, got); diff != "" {
+			t.Errorf("CreateBuildableServiceList() with profiles %v %q mismatch (-want +got):\n%s",
+				tc.Profiles, tc.EnvProfiles, diff)
+		}
+	}
+}
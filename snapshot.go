@@ -0,0 +1,376 @@
+package dev
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"github.com/wish/dev/state"
+)
+
+const (
+	// snapshotImage is the image of the containers that archive and
+	// extract the volumes.
+	snapshotImage = "alpine:3"
+	// snapshotMetadataName is the name of the metadata file of a
+	// snapshot.
+	snapshotMetadataName = "snapshot.json"
+)
+
+// validSnapshotName matches the names snapshots can be given, which are
+// directory names.
+var validSnapshotName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// SnapshotVolume is a named volume of a project.
+type SnapshotVolume struct {
+	// Name of the volume in the compose files.
+	Name string `json:"name"`
+	// Volume is the name of the docker volume.
+	Volume string `json:"volume"`
+	// Services that mount the volume.
+	Services []string `json:"services"`
+}
+
+// archive returns the name of the archive of the volume in a snapshot.
+func (v *SnapshotVolume) archive() string {
+	return v.Name + ".tar.gz"
+}
+
+// Snapshot is the metadata of a snapshot of the volumes of a project.
+type Snapshot struct {
+	Name    string            `json:"name"`
+	Project string            `json:"project"`
+	Created time.Time         `json:"created"`
+	Volumes []*SnapshotVolume `json:"volumes"`
+	// Services are the services that mount the volumes.
+	Services []string `json:"services"`
+	// Images are the digests of the images the containers of the
+	// services ran when the snapshot was taken, by service. Images built
+	// locally have no digest and are recorded by id.
+	Images map[string]string `json:"images,omitempty"`
+	// Size of the archives of the volumes, set when listed.
+	Size int64 `json:"-"`
+}
+
+// snapshotDirectory returns the directory the snapshots of the project are
+// stored in.
+func snapshotDirectory(appConfig *c.Dev, project *c.Project) string {
+	return filepath.Join(state.Directory(appConfig.ImagePrefix), "snapshots", project.Name)
+}
+
+// ProjectVolumes returns the named volumes declared in the compose files of
+// the project, with the services that mount them. External volumes are not
+// managed by the project and are not returned.
+func ProjectVolumes(appConfig *c.Dev, project *c.Project) ([]*SnapshotVolume, error) {
+	volumes := make(map[string]*SnapshotVolume)
+	composeConfig, err := parseCompose(appConfig, project)
+	if err != nil {
+		return nil, err
+	}
+	for name, config := range composeConfig.Volumes {
+		if config.External.External {
+			log.Debugf("Not including external volume %s of %s", name, project.Name)
+			continue
+		}
+		volume := config.Name
+		if volume == "" {
+			volume = appConfig.ImagePrefix + "_" + name
+		}
+		volumes[name] = &SnapshotVolume{Name: name, Volume: volume}
+	}
+	for _, service := range composeConfig.Services {
+		for _, mount := range service.Volumes {
+			if volume, ok := volumes[mount.Source]; ok && mount.Type == "volume" {
+				volume.Services = append(volume.Services, service.Name)
+			}
+		}
+	}
+
+	result := make([]*SnapshotVolume, 0, len(volumes))
+	for _, volume := range volumes {
+		sort.Strings(volume.Services)
+		result = append(result, volume)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// snapshotServices returns the services that mount the volumes.
+func snapshotServices(volumes []*SnapshotVolume) []string {
+	services := []string{}
+	for _, volume := range volumes {
+		for _, service := range volume.Services {
+			if !SliceContainsString(services, service) {
+				services = append(services, service)
+			}
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// imageDigest returns the digest of the image, or its id if it has none.
+func imageDigest(engine docker.Engine, imageID string) string {
+	info, err := engine.ImageInspect(imageID)
+	if err != nil || len(info.RepoDigests) == 0 {
+		return imageID
+	}
+	return info.RepoDigests[0]
+}
+
+// withServicesStopped stops the running containers of the services while fn
+// runs, so that the data of their volumes is consistent, and starts them again
+// afterwards. The digests of the images of the containers are returned by
+// service.
+func withServicesStopped(appConfig *c.Dev, project *c.Project, services []string, fn func() error) (map[string]string, error) {
+	engine := appConfig.GetEngine()
+	containers, err := docker.ComposeContainers(engine, appConfig.ImagePrefix, services)
+	if err != nil {
+		return nil, err
+	}
+	images := make(map[string]string)
+	running := []string{}
+	for _, container := range containers {
+		service := container.Labels[docker.ComposeServiceLabel]
+		images[service] = imageDigest(engine, container.ImageID)
+		if container.State == "running" && !SliceContainsString(running, service) {
+			running = append(running, service)
+		}
+	}
+	sort.Strings(running)
+
+	files, err := ComposeFiles(appConfig, project)
+	if err != nil {
+		return nil, err
+	}
+	if len(running) > 0 {
+		log.Infof("Stopping %s", strings.Join(running, ", "))
+		if err := RunComposeStop(appConfig.ImagePrefix, ProjectProfiles(project), files, running...); err != nil {
+			return nil, errors.Wrapf(err, "failed to stop %s", strings.Join(running, ", "))
+		}
+	}
+	fnErr := fn()
+	if len(running) > 0 {
+		log.Infof("Starting %s", strings.Join(running, ", "))
+		if err := RunComposeStart(appConfig.ImagePrefix, ProjectProfiles(project), files, running...); err != nil {
+			if fnErr == nil {
+				fnErr = errors.Wrapf(err, "failed to start %s", strings.Join(running, ", "))
+			} else {
+				log.Warnf("Failed to start %s: %s", strings.Join(running, ", "), err)
+			}
+		}
+	}
+	return images, fnErr
+}
+
+// SaveSnapshot archives the named volumes of the project into a snapshot with
+// the name, stopping the services that mount them while they are archived. An
+// existing snapshot is only replaced with force. Volumes that do not exist yet
+// are left out, rather than created empty.
+func SaveSnapshot(appConfig *c.Dev, project *c.Project, name string, force bool) (*Snapshot, error) {
+	if !validSnapshotName.MatchString(name) {
+		return nil, errors.Errorf("invalid snapshot name %q", name)
+	}
+	volumes, err := ProjectVolumes(appConfig, project)
+	if err != nil {
+		return nil, err
+	}
+	if len(volumes) == 0 {
+		return nil, errors.Errorf("%s does not declare any named volumes", project.Name)
+	}
+	existing, err := appConfig.GetEngine().VolumeList()
+	if err != nil {
+		return nil, err
+	}
+	created := make(map[string]bool, len(existing))
+	for _, volume := range existing {
+		created[volume.Name] = true
+	}
+	present := []*SnapshotVolume{}
+	for _, volume := range volumes {
+		if !created[volume.Volume] {
+			log.Warnf("Not including volume %s, which does not exist", volume.Volume)
+			continue
+		}
+		present = append(present, volume)
+	}
+	if len(present) == 0 {
+		return nil, errors.Errorf("none of the volumes of %s exist, run 'dev %s up' first", project.Name,
+			project.Name)
+	}
+	volumes = present
+
+	fs := appConfig.GetFs()
+	dir := filepath.Join(snapshotDirectory(appConfig, project), name)
+	if _, err := fs.Stat(dir); err == nil {
+		if !force {
+			return nil, errors.Errorf("snapshot %s of %s already exists", name, project.Name)
+		}
+		if err := fs.RemoveAll(dir); err != nil {
+			return nil, errors.Wrapf(err, "failed to remove snapshot %s", name)
+		}
+	}
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s", dir)
+	}
+
+	snapshot := &Snapshot{
+		Name:     name,
+		Project:  project.Name,
+		Volumes:  volumes,
+		Services: snapshotServices(volumes),
+	}
+	images, err := withServicesStopped(appConfig, project, snapshot.Services, func() error {
+		for _, volume := range volumes {
+			log.Infof("Archiving volume %s", volume.Volume)
+			if err := RunDockerRun("-v", volume.Volume+":/volume:ro", "-v", dir+":/snapshot", snapshotImage,
+				"tar", "-czf", "/snapshot/"+volume.archive(), "-C", "/volume", "."); err != nil {
+				return errors.Wrapf(err, "failed to archive volume %s", volume.Volume)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fs.RemoveAll(dir)
+		return nil, err
+	}
+	snapshot.Images = images
+	snapshot.Created = time.Now().UTC()
+
+	b, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the snapshot metadata")
+	}
+	if err := afero.WriteFile(fs, filepath.Join(dir, snapshotMetadataName), b, 0644); err != nil {
+		return nil, errors.Wrapf(err, "failed to write the metadata of snapshot %s", name)
+	}
+	return snapshot, nil
+}
+
+// loadSnapshot reads the metadata of the snapshot of the project.
+func loadSnapshot(appConfig *c.Dev, project *c.Project, name string) (*Snapshot, error) {
+	if !validSnapshotName.MatchString(name) {
+		return nil, errors.Errorf("invalid snapshot name %q", name)
+	}
+	filename := filepath.Join(snapshotDirectory(appConfig, project), name, snapshotMetadataName)
+	b, err := afero.ReadFile(appConfig.GetFs(), filename)
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("%s has no snapshot %s", project.Name, name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read snapshot %s", name)
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(b, snapshot); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse snapshot %s", name)
+	}
+	return snapshot, nil
+}
+
+// RestoreSnapshot replaces the content of the volumes of the project with
+// that of the snapshot, stopping the services that mount them while they are
+// restored. It warns when the services run different images than when the
+// snapshot was taken, as their data may no longer be compatible.
+func RestoreSnapshot(appConfig *c.Dev, project *c.Project, name string) (*Snapshot, error) {
+	snapshot, err := loadSnapshot(appConfig, project, name)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(snapshotDirectory(appConfig, project), name)
+	images, err := withServicesStopped(appConfig, project, snapshot.Services, func() error {
+		for _, volume := range snapshot.Volumes {
+			log.Infof("Restoring volume %s", volume.Volume)
+			// hidden files are removed too, but not the . and ..
+			// entries
+			script := "rm -rf /volume/* /volume/.[!.]* /volume/..?* && tar -xzf /snapshot/" + volume.archive() + " -C /volume"
+			if err := RunDockerRun("-v", volume.Volume+":/volume", "-v", dir+":/snapshot:ro", snapshotImage,
+				"sh", "-c", script); err != nil {
+				return errors.Wrapf(err, "failed to restore volume %s", volume.Volume)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range snapshot.Services {
+		if saved, current := snapshot.Images[service], images[service]; saved != "" && current != "" && saved != current {
+			log.Warnf("%s runs a different image than when snapshot %s was taken", service, name)
+		}
+	}
+	return snapshot, nil
+}
+
+// ListSnapshots returns the snapshots of the project, oldest first, with the
+// size of their archives.
+func ListSnapshots(appConfig *c.Dev, project *c.Project) ([]*Snapshot, error) {
+	fs := appConfig.GetFs()
+	dir := snapshotDirectory(appConfig, project)
+	entries, err := afero.ReadDir(fs, dir)
+	if os.IsNotExist(err) {
+		return []*Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", dir)
+	}
+
+	snapshots := []*Snapshot{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		snapshot, err := loadSnapshot(appConfig, project, entry.Name())
+		if err != nil {
+			log.Warnf("Ignoring snapshot %s: %s", entry.Name(), err)
+			continue
+		}
+		for _, volume := range snapshot.Volumes {
+			if info, err := fs.Stat(filepath.Join(dir, entry.Name(), volume.archive())); err == nil {
+				snapshot.Size += info.Size()
+			}
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Created.Before(snapshots[j].Created) })
+	return snapshots, nil
+}
+
+// RemoveSnapshot deletes the snapshot of the project.
+func RemoveSnapshot(appConfig *c.Dev, project *c.Project, name string) error {
+	if _, err := loadSnapshot(appConfig, project, name); err != nil {
+		return err
+	}
+	dir := filepath.Join(snapshotDirectory(appConfig, project), name)
+	if err := appConfig.GetFs().RemoveAll(dir); err != nil {
+		return errors.Wrapf(err, "failed to remove snapshot %s", name)
+	}
+	return nil
+}
+
+// PrintSnapshots writes the snapshots as a table.
+func PrintSnapshots(out io.Writer, snapshots []*Snapshot) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCREATED\tVOLUMES\tSERVICES\tSIZE")
+	for _, snapshot := range snapshots {
+		volumes := []string{}
+		for _, volume := range snapshot.Volumes {
+			volumes = append(volumes, volume.Name)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", snapshot.Name, snapshot.Created.Local().Format("2006-01-02 15:04"),
+			strings.Join(volumes, ","), strings.Join(snapshot.Services, ","), units.HumanSize(float64(snapshot.Size)))
+	}
+	w.Flush()
+}
@@ -0,0 +1,166 @@
+package dev
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"gotest.tools/v3/env"
+)
+
+func snapshotTestConfig(t *testing.T) (*c.Dev, *c.Project, *docker.FakeEngine) {
+	project := &c.Project{
+		Name:                   "app",
+		Directory:              "/home/test",
+		DockerComposeFilenames: []string{"/home/test/docker-compose.yml"},
+	}
+	appConfig, engine := testConfig(t, map[string]string{
+		"/home/test/docker-compose.yml": `version: "3.6"
+services:
+  db:
+    image: postgres
+    volumes:
+      - pgdata:/var/lib/postgresql/data
+      - ./init:/docker-entrypoint-initdb.d
+  cache:
+    image: redis
+    volumes:
+      - type: volume
+        source: cache
+        target: /data
+  app:
+    image: app
+    volumes:
+      - shared:/shared
+volumes:
+  pgdata:
+  cache:
+    name: shared-cache
+  shared:
+    external: true
+`,
+	}, project)
+	labels := func(service string) map[string]string {
+		return map[string]string{docker.ComposeProjectLabel: "dev", docker.ComposeServiceLabel: service}
+	}
+	engine.AddContainer(types.Container{Names: []string{"/dev_db_1"}, State: "running", Labels: labels("db"), ImageID: "sha256:pg"})
+	engine.AddContainer(types.Container{Names: []string{"/dev_cache_1"}, State: "exited", Labels: labels("cache"), ImageID: "sha256:redis"})
+	return appConfig, project, engine
+}
+
+func TestProjectVolumes(t *testing.T) {
+	appConfig, project, _ := snapshotTestConfig(t)
+	volumes, err := ProjectVolumes(appConfig, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*SnapshotVolume{
+		{Name: "cache", Volume: "shared-cache", Services: []string{"cache"}},
+		{Name: "pgdata", Volume: "dev_pgdata", Services: []string{"db"}},
+	}
+	if !reflect.DeepEqual(volumes, expected) {
+		t.Errorf("Expected volumes %+v but got %+v", expected, volumes)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	defer env.Patch(t, "DEV_STATE_HOME", "/state")()
+	defer setExecutor(nil)
+	setup()
+	setExecutor(tc.NewCommand)
+	appConfig, project, engine := snapshotTestConfig(t)
+	fs := appConfig.GetFs()
+
+	if _, err := SaveSnapshot(appConfig, project, "../seed", false); err == nil {
+		t.Errorf("Expected an error for an invalid snapshot name")
+	}
+	if _, err := SaveSnapshot(appConfig, project, "seed", false); err == nil {
+		t.Errorf("Expected an error when none of the volumes exist")
+	}
+
+	// volumes that do not exist are left out rather than created
+	engine.Volumes = []*types.Volume{{Name: "dev_pgdata"}}
+	snapshot, err := SaveSnapshot(appConfig, project, "partial", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Volumes) != 1 || snapshot.Volumes[0].Name != "pgdata" {
+		t.Errorf("Expected only the pgdata volume in the snapshot but got %+v", snapshot.Volumes)
+	}
+	if args := strings.Join(tc.Args, " "); strings.Contains(args, "shared-cache") {
+		t.Errorf("Expected the missing volume not to be mounted: %s", args)
+	}
+	if err := RemoveSnapshot(appConfig, project, "partial"); err != nil {
+		t.Fatal(err)
+	}
+
+	setup()
+	engine.Volumes = append(engine.Volumes, &types.Volume{Name: "shared-cache"})
+	engine.ImageDetails["sha256:pg"] = types.ImageInspect{ID: "sha256:pg", RepoDigests: []string{"postgres@sha256:1"}}
+	snapshot, err = SaveSnapshot(appConfig, project, "seed", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snapshot.Services, []string{"cache", "db"}) {
+		t.Errorf("Unexpected snapshot %+v", snapshot)
+	}
+	// images built locally have no digest
+	if expected := map[string]string{"db": "postgres@sha256:1", "cache": "sha256:redis"}; !reflect.DeepEqual(
+		snapshot.Images, expected) {
+		t.Errorf("Expected the images %v but got %v", expected, snapshot.Images)
+	}
+	// only the running service is stopped and started again
+	args := strings.Join(tc.Args, " ")
+	for _, expected := range []string{
+		"stop db run",
+		"run --rm -v shared-cache:/volume:ro -v /state/dev/snapshots/app/seed:/snapshot alpine:3 tar -czf /snapshot/cache.tar.gz -C /volume .",
+		"run --rm -v dev_pgdata:/volume:ro -v /state/dev/snapshots/app/seed:/snapshot alpine:3 tar -czf /snapshot/pgdata.tar.gz -C /volume .",
+		"start db",
+	} {
+		if !strings.Contains(args, expected) {
+			t.Errorf("Expected %q in the commands run: %s", expected, args)
+		}
+	}
+	if _, err := SaveSnapshot(appConfig, project, "seed", false); err == nil {
+		t.Errorf("Expected an error replacing a snapshot without force")
+	}
+	if _, err := SaveSnapshot(appConfig, project, "seed", true); err != nil {
+		t.Errorf("Unexpected error replacing a snapshot with force: %s", err)
+	}
+	writeFiles(t, fs, map[string]string{
+		"/state/dev/snapshots/app/seed/pgdata.tar.gz": "pgdata",
+		"/state/dev/snapshots/app/seed/cache.tar.gz":  "cache",
+	})
+
+	snapshots, err := ListSnapshots(appConfig, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Name != "seed" || snapshots[0].Size != 11 {
+		t.Errorf("Expected the seed snapshot of 11 bytes but got %+v", snapshots)
+	}
+
+	setup()
+	engine.AddContainer(types.Container{Names: []string{"/dev_db_2"}, State: "exited",
+		Labels: map[string]string{docker.ComposeProjectLabel: "dev", docker.ComposeServiceLabel: "db"}})
+	if _, err := RestoreSnapshot(appConfig, project, "seed"); err != nil {
+		t.Fatal(err)
+	}
+	if args := strings.Join(tc.Args, " "); !strings.Contains(args,
+		"run --rm -v dev_pgdata:/volume -v /state/dev/snapshots/app/seed:/snapshot:ro alpine:3 sh -c") {
+		t.Errorf("Expected the pgdata volume to be restored: %s", args)
+	}
+	if _, err := RestoreSnapshot(appConfig, project, "missing"); err == nil {
+		t.Errorf("Expected an error restoring a snapshot that does not exist")
+	}
+
+	if err := RemoveSnapshot(appConfig, project, "seed"); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, err := ListSnapshots(appConfig, project); err != nil || len(snapshots) != 0 {
+		t.Errorf("Expected no snapshots once removed but got %v (%v)", snapshots, err)
+	}
+}
@@ -0,0 +1,137 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// stateFilename is the name of the file the state is stored in within the
+// state directory.
+const stateFilename = "state.json"
+
+// State is the data dev records locally between runs. It is stored per image
+// prefix, as that is what identifies the projects of a dev configuration to
+// docker.
+type State struct {
+	// Subnets maps the name of a managed network configured with an
+	// automatic subnet to the subnet chosen for it.
+	Subnets map[string]string `json:"subnets,omitempty"`
+	// Builds maps the name of each compose service built by dev to the
+	// record of its last build.
+	Builds map[string]*Build `json:"builds,omitempty"`
+	// Instances maps the name of each instance of the projects that was
+	// brought up to its record. It is only kept in the state of the
+	// default instance.
+	Instances map[string]*Instance `json:"instances,omitempty"`
+
+	filename string
+	fs       afero.Fs
+}
+
+// Build is the record of the build of a compose service.
+type Build struct {
+	// Hash of the build context, Dockerfile and build arguments the
+	// image was built from.
+	Hash string `json:"hash"`
+	// ImageID is the id of the image built.
+	ImageID string `json:"image_id"`
+	// Time the build completed.
+	Time time.Time `json:"time"`
+	// Previous are the images of the earlier builds of the service, most
+	// recent first, so that they can be cleaned up.
+	Previous []*Image `json:"previous,omitempty"`
+}
+
+// Image is an image built by dev.
+type Image struct {
+	ID string `json:"id"`
+	// Time the build of the image completed.
+	Time time.Time `json:"time"`
+}
+
+// Instance is the record of an instance of the projects.
+type Instance struct {
+	// Number distinguishes the instance from the others, the published
+	// host ports of the instance are moved by a multiple of it.
+	Number int `json:"number"`
+	// Directory is the directory of the dev configuration the instance
+	// was last brought up from.
+	Directory string `json:"directory"`
+	// Time the instance was last brought up.
+	Time time.Time `json:"time"`
+}
+
+// BaseDirectory returns the directory dev stores its local state in. This is
+// $DEV_STATE_HOME if set, otherwise the dev directory of $XDG_STATE_HOME,
+// which defaults to ~/.local/state.
+func BaseDirectory() string {
+	if dir := os.Getenv("DEV_STATE_HOME"); dir != "" {
+		return dir
+	}
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		homeDir, _ := homedir.Dir()
+		stateHome = filepath.Join(homeDir, ".local", "state")
+	}
+	return filepath.Join(stateHome, "dev")
+}
+
+// Directory returns the directory in which the state of the projects with
+// the specified image prefix is stored.
+func Directory(imagePrefix string) string {
+	return filepath.Join(BaseDirectory(), imagePrefix)
+}
+
+// Load reads the state of the projects with the specified image prefix. An
+// empty state is returned if none has been saved yet.
+func Load(fs afero.Fs, imagePrefix string) (*State, error) {
+	s := &State{
+		Subnets:   make(map[string]string),
+		Builds:    make(map[string]*Build),
+		Instances: make(map[string]*Instance),
+		filename:  filepath.Join(Directory(imagePrefix), stateFilename),
+		fs:        fs,
+	}
+
+	b, err := afero.ReadFile(fs, s.filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read state from %s", s.filename)
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse state in %s", s.filename)
+	}
+	if s.Subnets == nil {
+		s.Subnets = make(map[string]string)
+	}
+	if s.Builds == nil {
+		s.Builds = make(map[string]*Build)
+	}
+	if s.Instances == nil {
+		s.Instances = make(map[string]*Instance)
+	}
+	return s, nil
+}
+
+// Save writes the state to disk, creating the state directory if required.
+func (s *State) Save() error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize state")
+	}
+	if err := s.fs.MkdirAll(filepath.Dir(s.filename), 0755); err != nil {
+		return errors.Wrapf(err, "failed to create state directory %s", filepath.Dir(s.filename))
+	}
+	if err := afero.WriteFile(s.fs, s.filename, b, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write state to %s", s.filename)
+	}
+	return nil
+}
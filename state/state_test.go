@@ -0,0 +1,65 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"gotest.tools/v3/env"
+)
+
+func TestBaseDirectory(t *testing.T) {
+	defer env.Patch(t, "DEV_STATE_HOME", "")()
+	defer env.Patch(t, "XDG_STATE_HOME", "")()
+	defer env.Patch(t, "HOME", "/home/test")()
+
+	if dir := BaseDirectory(); dir != "/home/test/.local/state/dev" {
+		t.Errorf("Expected default state directory to be /home/test/.local/state/dev but got %s", dir)
+	}
+
+	defer env.Patch(t, "XDG_STATE_HOME", "/var/state")()
+	if dir := BaseDirectory(); dir != "/var/state/dev" {
+		t.Errorf("Expected state directory to be /var/state/dev but got %s", dir)
+	}
+
+	defer env.Patch(t, "DEV_STATE_HOME", "/tmp/dev")()
+	if dir := BaseDirectory(); dir != "/tmp/dev" {
+		t.Errorf("Expected state directory to be /tmp/dev but got %s", dir)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	defer env.Patch(t, "DEV_STATE_HOME", "/home/test/state")()
+	fs := afero.NewMemMapFs()
+
+	s, err := Load(fs, "smallco")
+	if err != nil {
+		t.Fatalf("Unexpected error loading missing state: %s", err)
+	}
+	if len(s.Subnets) != 0 {
+		t.Errorf("Expected no subnets in new state but got %v", s.Subnets)
+	}
+
+	s.Subnets["app-net"] = "10.242.0.0/24"
+	if err := s.Save(); err != nil {
+		t.Fatalf("Unexpected error saving state: %s", err)
+	}
+	if exists, _ := afero.Exists(fs, "/home/test/state/smallco/state.json"); !exists {
+		t.Error("Expected state to be saved to /home/test/state/smallco/state.json")
+	}
+
+	loaded, err := Load(fs, "smallco")
+	if err != nil {
+		t.Fatalf("Unexpected error loading state: %s", err)
+	}
+	if loaded.Subnets["app-net"] != "10.242.0.0/24" {
+		t.Errorf("Expected subnet of app-net to be 10.242.0.0/24 but got '%s'", loaded.Subnets["app-net"])
+	}
+
+	other, err := Load(fs, "bigco")
+	if err != nil {
+		t.Fatalf("Unexpected error loading state: %s", err)
+	}
+	if len(other.Subnets) != 0 {
+		t.Errorf("Expected state to be separate per image prefix but got %v", other.Subnets)
+	}
+}
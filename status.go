@@ -0,0 +1,223 @@
+package dev
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+	"github.com/wish/dev/registry"
+)
+
+const (
+	// StateRunning is the state of a service whose container is running.
+	StateRunning = "running"
+	// StateExited is the state of a service whose container exists but is
+	// not running.
+	StateExited = "exited"
+	// StateMissing is the state of a service or network that has not been
+	// created.
+	StateMissing = "missing"
+	// StateCreated is the state of a network that exists.
+	StateCreated = "created"
+)
+
+// Status is a snapshot of the state of everything managed by dev.
+type Status struct {
+	Projects   []*ProjectStatus  `json:"projects"`
+	Networks   []*NetworkStatus  `json:"networks"`
+	Registries []*RegistryStatus `json:"registries"`
+}
+
+// ProjectStatus is the state of the services of a project.
+type ProjectStatus struct {
+	Name     string           `json:"name"`
+	Services []*ServiceStatus `json:"services"`
+}
+
+// ServiceStatus is the state of the container of a compose service.
+type ServiceStatus struct {
+	Name   string `json:"name"`
+	State  string `json:"state"`
+	Health string `json:"health,omitempty"`
+	// StartedAt is the time the container was started, set only for
+	// running containers.
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	Ports        []string   `json:"ports,omitempty"`
+	Image        string     `json:"image,omitempty"`
+	ImageCreated *time.Time `json:"image_created,omitempty"`
+}
+
+// NetworkStatus is the state of a network managed by dev.
+type NetworkStatus struct {
+	Name    string   `json:"name"`
+	ID      string   `json:"id,omitempty"`
+	State   string   `json:"state"`
+	Driver  string   `json:"driver,omitempty"`
+	Subnets []string `json:"subnets,omitempty"`
+}
+
+// RegistryStatus is the login state of a registry managed by dev.
+type RegistryStatus struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	LoggedIn bool   `json:"logged_in"`
+}
+
+// CollectStatus queries docker for the state of every project, network and
+// registry in the dev configuration.
+func CollectStatus(appConfig *c.Dev) (*Status, error) {
+	status := &Status{}
+
+	containers, err := docker.ComposeContainers(appConfig.GetEngine(), appConfig.ImagePrefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	serviceContainers := make(map[string]types.Container, len(containers))
+	for _, container := range containers {
+		serviceContainers[container.Labels[docker.ComposeServiceLabel]] = container
+	}
+
+	for _, name := range appConfig.ProjectNames() {
+		project := appConfig.Projects[name]
+		projectStatus := &ProjectStatus{Name: project.Name}
+		for _, service := range CreateServiceList(appConfig, project) {
+			serviceStatus := &ServiceStatus{Name: service, State: StateMissing}
+			if container, ok := serviceContainers[service]; ok {
+				if err := fillServiceStatus(appConfig.GetEngine(), serviceStatus, container); err != nil {
+					return nil, err
+				}
+			}
+			projectStatus.Services = append(projectStatus.Services, serviceStatus)
+		}
+		status.Projects = append(status.Projects, projectStatus)
+	}
+
+	networks, err := appConfig.GetEngine().NetworkList()
+	if err != nil {
+		return nil, err
+	}
+	networkMap := make(map[string]types.NetworkResource, len(networks))
+	for _, network := range networks {
+		networkMap[network.Name] = network
+	}
+	for _, name := range appConfig.NetworkNames() {
+		networkStatus := &NetworkStatus{Name: appConfig.NetworkName(name), State: StateMissing}
+		if network, ok := networkMap[networkStatus.Name]; ok {
+			networkStatus.State = StateCreated
+			networkStatus.ID = network.ID
+			networkStatus.Driver = network.Driver
+			for _, config := range network.IPAM.Config {
+				networkStatus.Subnets = append(networkStatus.Subnets, config.Subnet)
+			}
+		}
+		status.Networks = append(status.Networks, networkStatus)
+	}
+
+	for _, name := range appConfig.RegistryNames() {
+		reg := appConfig.Registries[name]
+		loggedIn, err := registry.LoggedIn(reg.URL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to check login state of %s", name)
+		}
+		status.Registries = append(status.Registries, &RegistryStatus{
+			Name:     name,
+			URL:      reg.URL,
+			LoggedIn: loggedIn,
+		})
+	}
+
+	return status, nil
+}
+
+// fillServiceStatus sets the fields of the service status from the state of
+// its container.
+func fillServiceStatus(engine docker.Engine, status *ServiceStatus, container types.Container) error {
+	status.State = StateExited
+	status.Image = container.Image
+	for _, port := range container.Ports {
+		if port.PublicPort == 0 {
+			continue
+		}
+		status.Ports = append(status.Ports,
+			fmt.Sprintf("%s:%d->%d/%s", port.IP, port.PublicPort, port.PrivatePort, port.Type))
+	}
+
+	info, err := engine.ContainerInspect(container.ID)
+	if err != nil {
+		return err
+	}
+	if info.State != nil {
+		if info.State.Running {
+			status.State = StateRunning
+			if started, err := time.Parse(time.RFC3339Nano, info.State.StartedAt); err == nil {
+				status.StartedAt = &started
+			}
+		}
+		if info.State.Health != nil {
+			status.Health = info.State.Health.Status
+		}
+	}
+
+	image, err := engine.ImageInspect(container.ImageID)
+	if err == nil {
+		if created, err := time.Parse(time.RFC3339Nano, image.Created); err == nil {
+			status.ImageCreated = &created
+		}
+	}
+	return nil
+}
+
+// since formats the time elapsed since t in a human readable form.
+func since(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return units.HumanDuration(time.Since(*t))
+}
+
+// Print writes the status as a set of human readable tables.
+func (s *Status) Print(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(w, "PROJECT\tSERVICE\tSTATE\tHEALTH\tUPTIME\tPORTS\tIMAGE AGE")
+	for _, project := range s.Projects {
+		for _, service := range project.Services {
+			health := service.Health
+			if health == "" {
+				health = "-"
+			}
+			ports := strings.Join(service.Ports, ", ")
+			if ports == "" {
+				ports = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", project.Name, service.Name,
+				service.State, health, since(service.StartedAt), ports, since(service.ImageCreated))
+		}
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "NETWORK\tSTATE\tDRIVER\tSUBNETS\tID")
+	for _, network := range s.Networks {
+		id := network.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", network.Name, network.State, network.Driver,
+			strings.Join(network.Subnets, ", "), id)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "REGISTRY\tLOGGED IN\tURL")
+	for _, reg := range s.Registries {
+		fmt.Fprintf(w, "%s\t%t\t%s\n", reg.Name, reg.LoggedIn, reg.URL)
+	}
+
+	w.Flush()
+}
@@ -0,0 +1,89 @@
+package dev
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	c "github.com/wish/dev/config"
+	"github.com/wish/dev/docker"
+)
+
+func TestCollectStatus(t *testing.T) {
+	project := &c.Project{Name: "app", Directory: "/home/test",
+		DockerComposeFilenames: []string{"/home/test/docker-compose.yml"}}
+	appConfig, engine := testConfig(t, map[string]string{
+		"/home/test/docker-compose.yml": `version: "3.6"
+services:
+  web:
+    image: nginx
+  db:
+    image: postgres
+  worker:
+    image: worker
+`,
+	}, project)
+	appConfig.Networks["shared"] = &types.NetworkCreate{}
+	appConfig.Networks["backend"] = &types.NetworkCreate{}
+
+	labels := func(service string) map[string]string {
+		return map[string]string{docker.ComposeProjectLabel: "dev", docker.ComposeServiceLabel: service}
+	}
+	engine.AddContainer(types.Container{ID: "web", Names: []string{"/dev_web_1"}, State: "running",
+		Labels: labels("web"), Image: "nginx", ImageID: "sha256:nginx",
+		Ports: []types.Port{{IP: "0.0.0.0", PublicPort: 8080, PrivatePort: 80, Type: "tcp"}, {PrivatePort: 443, Type: "tcp"}}})
+	engine.AddContainer(types.Container{ID: "db", Names: []string{"/dev_db_1"}, State: "exited",
+		Labels: labels("db"), Image: "postgres", ImageID: "sha256:postgres"})
+	// containers of other compose projects are not services of the project
+	engine.AddContainer(types.Container{ID: "other", Names: []string{"/other_worker_1"}, State: "running",
+		Labels: map[string]string{docker.ComposeProjectLabel: "other", docker.ComposeServiceLabel: "worker"}})
+	engine.ImageDetails["sha256:nginx"] = types.ImageInspect{ID: "sha256:nginx", Created: "2020-01-02T03:04:05Z"}
+	sharedID := engine.AddNetwork("shared", types.NetworkCreate{Driver: "bridge",
+		IPAM: &network.IPAM{Config: []network.IPAMConfig{{Subnet: "10.242.0.0/24"}}}})
+
+	status, err := CollectStatus(appConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Projects) != 1 || status.Projects[0].Name != "app" {
+		t.Fatalf("Expected the status of the app project, got %+v", status.Projects)
+	}
+	services := make(map[string]*ServiceStatus)
+	for _, service := range status.Projects[0].Services {
+		services[service.Name] = service
+	}
+	if len(services) != 3 {
+		t.Fatalf("Expected the status of every service, got %+v", status.Projects[0].Services)
+	}
+	web, db, worker := services["web"], services["db"], services["worker"]
+	if web.State != StateRunning || web.Image != "nginx" || web.ImageCreated == nil ||
+		!reflect.DeepEqual(web.Ports, []string{"0.0.0.0:8080->80/tcp"}) {
+		t.Errorf("Expected web to be running with its published port, got %+v", web)
+	}
+	if db.State != StateExited || db.StartedAt != nil || db.ImageCreated != nil {
+		t.Errorf("Expected db to be exited, got %+v", db)
+	}
+	if worker.State != StateMissing || worker.Image != "" {
+		t.Errorf("Expected worker to be missing, got %+v", worker)
+	}
+
+	expected := []*NetworkStatus{
+		{Name: "backend", State: StateMissing},
+		{Name: "shared", ID: sharedID, State: StateCreated, Driver: "bridge", Subnets: []string{"10.242.0.0/24"}},
+	}
+	if !reflect.DeepEqual(status.Networks, expected) {
+		t.Errorf("Expected networks %+v, got %+v", expected, status.Networks)
+	}
+
+	var out bytes.Buffer
+	status.Print(&out)
+	printed := strings.Join(strings.Fields(out.String()), " ")
+	for _, expected := range []string{"app worker missing - - - -", "backend missing"} {
+		if !strings.Contains(printed, expected) {
+			t.Errorf("Expected %q in the status, got:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package dev
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+)
+
+// Route is an entry of the routing table of the host.
+type Route struct {
+	Interface   string
+	Destination *net.IPNet
+}
+
+// SubnetConflict is a subnet of a network managed by dev that overlaps with
+// the subnet of another network or a route of the host.
+type SubnetConflict struct {
+	Network string
+	Subnet  string
+	// Other describes what the subnet conflicts with.
+	Other       string
+	OtherSubnet string
+}
+
+func (sc SubnetConflict) String() string {
+	return fmt.Sprintf("network %s subnet %s overlaps with %s subnet %s", sc.Network, sc.Subnet, sc.Other, sc.OtherSubnet)
+}
+
+// parseRoutes parses a routing table in the format of /proc/net/route, where
+// addresses are little endian hex encoded.
+func parseRoutes(r io.Reader) ([]Route, error) {
+	routes := []Route{}
+	scanner := bufio.NewScanner(r)
+	first := true
+	for scanner.Scan() {
+		if first {
+			// skip the header
+			first = false
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		dest, err := parseHexIP(fields[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid route destination %s", fields[1])
+		}
+		mask, err := parseHexIP(fields[7])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid route mask %s", fields[7])
+		}
+		routes = append(routes, Route{
+			Interface:   fields[0],
+			Destination: &net.IPNet{IP: dest, Mask: net.IPMask(mask.To4())},
+		})
+	}
+	return routes, scanner.Err()
+}
+
+func parseHexIP(s string) (net.IP, error) {
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(byte(v), byte(v>>8), byte(v>>16), byte(v>>24)).To4(), nil
+}
+
+// isDockerInterface reports whether the network interface is a bridge created
+// by docker. Routes through these belong to docker networks, which are
+// checked against directly.
+func isDockerInterface(name string) bool {
+	return name == "docker0" || strings.HasPrefix(name, "br-")
+}
+
+func subnetsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// managedSubnets returns the parsed subnets of a network managed by dev.
+// Subnets that are not set or cannot be parsed are skipped.
+func managedSubnets(network *types.NetworkCreate) []*net.IPNet {
+	subnets := []*net.IPNet{}
+	if network == nil || network.IPAM == nil {
+		return subnets
+	}
+	for _, config := range network.IPAM.Config {
+		if _, subnet, err := net.ParseCIDR(config.Subnet); err == nil {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets
+}
+
+// FindSubnetConflicts checks the subnets of the named networks managed by dev
+// against each other, the existing docker networks and the routes of the host.
+// Docker networks with the same name as a managed network are the managed
+// network itself and are not checked.
+func FindSubnetConflicts(names []string, managed map[string]*types.NetworkCreate,
+	networks []types.NetworkResource, routes []Route) []SubnetConflict {
+
+	conflicts := []SubnetConflict{}
+	for i, name := range names {
+		for _, subnet := range managedSubnets(managed[name]) {
+			for _, otherName := range names[i+1:] {
+				for _, other := range managedSubnets(managed[otherName]) {
+					if subnetsOverlap(subnet, other) {
+						conflicts = append(conflicts, SubnetConflict{name, subnet.String(),
+							"managed network " + otherName, other.String()})
+					}
+				}
+			}
+
+			for _, network := range networks {
+				if _, ok := managed[network.Name]; ok {
+					continue
+				}
+				for _, config := range network.IPAM.Config {
+					_, other, err := net.ParseCIDR(config.Subnet)
+					if err == nil && subnetsOverlap(subnet, other) {
+						conflicts = append(conflicts, SubnetConflict{name, subnet.String(),
+							"docker network " + network.Name, other.String()})
+					}
+				}
+			}
+
+			for _, route := range routes {
+				ones, _ := route.Destination.Mask.Size()
+				if ones == 0 || isDockerInterface(route.Interface) {
+					continue
+				}
+				if subnetsOverlap(subnet, route.Destination) {
+					conflicts = append(conflicts, SubnetConflict{name, subnet.String(),
+						"host route on " + route.Interface, route.Destination.String()})
+				}
+			}
+		}
+	}
+	return conflicts
+}
+
+// ipToUint32 converts an IPv4 address to an integer so subnets can be
+// stepped through.
+func ipToUint32(ip net.IP) uint32 {
+	ip = ip.To4()
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func uint32ToIP(v uint32) net.IP {
+	return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).To4()
+}
+
+// AllocateSubnet returns the first subnet with the specified prefix length
+// within the subnets of the pool that does not overlap with any of the used
+// subnets.
+func AllocateSubnet(pool []string, prefix int, used []*net.IPNet) (*net.IPNet, error) {
+	for _, cidr := range pool {
+		_, poolNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid subnet pool %s", cidr)
+		}
+		if poolNet.IP.To4() == nil {
+			return nil, errors.Errorf("subnet pool %s is not an IPv4 subnet", cidr)
+		}
+		poolOnes, _ := poolNet.Mask.Size()
+		if prefix < poolOnes || prefix > 32 {
+			return nil, errors.Errorf("subnet prefix length %d does not fit in subnet pool %s", prefix, cidr)
+		}
+
+		mask := net.CIDRMask(prefix, 32)
+		step := uint64(1) << uint(32-prefix)
+		start := uint64(ipToUint32(poolNet.IP))
+		end := start + uint64(1)<<uint(32-poolOnes)
+		for ip := start; ip < end; ip += step {
+			candidate := &net.IPNet{IP: uint32ToIP(uint32(ip)), Mask: mask}
+			free := true
+			for _, subnet := range used {
+				if subnetsOverlap(candidate, subnet) {
+					free = false
+					break
+				}
+			}
+			if free {
+				return candidate, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("no free /%d subnet left in subnet pool %s", prefix, strings.Join(pool, ", "))
+}
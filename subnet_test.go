@@ -0,0 +1,134 @@
+package dev
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+const procNetRoute = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0100A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0000A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+tun0	000010AC	00000000	0001	0	0	50	0000FFFF	0	0	0
+docker0	000011AC	00000000	0001	0	0	0	0000FFFF	0	0	0
+`
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := parseRoutes(strings.NewReader(procNetRoute))
+	if err != nil {
+		t.Fatalf("Unexpected error parsing routes: %s", err)
+	}
+
+	expected := []struct {
+		Interface   string
+		Destination string
+	}{
+		{"eth0", "0.0.0.0/0"},
+		{"eth0", "192.168.0.0/24"},
+		{"tun0", "172.16.0.0/16"},
+		{"docker0", "172.17.0.0/16"},
+	}
+	if len(routes) != len(expected) {
+		t.Fatalf("Expected %d routes but got %d", len(expected), len(routes))
+	}
+	for i, route := range routes {
+		if route.Interface != expected[i].Interface {
+			t.Errorf("Expected route %d interface to be %s but got %s", i, expected[i].Interface, route.Interface)
+		}
+		if route.Destination.String() != expected[i].Destination {
+			t.Errorf("Expected route %d destination to be %s but got %s", i, expected[i].Destination, route.Destination)
+		}
+	}
+}
+
+func newNetworkCreate(subnet string) *types.NetworkCreate {
+	return &types.NetworkCreate{
+		Driver: "bridge",
+		IPAM: &network.IPAM{
+			Driver: "default",
+			Config: []network.IPAMConfig{{Subnet: subnet}},
+		},
+	}
+}
+
+func TestFindSubnetConflicts(t *testing.T) {
+	routes, err := parseRoutes(strings.NewReader(procNetRoute))
+	if err != nil {
+		t.Fatalf("Unexpected error parsing routes: %s", err)
+	}
+
+	managed := map[string]*types.NetworkCreate{
+		"app-net":   newNetworkCreate("173.16.242.0/16"),
+		"other-net": newNetworkCreate("173.16.10.0/24"),
+		"vpn-net":   newNetworkCreate("172.16.5.0/24"),
+		"fine-net":  newNetworkCreate("10.200.0.0/24"),
+		"auto-net":  newNetworkCreate("auto"),
+	}
+	networks := []types.NetworkResource{
+		{Name: "app-net", IPAM: network.IPAM{Config: []network.IPAMConfig{{Subnet: "173.16.0.0/16"}}}},
+		{Name: "bridge", IPAM: network.IPAM{Config: []network.IPAMConfig{{Subnet: "172.17.0.0/16"}}}},
+		{Name: "someone-elses", IPAM: network.IPAM{Config: []network.IPAMConfig{{Subnet: "10.200.0.128/25"}}}},
+	}
+	names := []string{"app-net", "auto-net", "fine-net", "other-net", "vpn-net"}
+
+	expected := []string{
+		"network app-net subnet 173.16.0.0/16 overlaps with managed network other-net subnet 173.16.10.0/24",
+		"network fine-net subnet 10.200.0.0/24 overlaps with docker network someone-elses subnet 10.200.0.128/25",
+		"network vpn-net subnet 172.16.5.0/24 overlaps with host route on tun0 subnet 172.16.0.0/16",
+	}
+
+	conflicts := FindSubnetConflicts(names, managed, networks, routes)
+	if len(conflicts) != len(expected) {
+		t.Fatalf("Expected %d conflicts but got %d: %v", len(expected), len(conflicts), conflicts)
+	}
+	for i, conflict := range conflicts {
+		if conflict.String() != expected[i] {
+			t.Errorf("Expected conflict '%s' but got '%s'", expected[i], conflict)
+		}
+	}
+}
+
+func TestAllocateSubnet(t *testing.T) {
+	parse := func(cidrs ...string) []*net.IPNet {
+		subnets := []*net.IPNet{}
+		for _, cidr := range cidrs {
+			_, subnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			subnets = append(subnets, subnet)
+		}
+		return subnets
+	}
+
+	tests := []struct {
+		Pool     []string
+		Prefix   int
+		Used     []*net.IPNet
+		Expected string
+	}{
+		{[]string{"10.242.0.0/16"}, 24, parse(), "10.242.0.0/24"},
+		{[]string{"10.242.0.0/16"}, 24, parse("10.242.0.0/24", "10.242.1.128/25"), "10.242.2.0/24"},
+		{[]string{"10.242.0.0/16"}, 24, parse("10.0.0.0/8"), ""},
+		{[]string{"10.242.0.0/23", "192.168.100.0/24"}, 24, parse("10.242.0.0/23"), "192.168.100.0/24"},
+		{[]string{"10.242.0.0/16"}, 12, parse(), ""},
+	}
+
+	for _, test := range tests {
+		subnet, err := AllocateSubnet(test.Pool, test.Prefix, test.Used)
+		if test.Expected == "" {
+			if err == nil {
+				t.Errorf("Expected error allocating from %v but got %s", test.Pool, subnet)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error allocating from %v: %s", test.Pool, err)
+		} else if subnet.String() != test.Expected {
+			t.Errorf("Expected subnet %s from %v but got %s", test.Expected, test.Pool, subnet)
+		}
+	}
+}
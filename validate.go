@@ -0,0 +1,146 @@
+package dev
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/wish/dev/compose"
+	c "github.com/wish/dev/config"
+)
+
+// ComposeProblem is an inconsistency between the docker-compose files of a
+// project and the dev configuration.
+type ComposeProblem struct {
+	Project string
+	// File is the compose file the problem is in, the first compose file of
+	// the project when it is not in a particular one.
+	File string
+	// Service is the name of the compose service the problem is with, if
+	// any.
+	Service string
+	// Fatal is set for problems that prevent the project from being
+	// brought up, rather than from functioning fully.
+	Fatal   bool
+	Message string
+	// Fix is what resolves the problem.
+	Fix string
+}
+
+func (p *ComposeProblem) String() string {
+	if p.Service == "" {
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	}
+	return fmt.Sprintf("%s: service %s %s", p.File, p.Service, p.Message)
+}
+
+// ValidateCompose cross-checks the docker-compose files of the projects with
+// the dev configuration. The networks managed by dev that services use must be
+// declared external and be dependencies of the project, directly or through
+// the projects it depends on, so that dev creates them. The project must have
+// a service of the same name, which the sh command runs in.
+func ValidateCompose(appConfig *c.Dev) []*ComposeProblem {
+	problems := []*ComposeProblem{}
+	for _, projectName := range appConfig.ProjectNames() {
+		project := appConfig.Projects[projectName]
+		files := project.DockerComposeFilenames
+		if len(files) == 0 {
+			continue
+		}
+		composeConfig, err := parseCompose(appConfig, project)
+		if err != nil {
+			problems = append(problems, &ComposeProblem{Project: projectName, File: files[0], Fatal: true,
+				Message: err.Error(), Fix: "Fix the docker-compose files of project " + projectName})
+			continue
+		}
+		dependencies := projectDependencies(appConfig, projectName)
+
+		services := make(map[string]bool)
+		sort.Slice(composeConfig.Services, func(i, j int) bool {
+			return composeConfig.Services[i].Name < composeConfig.Services[j].Name
+		})
+		for _, service := range composeConfig.Services {
+			services[service.Name] = true
+			serviceFile := declaringFile(appConfig, files, "services", service.Name)
+
+			names := make([]string, 0, len(service.Networks))
+			for key := range service.Networks {
+				names = append(names, key)
+			}
+			sort.Strings(names)
+			for _, key := range names {
+				network, declared := composeConfig.Networks[key]
+				name := key
+				if declared && network.Name != "" {
+					name = network.Name
+				}
+				if _, managed := appConfig.Networks[name]; !managed {
+					continue
+				}
+
+				if !declared || !network.External.External {
+					file := serviceFile
+					if declared {
+						file = declaringFile(appConfig, files, "networks", key)
+					}
+					problems = append(problems, &ComposeProblem{
+						Project: projectName, File: file, Service: service.Name, Fatal: true,
+						Message: fmt.Sprintf("uses network %s, which dev manages, but it is not declared external", key),
+						Fix: fmt.Sprintf("Declare it in %s with\nnetworks:\n  %s:\n    external: true",
+							file, key),
+					})
+				}
+				if !dependencies[name] {
+					problems = append(problems, &ComposeProblem{
+						Project: projectName, File: serviceFile, Service: service.Name, Fatal: true,
+						Message: fmt.Sprintf("uses network %s, but project %s does not depend on it", name,
+							projectName),
+						Fix: fmt.Sprintf("Add %s to the depends_on of project %s in the dev configuration in %s",
+							name, projectName, project.Directory),
+					})
+				}
+			}
+		}
+
+		if !services[projectName] {
+			problems = append(problems, &ComposeProblem{
+				Project: projectName, File: files[len(files)-1],
+				Message: fmt.Sprintf("project %s has no service named %s, which 'dev %s sh' runs in",
+					projectName, projectName, projectName),
+				Fix: fmt.Sprintf("Name the main service of project %s %s, or add a service of that name",
+					projectName, projectName),
+			})
+		}
+	}
+	return problems
+}
+
+// projectDependencies returns the names of the projects, networks and
+// registries the project depends on, directly or through the projects it
+// depends on.
+func projectDependencies(appConfig *c.Dev, projectName string) map[string]bool {
+	dependencies := make(map[string]bool)
+	pending := []string{projectName}
+	for len(pending) > 0 {
+		project, ok := appConfig.Projects[pending[0]]
+		pending = pending[1:]
+		if !ok {
+			continue
+		}
+		for _, dep := range project.Dependencies {
+			if !dependencies[dep] {
+				dependencies[dep] = true
+				pending = append(pending, dep)
+			}
+		}
+	}
+	return dependencies
+}
+
+// declaringFile returns the compose file that declares the service or
+// network, the first of the files when none does.
+func declaringFile(appConfig *c.Dev, files []string, section string, name string) string {
+	if file := compose.DeclaringFile(appConfig.GetFs(), files, section, name); file != "" {
+		return file
+	}
+	return files[0]
+}
@@ -0,0 +1,79 @@
+package dev
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/spf13/afero"
+	c "github.com/wish/dev/config"
+)
+
+func TestValidateCompose(t *testing.T) {
+	appConfig := c.NewConfig()
+	appConfig.SetFs(afero.NewMemMapFs())
+	appConfig.Networks["app-net"] = &types.NetworkCreate{}
+	appConfig.Networks["data-net"] = &types.NetworkCreate{}
+	writeFiles(t, appConfig.GetFs(), map[string]string{
+		"/home/app/docker-compose.yml": `services:
+  app:
+    image: app
+    networks:
+      - app-net
+      - backend
+      - default
+  worker:
+    image: worker
+    networks:
+      - app-net
+networks:
+  app-net:
+  backend:
+    external: true
+    name: data-net
+`,
+		"/home/db/docker-compose.yml": `services:
+  postgres:
+    image: postgres
+    networks:
+      - data-net
+networks:
+  data-net:
+    external: true
+`,
+		"/home/api/docker-compose.yml": `services:
+  api:
+    image: api
+    networks:
+      - data-net
+networks:
+  data-net:
+    external: true
+`,
+	})
+	appConfig.Projects["app"] = &c.Project{Name: "app", Directory: "/home/app",
+		DockerComposeFilenames: []string{"/home/app/docker-compose.yml"}, Dependencies: []string{"api"}}
+	appConfig.Projects["db"] = &c.Project{Name: "db", Directory: "/home/db",
+		DockerComposeFilenames: []string{"/home/db/docker-compose.yml"}, Dependencies: []string{"data-net"}}
+	appConfig.Projects["api"] = &c.Project{Name: "api", Directory: "/home/api",
+		DockerComposeFilenames: []string{"/home/api/docker-compose.yml"}, Dependencies: []string{"db"}}
+
+	got := []string{}
+	for _, problem := range ValidateCompose(appConfig) {
+		got = append(got, problem.String())
+		if problem.Fix == "" {
+			t.Errorf("Expected a fix for %s", problem)
+		}
+	}
+	// api depends on data-net through db and app through api
+	expected := []string{
+		"/home/app/docker-compose.yml: service app uses network app-net, which dev manages, but it is not declared external",
+		"/home/app/docker-compose.yml: service app uses network app-net, but project app does not depend on it",
+		"/home/app/docker-compose.yml: service worker uses network app-net, which dev manages, but it is not declared external",
+		"/home/app/docker-compose.yml: service worker uses network app-net, but project app does not depend on it",
+		"/home/db/docker-compose.yml: project db has no service named db, which 'dev db sh' runs in",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected problems\n%v\ngot\n%v", expected, got)
+	}
+}